@@ -0,0 +1,430 @@
+/******************************************************************
+ *
+ * Alert rules - Threshold rules evaluated against every reading of
+ *      an active sensor, e.g. "barn below 34 for 10 minutes".
+ *      A rule fires once its condition has held for its duration,
+ *      and clears once the value is back past the threshold by the
+ *      hysteresis, so a value hovering at the threshold can't flap.
+ *      Alerts raised during the rule's quiet hours are recorded
+ *      and shown, but not announced.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const defaultRateWindowSecs = 3600 // Readings used for rising/falling rates
+
+var ruleConditions = []string{"above", "below", "rising", "falling"}
+
+type AlertRule struct {
+	Name           string   `json:"Name"`           // Unique name of the rule
+	SensorKey      string   `json:"SensorKey"`      // Sensor watched, "*" for every active sensor
+	Measurement    string   `json:"Measurement"`    // e.g. "temperature_F"
+	Condition      string   `json:"Condition"`      // "above", "below", "rising" or "falling"
+	Threshold      float64  `json:"Threshold"`      // Value, or rate per hour for rising/falling
+	DurationSecs   int      `json:"DurationSecs"`   // Condition must hold this long before the rule fires
+	Hysteresis     float64  `json:"Hysteresis"`     // Alert clears once this far back past the threshold
+	RateWindowSecs int      `json:"RateWindowSecs"` // Readings used for rising/falling, default one hour
+	QuietStart     string   `json:"QuietStart"`     // "22:00", alerts are not announced from QuietStart
+	QuietEnd       string   `json:"QuietEnd"`       // "07:00", until QuietEnd
+	Sinks          []string `json:"Sinks"`          // Names of the notifiers the alert is sent to
+	Disabled       bool     `json:"Disabled"`
+}
+
+type ruleState struct {
+	since   time.Time // When the condition started holding, zero if it isn't
+	firing  bool
+	samples []sample // Recent values for rising/falling rules
+}
+
+var (
+	alertRules     []AlertRule                   // Rules from config.json
+	ruleStates     = make(map[string]*ruleState) // Rule name + ":" + sensor key : state
+	rulesMutex     sync.Mutex
+	alertRulesFlag bool = false // Alert rules window flag. If true, window is open.
+)
+
+// evaluateRules - Check a reading of an active sensor against every alert rule for it
+func evaluateRules(key string, wd WeatherData) {
+	t := wd.Received
+	if t.IsZero() {
+		t = time.Now()
+	}
+	type change struct {
+		source string
+		alert  Alert
+		fire   bool
+	}
+	var changes []change
+
+	rulesMutex.Lock()
+	for _, r := range alertRules {
+		if r.Disabled || (r.SensorKey != key && r.SensorKey != "*") {
+			continue
+		}
+		v, ok := wd.Measurement(r.Measurement)
+		if !ok {
+			continue
+		}
+		st, ok := ruleStates[r.Name+":"+key]
+		if !ok {
+			st = new(ruleState)
+			ruleStates[r.Name+":"+key] = st
+		}
+		value, holds, ends := r.test(st, t, v)
+		source := "rule:" + r.Name + ":" + key
+		switch {
+		case !st.firing && holds:
+			if st.since.IsZero() {
+				st.since = t
+			}
+			if t.Sub(st.since) >= time.Duration(r.DurationSecs)*time.Second {
+				st.firing = true
+				changes = append(changes, change{source, Alert{
+					SensorKey: key,
+					Kind:      "rule",
+					Rule:      r.Name,
+					Message:   fmt.Sprintf("%s: %s %s %s (now %.1f)", r.Name, sensorLabel(key, wd), r.describe(), formatSince(st.since, t), value),
+					Value:     value,
+					Quiet:     r.inQuietHours(t),
+					Sinks:     r.Sinks,
+				}, true})
+			}
+		case !st.firing:
+			st.since = time.Time{}
+		case ends:
+			st.firing = false
+			st.since = time.Time{}
+			changes = append(changes, change{source, Alert{Message: fmt.Sprintf("%s: %s back to %.1f", r.Name, sensorLabel(key, wd), value)}, false})
+		}
+	}
+	rulesMutex.Unlock()
+
+	for _, c := range changes {
+		if c.fire {
+			raiseAlert(c.source, c.alert)
+		} else {
+			clearAlert(c.source, c.alert.Message)
+		}
+	}
+}
+
+// test - Whether the rule's condition holds for a value, and whether a firing rule should clear.
+// Rising and falling rules test the rate per hour over the rule's window instead of the value.
+func (r *AlertRule) test(st *ruleState, t time.Time, v float64) (value float64, holds bool, ends bool) {
+	value = v
+	if r.Condition == "rising" || r.Condition == "falling" {
+		window := time.Duration(r.RateWindowSecs) * time.Second
+		if window <= 0 {
+			window = defaultRateWindowSecs * time.Second
+		}
+		st.samples = append(st.samples, sample{t, v})
+		for len(st.samples) > 1 && t.Sub(st.samples[0].t) > window {
+			st.samples = st.samples[1:]
+		}
+		value = ratePerHour(st.samples)
+		// A rate over a small part of the window is mostly noise
+		if t.Sub(st.samples[0].t) < window/4 {
+			return value, false, false
+		}
+	}
+	switch r.Condition {
+	case "above", "rising":
+		return value, value > r.Threshold, value < r.Threshold-r.Hysteresis
+	case "below":
+		return value, value < r.Threshold, value > r.Threshold+r.Hysteresis
+	case "falling":
+		return value, value < -r.Threshold, value > -r.Threshold+r.Hysteresis
+	}
+	return value, false, false
+}
+
+// inQuietHours - True if t falls in the rule's quiet hours, which may span midnight
+func (r *AlertRule) inQuietHours(t time.Time) bool {
+	start, err1 := time.Parse("15:04", r.QuietStart)
+	end, err2 := time.Parse("15:04", r.QuietEnd)
+	if err1 != nil || err2 != nil || start.Equal(end) {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	s := start.Hour()*60 + start.Minute()
+	e := end.Hour()*60 + end.Minute()
+	if s < e {
+		return now >= s && now < e
+	}
+	return now >= s || now < e
+}
+
+// describe - Condition of the rule in words, e.g. "temperature_F below 34.0"
+func (r *AlertRule) describe() string {
+	switch r.Condition {
+	case "rising", "falling":
+		return fmt.Sprintf("%s %s faster than %.1f/h", r.Measurement, r.Condition, r.Threshold)
+	}
+	return fmt.Sprintf("%s %s %.1f", r.Measurement, r.Condition, r.Threshold)
+}
+
+// validate - Check a rule for mistakes before it is used
+func (r *AlertRule) validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("alert rule has no name")
+	}
+	if strings.Contains(r.Name, ":") { // Rule states are keyed by name:sensor key
+		return fmt.Errorf("alert rule %s: name can't contain ':'", r.Name)
+	}
+	if r.SensorKey == "" {
+		return fmt.Errorf("alert rule %s: no sensor", r.Name)
+	}
+	if !containsString(measurementNames, r.Measurement) {
+		return fmt.Errorf("alert rule %s: unknown measurement %q", r.Name, r.Measurement)
+	}
+	if !containsString(ruleConditions, r.Condition) {
+		return fmt.Errorf("alert rule %s: unknown condition %q", r.Name, r.Condition)
+	}
+	if r.DurationSecs < 0 || r.Hysteresis < 0 || r.RateWindowSecs < 0 {
+		return fmt.Errorf("alert rule %s: duration, hysteresis and rate window can't be negative", r.Name)
+	}
+	for _, q := range []string{r.QuietStart, r.QuietEnd} {
+		if _, err := time.Parse("15:04", q); q != "" && err != nil {
+			return fmt.Errorf("alert rule %s: quiet hours %q are not HH:MM", r.Name, q)
+		}
+	}
+	for _, name := range r.Sinks {
+		if _, ok := findNotifier(name); !ok {
+			return fmt.Errorf("alert rule %s: no notifier named %s", r.Name, name)
+		}
+	}
+	return nil
+}
+
+// sensorLabel - Station and name of a sensor for alert messages
+func sensorLabel(key string, wd WeatherData) string {
+	if wd.SensorName != "" {
+		return wd.Station + " " + wd.SensorName
+	}
+	return key
+}
+
+// formatSince - How long a condition has held, empty if it just started
+func formatSince(since time.Time, t time.Time) string {
+	d := t.Sub(since).Round(time.Minute)
+	if d <= 0 {
+		return ""
+	}
+	return "for " + strings.TrimSuffix(d.String(), "0s")
+}
+
+/******************************************
+ * Alert rule windows
+ ******************************************/
+
+// alertRulesHandler - Opens a window listing the alert rules, with buttons to add and remove rules
+var alertRulesHandler = func() {
+	if alertRulesFlag {
+		return
+	}
+	alertRulesFlag = true
+	rulesWindow := a.NewWindow("Alert Rules")
+	rulesWindow.SetOnClosed(func() {
+		alertRulesFlag = false
+	})
+	list := container.NewVBox()
+	var fill func()
+	fill = func() {
+		list.RemoveAll()
+		rules := copyAlertRules()
+		if len(rules) == 0 {
+			list.Add(widget.NewLabel("No alert rules"))
+		}
+		for _, r := range rules {
+			name := r.Name
+			line := fmt.Sprintf("%s: %s %s", r.Name, r.SensorKey, r.describe())
+			if r.DurationSecs > 0 {
+				line = line + fmt.Sprintf(" for %d s", r.DurationSecs)
+			}
+			if r.QuietStart != "" {
+				line = line + fmt.Sprintf(", quiet %s-%s", r.QuietStart, r.QuietEnd)
+			}
+			list.Add(container.NewHBox(
+				widget.NewButton("Remove", func() {
+					removeAlertRule(name)
+					fill()
+				}),
+				widget.NewLabel(line),
+			))
+		}
+		list.Refresh()
+	}
+	fill()
+	scroller := container.NewVScroll(list)
+	scroller.SetMinSize(fyne.NewSize(700, 250))
+	rulesWindow.SetContent(container.NewBorder(
+		widget.NewButton("New Rule", func() {
+			newAlertRuleForm(fill)
+		}), // top
+		nil,      // bottom
+		nil,      // left
+		nil,      // right
+		scroller, // middle
+	))
+	rulesWindow.Show()
+}
+
+// newAlertRuleForm - Pop up a form for a new alert rule, calling done after it is added
+func newAlertRuleForm(done func()) {
+	ruleWindow := a.NewWindow("New Alert Rule")
+
+	// Sensor choices are shown by station and name, but the rule stores the key
+	sensorChoices := map[string]string{"* (every active sensor)": "*"}
+	options := []string{"* (every active sensor)"}
+	activeSensorsMutex.Lock()
+	for _, key := range sortActiveSensors() {
+		s := activeSensors[key]
+		label := s.Station + " : " + s.Name + " (" + key + ")"
+		sensorChoices[label] = key
+		options = append(options, label)
+	}
+	activeSensorsMutex.Unlock()
+	sort.Strings(options[1:])
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Rule name, e.g. Barn freeze")
+	sensorSelect := widget.NewSelect(options, nil)
+	measurementSelect := widget.NewSelect(measurementNames, nil)
+	measurementSelect.SetSelected("temperature_F")
+	conditionSelect := widget.NewSelect(ruleConditions, nil)
+	conditionSelect.SetSelected("below")
+	thresholdEntry := widget.NewEntry()
+	thresholdEntry.SetPlaceHolder("Threshold, or rate per hour for rising/falling")
+	durationEntry := widget.NewEntry()
+	durationEntry.SetPlaceHolder("Minutes the condition must hold, e.g. 10")
+	hysteresisEntry := widget.NewEntry()
+	hysteresisEntry.SetPlaceHolder("Hysteresis, e.g. 1")
+	quietStartEntry := widget.NewEntry()
+	quietStartEntry.SetPlaceHolder("Quiet hours start HH:MM, optional")
+	quietEndEntry := widget.NewEntry()
+	quietEndEntry.SetPlaceHolder("Quiet hours end HH:MM, optional")
+	var notifierNames []string
+	for _, n := range notifiers {
+		notifierNames = append(notifierNames, n.Name)
+	}
+	sinksCheck := widget.NewCheckGroup(notifierNames, nil)
+
+	ruleWindow.SetContent(container.NewVBox(
+		widget.NewLabel("Describe the condition to alert on, then press Submit to save."),
+		nameEntry,
+		sensorSelect,
+		measurementSelect,
+		conditionSelect,
+		thresholdEntry,
+		durationEntry,
+		hysteresisEntry,
+		quietStartEntry,
+		quietEndEntry,
+		widget.NewLabel("Notify:"),
+		sinksCheck,
+		widget.NewButton("Submit", func() {
+			r := AlertRule{
+				Name:        strings.TrimSpace(nameEntry.Text),
+				SensorKey:   sensorChoices[sensorSelect.Selected],
+				Measurement: measurementSelect.Selected,
+				Condition:   conditionSelect.Selected,
+				QuietStart:  quietStartEntry.Text,
+				QuietEnd:    quietEndEntry.Text,
+				Sinks:       sinksCheck.Selected,
+			}
+			var err error
+			r.Threshold, err = strconv.ParseFloat(thresholdEntry.Text, 64)
+			if err != nil {
+				SetStatus(fmt.Sprintf("Alert rule not saved, threshold %q is not a number", thresholdEntry.Text))
+				return
+			}
+			if durationEntry.Text != "" {
+				minutes, err := strconv.ParseFloat(durationEntry.Text, 64)
+				if err != nil {
+					SetStatus(fmt.Sprintf("Alert rule not saved, duration %q is not a number", durationEntry.Text))
+					return
+				}
+				r.DurationSecs = int(minutes * 60)
+			}
+			if hysteresisEntry.Text != "" {
+				r.Hysteresis, err = strconv.ParseFloat(hysteresisEntry.Text, 64)
+				if err != nil {
+					SetStatus(fmt.Sprintf("Alert rule not saved, hysteresis %q is not a number", hysteresisEntry.Text))
+					return
+				}
+			}
+			if err := addAlertRule(r); err != nil {
+				SetStatus(fmt.Sprintf("Alert rule not saved, %s", err))
+				return
+			}
+			SetStatus(fmt.Sprintf("Added alert rule %s", r.Name))
+			done()
+			ruleWindow.Close()
+		}),
+		widget.NewButton("Cancel", func() {
+			ruleWindow.Close()
+		}),
+	))
+	ruleWindow.Show()
+}
+
+// addAlertRule - Validate and add a rule. Rule names must be unique.
+func addAlertRule(r AlertRule) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	rulesMutex.Lock()
+	defer rulesMutex.Unlock()
+	for _, existing := range alertRules {
+		if existing.Name == r.Name {
+			return fmt.Errorf("there is already a rule named %s", r.Name)
+		}
+	}
+	alertRules = append(alertRules, r)
+	return nil
+}
+
+// copyAlertRules - A copy of the alert rules, to read without holding the lock
+func copyAlertRules() []AlertRule {
+	rulesMutex.Lock()
+	defer rulesMutex.Unlock()
+	return append([]AlertRule(nil), alertRules...)
+}
+
+// removeAlertRule - Remove a rule and forget its state. Its active alerts are cleared.
+func removeAlertRule(name string) {
+	rulesMutex.Lock()
+	for i, r := range alertRules {
+		if r.Name == name {
+			alertRules = append(alertRules[:i], alertRules[i+1:]...)
+			break
+		}
+	}
+	var sources []string
+	for skey := range ruleStates {
+		if strings.HasPrefix(skey, name+":") {
+			delete(ruleStates, skey)
+			sources = append(sources, "rule:"+skey)
+		}
+	}
+	rulesMutex.Unlock()
+	for _, source := range sources {
+		clearAlert(source, "rule "+name+" removed")
+	}
+	SetStatus(fmt.Sprintf("Removed alert rule %s", name))
+}
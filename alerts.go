@@ -0,0 +1,313 @@
+/******************************************************************
+ *
+ * Alerts - Records alerts raised by the dashboard, such as a sensor
+ *          battery going low, a sensor going silent or an alert rule
+ *          firing. Alerts stay active until their condition ends,
+ *          can be acknowledged, and are kept in an alert history.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	maxAlertHistory  = 500 // Oldest alerts are dropped beyond this
+	alertHistoryFile = "alerthistory.json"
+)
+
+type Alert struct {
+	Id           int      `json:"Id"`
+	Time         string   `json:"Time"`
+	SensorKey    string   `json:"SensorKey"`
+	Kind         string   `json:"Kind"` // "battery", "offline" or "rule"
+	Rule         string   `json:"Rule"` // Name of the alert rule that fired, if any
+	Message      string   `json:"Message"`
+	Value        float64  `json:"Value"`
+	Active       bool     `json:"Active"`       // Condition still holds
+	Acknowledged bool     `json:"Acknowledged"` // Someone has seen it
+	Cleared      string   `json:"Cleared"`      // Time the condition ended
+	Quiet        bool     `json:"Quiet"`        // Raised during quiet hours, not announced
+	Sinks        []string `json:"Sinks"`        // Notifiers the alert is sent to
+}
+
+var (
+	alertHistory []*Alert                  // Alerts raised, oldest first
+	activeAlerts = make(map[string]*Alert) // Alert source, e.g. "offline:<sensor key>" : alert
+	nextAlertId  int                       // Id given to the next alert
+	alertMutex   sync.Mutex
+)
+
+var (
+	alertBanner       = canvas.NewText("", color.RGBA{R: 247, G: 19, B: 2, A: 255})
+	alertBannerBox    = container.NewHBox() // Top of main window, empty unless alerts are active
+	activeAlertsList  = container.NewVBox()
+	alertHistoryList  = container.NewVBox()
+	activeAlertsPopup fyne.Window
+	activeAlertsFlag  bool = false // Active alerts window flag. If true, window is open.
+	alertHistoryFlag  bool = false // Alert history window flag. If true, window is open.
+)
+
+// raiseAlert - Record an alert and announce it. An alert with a source stays active until
+// clearAlert is called for that source; raising it again while active does nothing.
+// Returns the alert recorded, or nil if it was already active.
+func raiseAlert(source string, al Alert) *Alert {
+	if al.Time == "" {
+		al.Time = time.Now().Local().Format(YYYYMMDD + " " + HHMMSS24h)
+	}
+	alertMutex.Lock()
+	if _, ok := activeAlerts[source]; ok && source != "" {
+		alertMutex.Unlock()
+		return nil
+	}
+	nextAlertId++
+	al.Id = nextAlertId
+	rec := &al
+	if source != "" {
+		rec.Active = true
+		activeAlerts[source] = rec
+	}
+	alertHistory = append(alertHistory, rec)
+	if len(alertHistory) > maxAlertHistory {
+		alertHistory = alertHistory[len(alertHistory)-maxAlertHistory:]
+	}
+	alertMutex.Unlock()
+
+	if !rec.Quiet {
+		SetStatus(fmt.Sprintf("%s : ALERT %s", rec.Time, rec.Message))
+		notifyAlert(*rec, rec.Sinks)
+	}
+	refreshAlertDisplays()
+	return rec
+}
+
+// clearAlert - End the active alert from a source, if there is one
+func clearAlert(source string, message string) {
+	alertMutex.Lock()
+	al, ok := activeAlerts[source]
+	if ok {
+		delete(activeAlerts, source)
+		al.Active = false
+		al.Cleared = time.Now().Local().Format(YYYYMMDD + " " + HHMMSS24h)
+	}
+	alertMutex.Unlock()
+	if !ok {
+		return
+	}
+	SetStatus(fmt.Sprintf("%s : Alert cleared, %s", al.Cleared, message))
+	refreshAlertDisplays()
+}
+
+// acknowledgeAlert - Mark an alert as seen. Returns false if there is no such alert.
+func acknowledgeAlert(id int) bool {
+	alertMutex.Lock()
+	found := false
+	for _, al := range alertHistory {
+		if al.Id == id {
+			al.Acknowledged = true
+			found = true
+		}
+	}
+	alertMutex.Unlock()
+	if found {
+		refreshAlertDisplays()
+	}
+	return found
+}
+
+// listActiveAlerts - Copies of the active alerts, oldest first
+func listActiveAlerts() []Alert {
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+	list := make([]Alert, 0, len(activeAlerts))
+	for _, al := range activeAlerts {
+		list = append(list, *al)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+// listAlertHistory - Copies of the alert history, newest first
+func listAlertHistory() []Alert {
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+	list := make([]Alert, 0, len(alertHistory))
+	for i := len(alertHistory) - 1; i >= 0; i-- {
+		list = append(list, *alertHistory[i])
+	}
+	return list
+}
+
+// formatAlert - One line description of an alert for the alert windows
+func formatAlert(al Alert) string {
+	str := al.Time + "  " + al.Message
+	if al.Acknowledged {
+		str = str + "  [acknowledged]"
+	}
+	if al.Quiet {
+		str = str + "  [quiet hours]"
+	}
+	if al.Cleared != "" {
+		str = str + "  [cleared " + al.Cleared + "]"
+	}
+	return str
+}
+
+/******************************************
+ * Alert persistence
+ ******************************************/
+
+// saveAlertHistory - Write the alert history so it survives a restart
+func saveAlertHistory() error {
+	alertMutex.Lock()
+	data, err := json.MarshalIndent(alertHistory, "", "    ")
+	alertMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(alertHistoryFile, data, 0644)
+}
+
+// loadAlertHistory - Read the alert history saved by a previous run. Alerts that were
+// active are restored as active so they are cleared when their condition ends.
+func loadAlertHistory() error {
+	data, err := os.ReadFile(alertHistoryFile)
+	if err != nil {
+		return err
+	}
+	var history []*Alert
+	if err := json.Unmarshal(data, &history); err != nil {
+		return err
+	}
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+	alertHistory = history
+	for _, al := range history {
+		if al.Id > nextAlertId {
+			nextAlertId = al.Id
+		}
+		if al.Active {
+			activeAlerts[alertSource(*al)] = al
+		}
+	}
+	return nil
+}
+
+// alertSource - Source key of an alert, as used by raiseAlert and clearAlert
+func alertSource(al Alert) string {
+	if al.Kind == "rule" {
+		return "rule:" + al.Rule + ":" + al.SensorKey
+	}
+	return al.Kind + ":" + al.SensorKey
+}
+
+/******************************************
+ * Alert displays
+ ******************************************/
+
+// refreshAlertDisplays - Update the alert banner and any open alert windows
+func refreshAlertDisplays() {
+	if a == nil {
+		return
+	}
+	active := listActiveAlerts()
+	unacked := 0
+	latest := ""
+	for _, al := range active {
+		if !al.Acknowledged {
+			unacked++
+			latest = al.Message
+		}
+	}
+	alertBannerBox.RemoveAll()
+	if unacked > 0 {
+		alertBanner.Text = fmt.Sprintf("%d ACTIVE ALERT(S): %s", unacked, latest)
+		alertBanner.TextSize = 16
+		alertBanner.TextStyle = fyne.TextStyle{Bold: true}
+		alertBannerBox.Add(widget.NewButton("Show Alerts", activeAlertsHandler))
+		alertBannerBox.Add(alertBanner)
+	}
+	alertBannerBox.Refresh()
+	if activeAlertsFlag {
+		fillActiveAlertsList(active)
+	}
+	if alertHistoryFlag {
+		fillAlertHistoryList()
+	}
+}
+
+func fillActiveAlertsList(active []Alert) {
+	activeAlertsList.RemoveAll()
+	if len(active) == 0 {
+		activeAlertsList.Add(widget.NewLabel("No active alerts"))
+	}
+	for _, al := range active {
+		id := al.Id
+		ack := widget.NewButton("Acknowledge", func() {
+			acknowledgeAlert(id)
+		})
+		if al.Acknowledged {
+			ack.Disable()
+		}
+		activeAlertsList.Add(container.NewHBox(ack, widget.NewLabel(formatAlert(al))))
+	}
+	activeAlertsList.Refresh()
+}
+
+func fillAlertHistoryList() {
+	alertHistoryList.RemoveAll()
+	for _, al := range listAlertHistory() {
+		alertHistoryList.Add(widget.NewLabel(formatAlert(al)))
+	}
+	alertHistoryList.Refresh()
+}
+
+// activeAlertsHandler - Opens a window listing the active alerts, with acknowledge buttons
+func activeAlertsHandler() {
+	if activeAlertsFlag {
+		activeAlertsPopup.Show()
+		return
+	}
+	activeAlertsFlag = true
+	fillActiveAlertsList(listActiveAlerts())
+	activeAlertsPopup = a.NewWindow("Active Alerts")
+	activeAlertsPopup.SetOnClosed(func() {
+		activeAlertsFlag = false
+	})
+	scroller := container.NewVScroll(activeAlertsList)
+	scroller.SetMinSize(fyne.NewSize(700, 250))
+	activeAlertsPopup.SetContent(scroller)
+	activeAlertsPopup.Show()
+}
+
+// alertHistoryHandler - Opens a window listing the alert history, newest first
+var alertHistoryHandler = func() {
+	if alertHistoryFlag {
+		return
+	}
+	alertHistoryFlag = true
+	fillAlertHistoryList()
+	historyWindow := a.NewWindow("Alert History")
+	historyWindow.SetOnClosed(func() {
+		alertHistoryFlag = false
+	})
+	scroller := container.NewVScroll(alertHistoryList)
+	scroller.SetMinSize(fyne.NewSize(700, 350))
+	historyWindow.SetContent(scroller)
+	historyWindow.Show()
+}
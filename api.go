@@ -0,0 +1,319 @@
+/******************************************************************
+ *
+ * API - Optional local HTTP server with JSON endpoints, for home
+ *      automation scripts. It listens on APIAddr, e.g.
+ *      "127.0.0.1:8080", and is off when APIAddr is "". There is
+ *      no authentication, so keep it on a trusted address.
+ *          GET    /api/sensors                  active sensors, latest values and stats
+ *          GET    /api/sensors/available        sensors heard but not active
+ *          GET    /api/sensors/{key}            one active sensor
+ *          PATCH  /api/sensors/{key}            {"Name": "...", "Location": "..."}
+ *          POST   /api/sensors/{key}/activate   make an available sensor active
+ *          POST   /api/sensors/{key}/deactivate remove a sensor from the active sensors
+ *          GET    /api/subscriptions
+ *          POST   /api/subscriptions            {"Topic": "...", "Station": "..."}
+ *          DELETE /api/subscriptions/{key}
+ *          GET    /api/broker                   brokers and whether connected
+ *          GET    /api/alerts                   active alerts
+ *          GET    /api/alerts/history           all alerts, newest first
+ *      Changes go through the same functions as the Sensors and
+ *      Subscriptions menus. Errors are {"Error": "..."}.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiShutdownTimeout = 5 * time.Second
+
+var (
+	apiServer      *http.Server // Running server, nil if none
+	apiServerMutex sync.Mutex
+)
+
+// An active or available sensor with the latest value of each measurement
+type apiSensor struct {
+	Sensor
+	Latest map[string]apiValue `json:"Latest"` // Measurement : latest value in the history
+}
+
+// A measurement value and when it arrived
+type apiValue struct {
+	Value float64   `json:"Value"`
+	Time  time.Time `json:"Time"`
+}
+
+// A broker, without its password
+type apiBroker struct {
+	Path string `json:"Path"`
+	Port int    `json:"Port"`
+	Uid  string `json:"Uid"`
+}
+
+// Broker status
+type apiBrokerStatus struct {
+	Connected bool        `json:"Connected"`
+	Brokers   []apiBroker `json:"Brokers"`
+}
+
+// Changes of a sensor, fields left out are kept
+type apiSensorEdit struct {
+	Name     *string `json:"Name"`
+	Location *string `json:"Location"`
+}
+
+// startAPI - Serve the API on APIAddr in the background, if set
+func startAPI() {
+	if settings.APIAddr == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", settings.APIAddr)
+	if err != nil {
+		SetStatus(fmt.Sprintf("Unable to start the API on %s: %s", settings.APIAddr, err))
+		return
+	}
+	srv := &http.Server{Handler: newAPIHandler(), ReadHeaderTimeout: 10 * time.Second}
+	apiServerMutex.Lock()
+	apiServer = srv
+	apiServerMutex.Unlock()
+	SetStatus(fmt.Sprintf("API listening on %s", ln.Addr()))
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			SetStatus(fmt.Sprintf("API stopped: %s", err))
+		}
+	}()
+}
+
+// stopAPI - Stop the API, letting requests in progress finish
+func stopAPI() {
+	apiServerMutex.Lock()
+	srv := apiServer
+	apiServer = nil
+	apiServerMutex.Unlock()
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
+
+// newAPIHandler - Routes of the API
+func newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/sensors", apiListSensors)
+	mux.HandleFunc("GET /api/sensors/available", apiListAvailable)
+	mux.HandleFunc("GET /api/sensors/{key}", apiGetSensor)
+	mux.HandleFunc("PATCH /api/sensors/{key}", apiEditSensor)
+	mux.HandleFunc("POST /api/sensors/{key}/activate", apiActivateSensor)
+	mux.HandleFunc("POST /api/sensors/{key}/deactivate", apiDeactivateSensor)
+	mux.HandleFunc("GET /api/subscriptions", apiListSubscriptions)
+	mux.HandleFunc("POST /api/subscriptions", apiAddSubscription)
+	mux.HandleFunc("DELETE /api/subscriptions/{key}", apiRemoveSubscription)
+	mux.HandleFunc("GET /api/broker", apiBrokerInfo)
+	mux.HandleFunc("GET /api/alerts", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, listActiveAlerts())
+	})
+	mux.HandleFunc("GET /api/alerts/history", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, listAlertHistory())
+	})
+	return mux
+}
+
+// writeJSON - Send v as JSON with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	enc.Encode(v)
+}
+
+// writeAPIError - Send an error message with status
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"Error": err.Error()})
+}
+
+// newAPISensor - A copy of a sensor with the latest value of each measurement it has stats for.
+// The history may read files, so s must be a copy made under its table's lock, not held now.
+func newAPISensor(s Sensor) apiSensor {
+	as := apiSensor{Sensor: s, Latest: make(map[string]apiValue)}
+	for name := range s.Stats {
+		if smp, ok := historyStore.latest(s.Key, name); ok {
+			as.Latest[name] = apiValue{smp.v, smp.t}
+		}
+	}
+	return as
+}
+
+// apiSensors - Copies of a table of sensors, sorted by key
+func apiSensors(sensors map[string]*Sensor, mutex *sync.Mutex) []apiSensor {
+	mutex.Lock()
+	copies := make([]Sensor, 0, len(sensors))
+	for _, s := range sensors {
+		copies = append(copies, copySensor(s))
+	}
+	mutex.Unlock()
+	list := make([]apiSensor, 0, len(copies))
+	for _, s := range copies {
+		list = append(list, newAPISensor(s))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// copySensor - A copy of a sensor with its own maps and slices, so it can be read without the lock
+func copySensor(s *Sensor) Sensor {
+	c := *s
+	c.Stats = make(map[string]*MeasurementStats, len(s.Stats))
+	for name, ms := range s.Stats {
+		msc := *ms
+		c.Stats[name] = &msc
+	}
+	if s.Calibration != nil {
+		c.Calibration = make(map[string]Calibration, len(s.Calibration))
+		for name, cal := range s.Calibration {
+			c.Calibration[name] = cal
+		}
+	}
+	if s.Rain != nil {
+		rain := *s.Rain
+		rain.Recent = append([]RainEvent(nil), s.Rain.Recent...)
+		c.Rain = &rain
+	}
+	c.BatteryHistory = append([]BatteryEvent(nil), s.BatteryHistory...)
+	c.DegreeDays.Days = append([]DailySummary(nil), s.DegreeDays.Days...)
+	return c
+}
+
+func apiListSensors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiSensors(activeSensors, &activeSensorsMutex))
+}
+
+func apiListAvailable(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiSensors(availableSensors, &availableSensorsMutex))
+}
+
+func apiGetSensor(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	activeSensorsMutex.Lock()
+	s, ok := activeSensors[key]
+	var c Sensor
+	if ok {
+		c = copySensor(s)
+	}
+	activeSensorsMutex.Unlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no active sensor %s", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPISensor(c))
+}
+
+func apiEditSensor(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	var edit apiSensorEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	err := updateSensor(key, func(s *Sensor) {
+		if edit.Name != nil {
+			s.Name = *edit.Name
+		}
+		if edit.Location != nil {
+			s.Location = *edit.Location
+		}
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	SetStatus(fmt.Sprintf("Updated sensor %s from the API", key))
+	apiGetSensor(w, r)
+}
+
+func apiActivateSensor(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	activeSensorsMutex.Lock()
+	active := checkSensor(key, activeSensors)
+	activeSensorsMutex.Unlock()
+	if active {
+		writeAPIError(w, http.StatusConflict, fmt.Errorf("sensor %s is already active", key))
+		return
+	}
+	if err := activateSensor(key); err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	apiGetSensor(w, r)
+}
+
+func apiDeactivateSensor(w http.ResponseWriter, r *http.Request) {
+	if err := deactivateSensor(r.PathValue("key")); err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	list := sortedSubscriptions()
+	if list == nil {
+		list = []Subscription{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func apiAddSubscription(w http.ResponseWriter, r *http.Request) {
+	var m Subscription
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	m.Topic = strings.TrimSpace(m.Topic)
+	if m.Topic == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("no topic"))
+		return
+	}
+	if m.Station == "" {
+		m.Station = strings.Split(m.Topic, "/")[0]
+	}
+	m.Key = addSubscription(m.Topic, m.Station)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func apiRemoveSubscription(w http.ResponseWriter, r *http.Request) {
+	key, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("subscription key %q is not a number", r.PathValue("key")))
+		return
+	}
+	if err := removeSubscription(key); err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiBrokerInfo(w http.ResponseWriter, r *http.Request) {
+	status := apiBrokerStatus{Connected: Client != nil && Client.IsConnected(), Brokers: []apiBroker{}}
+	for _, b := range brokers {
+		status.Brokers = append(status.Brokers, apiBroker{b.Path, b.Port, b.Uid})
+	}
+	sort.Slice(status.Brokers, func(i, j int) bool { return status.Brokers[i].Path < status.Brokers[j].Path })
+	writeJSON(w, http.StatusOK, status)
+}
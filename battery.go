@@ -0,0 +1,131 @@
+/******************************************************************
+ *
+ * Battery - Tracks the battery state reported by active sensors,
+ *           keeps a history of battery changes and raises an alert
+ *           when a battery goes low
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const maxBatteryHistory = 50 // Battery changes kept per sensor
+
+var batteryWindowFlag bool = false // Battery status window flag. If true, window is open.
+
+// updateBattery - Record the battery state of a reading on its sensor.
+// Reports whether the state changed and whether it changed from ok to low.
+func (s *Sensor) updateBattery(wd WeatherData) (changed bool, wentLow bool) {
+	if !wd.HasBattery {
+		return false, false
+	}
+	ok := wd.Battery_ok != 0
+	if s.BatteryKnown && s.BatteryOk == ok {
+		return false, false
+	}
+	wentLow = s.BatteryKnown && s.BatteryOk && !ok
+	s.BatteryKnown = true
+	s.BatteryOk = ok
+	s.BatteryHistory = append(s.BatteryHistory, BatteryEvent{Time: wd.Time, Ok: ok})
+	if len(s.BatteryHistory) > maxBatteryHistory {
+		s.BatteryHistory = s.BatteryHistory[len(s.BatteryHistory)-maxBatteryHistory:]
+	}
+	return true, wentLow
+}
+
+// BatteryLow - True if the sensor's last reported battery state was low
+func (s *Sensor) BatteryLow() bool {
+	return s.BatteryKnown && !s.BatteryOk
+}
+
+// checkBattery - Update an active sensor's battery state and alert if it went low
+func checkBattery(key string, wd WeatherData) {
+	activeSensorsMutex.Lock()
+	s := activeSensors[key]
+	wasKnown := s.BatteryKnown
+	changed, wentLow := s.updateBattery(wd)
+	ok := s.BatteryOk
+	name := s.Name
+	activeSensorsMutex.Unlock()
+
+	switch {
+	case wentLow:
+		raiseAlert("battery:"+key, Alert{
+			Time:      wd.Time,
+			SensorKey: key,
+			Kind:      "battery",
+			Message:   fmt.Sprintf("Low battery on sensor %s (%s)", name, key),
+			Sinks:     settings.AlertSinks,
+		})
+	case changed && wasKnown && ok:
+		clearAlert("battery:"+key, fmt.Sprintf("battery ok again on sensor %s (%s)", name, key))
+	case changed && !ok:
+		SetStatus(fmt.Sprintf("Sensor %s (%s) reports a low battery", name, key))
+	}
+}
+
+// formatBatteryState - Battery state for displays
+func formatBatteryState(s *Sensor) string {
+	switch {
+	case !s.BatteryKnown:
+		return "unknown"
+	case s.BatteryOk:
+		return "ok"
+	default:
+		return "LOW"
+	}
+}
+
+// batteryStatusHandler - Opens a window listing the battery state and battery changes
+// of all active sensors, low batteries first
+var batteryStatusHandler = func() {
+	if batteryWindowFlag {
+		return
+	}
+	batteryWindowFlag = true
+
+	activeSensorsMutex.Lock()
+	keys := sortActiveSensors()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return activeSensors[keys[i]].BatteryLow() && !activeSensors[keys[j]].BatteryLow()
+	})
+	list := container.NewVBox()
+	for _, key := range keys {
+		s := activeSensors[key]
+		line := fmt.Sprintf("%s : %s  battery %s", s.Station, s.Name, formatBatteryState(s))
+		for _, e := range s.BatteryHistory {
+			state := "low"
+			if e.Ok {
+				state = "ok"
+			}
+			line = line + fmt.Sprintf("   [%s %s]", e.Time, state)
+		}
+		list.Add(widget.NewLabel(line))
+	}
+	activeSensorsMutex.Unlock()
+
+	batteryWindow := a.NewWindow("Sensor Battery Status")
+	batteryWindow.SetOnClosed(func() {
+		batteryWindowFlag = false
+	})
+	scroller := container.NewVScroll(list)
+	scroller.SetMinSize(fyne.NewSize(600, 300))
+	batteryWindow.SetContent(container.NewBorder(
+		widget.NewButton("Close", func() {
+			batteryWindow.Close()
+		}), // top
+		nil,      // bottom
+		nil,      // left
+		nil,      // right
+		scroller, // middle
+	))
+	batteryWindow.Show()
+}
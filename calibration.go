@@ -0,0 +1,128 @@
+/******************************************************************
+ *
+ * Calibration - Offset and scale for each measurement of a sensor,
+ *      to correct sensors that read high or low against a reference.
+ *      A calibrated value is raw * Scale + Offset. Readings are
+ *      calibrated as they arrive, before the derived values, widgets,
+ *      statistics and logs, and keep the raw values in Raw.
+ *      Pressures are calibrated in the unit the sensor reports, wind
+ *      speeds in mph.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Correction of one measurement
+type Calibration struct {
+	Offset float64 `json:"Offset"`
+	Scale  float64 `json:"Scale"` // 0 is taken as 1
+}
+
+// Measurements that can be calibrated
+var calibrationNames = []string{
+	"temperature_F",
+	"humidity",
+	"pressure_hPa",
+	"pressure_inHg",
+	"pressure_kPa",
+	"wind_avg_mi_h",
+	"wind_max_mi_h",
+	"wind_dir_deg",
+	"rain_in",
+	"rain_mm",
+}
+
+// apply - Calibrated value of raw value v
+func (c Calibration) apply(v float64) float64 {
+	scale := c.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return v*scale + c.Offset
+}
+
+// identity - Whether the calibration leaves values as they are
+func (c Calibration) identity() bool {
+	return c.Offset == 0 && (c.Scale == 0 || c.Scale == 1)
+}
+
+// calibrate - Correct the measurements of a reading by the sensor's calibration,
+// keeping the raw values in the reading's Raw
+func (s *Sensor) calibrate(wd *WeatherData) {
+	for name, c := range s.Calibration {
+		v, ok := wd.Measurement(name)
+		if !ok || c.identity() {
+			continue
+		}
+		cv := c.apply(v)
+		switch name {
+		case "humidity":
+			cv = math.Min(math.Max(cv, 0), 100)
+		case "wind_avg_mi_h", "wind_max_mi_h", "rain_in", "rain_mm":
+			cv = math.Max(cv, 0)
+		case "wind_dir_deg":
+			cv = math.Mod(cv+360, 360)
+		}
+		if wd.Raw == nil {
+			wd.Raw = make(map[string]float64)
+		}
+		wd.Raw[name] = v
+		wd.SetMeasurement(name, cv)
+	}
+}
+
+// parseCalibration - Calibration from the offset and scale typed in the edit form, empty for none
+func parseCalibration(offsetText string, scaleText string) (Calibration, error) {
+	var c Calibration
+	var err error
+	if t := strings.TrimSpace(offsetText); t != "" {
+		if c.Offset, err = strconv.ParseFloat(t, 64); err != nil {
+			return c, fmt.Errorf("offset %q is not a number", offsetText)
+		}
+	}
+	if t := strings.TrimSpace(scaleText); t != "" {
+		if c.Scale, err = strconv.ParseFloat(t, 64); err != nil || c.Scale <= 0 {
+			return c, fmt.Errorf("scale %q is not a positive number", scaleText)
+		}
+	}
+	return c, nil
+}
+
+// formatCalibration - Summary of a sensor's calibration for the edit form
+func formatCalibration(cal map[string]Calibration) string {
+	var parts []string
+	for _, name := range calibrationNames {
+		if c, ok := cal[name]; ok && !c.identity() {
+			parts = append(parts, fmt.Sprintf("%s * %g %+g", name, c.apply(1)-c.apply(0), c.Offset))
+		}
+	}
+	if len(parts) == 0 {
+		return "Calibration: none"
+	}
+	return "Calibration: " + strings.Join(parts, ", ")
+}
+
+// formatRaw - Raw values of calibrated measurements, empty if none were calibrated
+func formatRaw(wd WeatherData) string {
+	if len(wd.Raw) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(wd.Raw))
+	for name := range wd.Raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%.2f", name, wd.Raw[name]))
+	}
+	return ", raw: " + strings.Join(parts, "|")
+}
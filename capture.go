@@ -0,0 +1,344 @@
+/******************************************************************
+ *
+ * Capture - Record every raw MQTT message to a capture file, and
+ *      replay a capture through messageHandler. A capture is JSON
+ *      Lines, one message per line:
+ *          {"time":"2024-06-18T15:30:00.123456789-07:00",
+ *           "broker":"tcp://pi:1883","topic":"Home/rtl_433/...",
+ *           "payload":"{\"model\":...}"}
+ *      with the payload as a string, or base64 in payload_b64 if it
+ *      isn't UTF-8. A replay keeps the recorded receive times, so the
+ *      same capture always gives the same readings, or shifts them to
+ *      start now for demos. It waits between messages as they were
+ *      received, divided by the speed, or not at all at speed 0.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// A raw message of a capture file
+type captureRecord struct {
+	Time       string `json:"time"` // Receive time, RFC 3339 with nanoseconds
+	Broker     string `json:"broker,omitempty"`
+	Topic      string `json:"topic"`
+	Payload    string `json:"payload,omitempty"`
+	PayloadB64 string `json:"payload_b64,omitempty"` // Payload that isn't UTF-8
+}
+
+// The capture file being written, if any
+type captureWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+	path  string
+}
+
+var (
+	capture                 = new(captureWriter)
+	captureReplayFlag  bool = false // Capture and replay window flag. If true, window is open.
+	replaySpeedChoices      = []string{"Real time", "10x", "60x", "As fast as possible"}
+	replaySpeeds            = map[string]float64{"Real time": 1, "10x": 10, "60x": 60, "As fast as possible": 0}
+)
+
+// start - Append captured messages to the file at path
+func (cw *captureWriter) start(path string) error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.file != nil {
+		cw.file.Close()
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		cw.file = nil
+		return err
+	}
+	cw.file, cw.path = f, path
+	return nil
+}
+
+// stop - Sync and close the capture file
+func (cw *captureWriter) stop() {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.file != nil {
+		cw.file.Sync()
+		cw.file.Close()
+		cw.file = nil
+	}
+}
+
+// capturing - Whether messages are being captured
+func (cw *captureWriter) capturing() bool {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	return cw.file != nil
+}
+
+// record - Write a message to the capture file, if capturing
+func (cw *captureWriter) record(broker string, topic string, payload []byte, received time.Time) error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(newCaptureRecord(broker, topic, payload, received))
+	if err != nil {
+		return err
+	}
+	_, err = cw.file.Write(append(data, '\n'))
+	return err
+}
+
+// newCaptureRecord - Capture record of a message
+func newCaptureRecord(broker string, topic string, payload []byte, received time.Time) captureRecord {
+	rec := captureRecord{Time: received.Format(time.RFC3339Nano), Broker: broker, Topic: topic}
+	if utf8.Valid(payload) {
+		rec.Payload = string(payload)
+	} else {
+		rec.PayloadB64 = base64.StdEncoding.EncodeToString(payload)
+	}
+	return rec
+}
+
+// captureBroker - URL of the broker a client is connected to, "" if unknown
+func captureBroker(client mqtt.Client) string {
+	if client == nil {
+		return ""
+	}
+	r := client.OptionsReader()
+	var servers []string
+	for _, u := range r.Servers() {
+		servers = append(servers, u.String())
+	}
+	return strings.Join(servers, ",")
+}
+
+// A captured message being replayed, as paho delivers one
+type replayMessage struct {
+	topic    string
+	payload  []byte
+	received time.Time
+}
+
+func (m *replayMessage) Duplicate() bool   { return false }
+func (m *replayMessage) Qos() byte         { return 0 }
+func (m *replayMessage) Retained() bool    { return false }
+func (m *replayMessage) Topic() string     { return m.topic }
+func (m *replayMessage) MessageID() uint16 { return 0 }
+func (m *replayMessage) Payload() []byte   { return m.payload }
+func (m *replayMessage) Ack()              {}
+
+// A replay of a capture. Speed 1 is real time, 0 as fast as possible.
+type replayer struct {
+	speed   float64
+	shift   bool                // Shift the receive times so the first message is received now
+	sleep   func(time.Duration) // Waits between messages, until halted, unless a test sets it
+	now     func() time.Time    // Time of the replay's start, time.Now unless a test sets it
+	handler func(mqtt.Message)  // Takes each message, messageHandler unless a test sets it
+	stop    chan struct{}       // Closed by halt to stop the replay
+	halted  sync.Once
+}
+
+// Result of a replay
+type replayResult struct {
+	Messages int
+	Bad      int // Lines that aren't capture records
+}
+
+func newReplayer(speed float64, shift bool) *replayer {
+	rp := &replayer{
+		speed:   speed,
+		shift:   shift,
+		now:     time.Now,
+		handler: func(msg mqtt.Message) { messageHandler(nil, msg) },
+		stop:    make(chan struct{}),
+	}
+	rp.sleep = func(d time.Duration) {
+		select {
+		case <-time.After(d):
+		case <-rp.stop:
+		}
+	}
+	return rp
+}
+
+// halt - Stop the replay before its next message
+func (rp *replayer) halt() {
+	rp.halted.Do(func() { close(rp.stop) })
+}
+
+// replay - Pass the messages of a capture to the handler in order, waiting between them by the speed
+func (rp *replayer) replay(r io.Reader) (replayResult, error) {
+	var result replayResult
+	var first, last time.Time
+	offset := time.Duration(0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		select {
+		case <-rp.stop:
+			return result, nil
+		default:
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		msg, err := parseCaptureLine(line)
+		if err != nil {
+			result.Bad++
+			continue
+		}
+		if first.IsZero() {
+			first = msg.received
+			if rp.shift {
+				offset = rp.now().Sub(first)
+			}
+		} else if rp.speed > 0 && msg.received.After(last) {
+			rp.sleep(time.Duration(float64(msg.received.Sub(last)) / rp.speed))
+		}
+		if msg.received.After(last) {
+			last = msg.received
+		}
+		msg.received = msg.received.Add(offset)
+		rp.handler(msg)
+		result.Messages++
+	}
+	return result, scanner.Err()
+}
+
+// parseCaptureLine - Message of a line of a capture
+func parseCaptureLine(line string) (*replayMessage, error) {
+	var rec captureRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, rec.Time)
+	if err != nil {
+		return nil, err
+	}
+	payload := []byte(rec.Payload)
+	if rec.PayloadB64 != "" {
+		if payload, err = base64.StdEncoding.DecodeString(rec.PayloadB64); err != nil {
+			return nil, err
+		}
+	}
+	return &replayMessage{topic: rec.Topic, payload: payload, received: t}, nil
+}
+
+// replayFile - Replay the capture in the file at path
+func (rp *replayer) replayFile(path string) (replayResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return replayResult{}, err
+	}
+	defer f.Close()
+	return rp.replay(f)
+}
+
+// captureReplayHandler - Opens a window to capture raw messages and replay captures
+var captureReplayHandler = func() {
+	if captureReplayFlag {
+		return
+	}
+	captureReplayFlag = true
+	captureEntry := widget.NewEntry()
+	captureEntry.SetText(settings.CaptureFile)
+	var captureButton *widget.Button
+	captureButton = widget.NewButton("Start Capture", func() {
+		if capture.capturing() {
+			capture.stop()
+			captureButton.SetText("Start Capture")
+			SetStatus("Stopped capturing raw messages")
+			return
+		}
+		path := strings.TrimSpace(captureEntry.Text)
+		if err := capture.start(path); err != nil {
+			SetStatus(fmt.Sprintf("Unable to capture to %s: %s", path, err))
+			return
+		}
+		settings.CaptureFile = path
+		captureButton.SetText("Stop Capture")
+		SetStatus(fmt.Sprintf("Capturing raw messages to %s", path))
+	})
+	if capture.capturing() {
+		captureButton.SetText("Stop Capture")
+	}
+	replayEntry := widget.NewEntry()
+	replayEntry.SetText(settings.CaptureFile)
+	speedSelect := widget.NewSelect(replaySpeedChoices, nil)
+	speedSelect.SetSelected(replaySpeedChoices[0])
+	shiftCheck := widget.NewCheck("Replay as if received now", nil)
+	shiftCheck.SetChecked(true)
+	var rp *replayer // Replay running, nil if none
+	var rpMutex sync.Mutex
+	running := func() *replayer {
+		rpMutex.Lock()
+		defer rpMutex.Unlock()
+		return rp
+	}
+	var replayButton *widget.Button
+	replayButton = widget.NewButton("Replay", func() {
+		rpMutex.Lock()
+		if r := rp; r != nil {
+			rpMutex.Unlock()
+			r.halt()
+			return
+		}
+		path := strings.TrimSpace(replayEntry.Text)
+		rp = newReplayer(replaySpeeds[speedSelect.Selected], shiftCheck.Checked)
+		started := rp
+		rpMutex.Unlock()
+		replayButton.SetText("Stop Replay")
+		SetStatus(fmt.Sprintf("Replaying %s", path))
+		go func() {
+			result, err := started.replayFile(path)
+			if err != nil {
+				SetStatus(fmt.Sprintf("Replay of %s stopped: %s", path, err))
+			} else {
+				SetStatus(fmt.Sprintf("Replayed %d messages of %s, %d bad lines", result.Messages, path, result.Bad))
+			}
+			rpMutex.Lock()
+			rp = nil
+			rpMutex.Unlock()
+			replayButton.SetText("Replay")
+		}()
+	})
+	crWindow := a.NewWindow("Capture and Replay")
+	crWindow.SetOnClosed(func() {
+		captureReplayFlag = false
+		if r := running(); r != nil {
+			r.halt()
+		}
+	})
+	crWindow.SetContent(container.NewVBox(
+		widget.NewLabel("Capture every raw message to"),
+		captureEntry,
+		captureButton,
+		widget.NewSeparator(),
+		widget.NewLabel("Replay the capture in"),
+		replayEntry,
+		speedSelect,
+		shiftCheck,
+		replayButton,
+	))
+	crWindow.Resize(fyne.NewSize(400, 300))
+	crWindow.Show()
+}
@@ -57,6 +57,8 @@ type sensorDisplayWidget struct {
 	dateAdded         string
 	latestUpdate      string
 	hasHumidity       bool
+	lastHeard         string
+	signal            string
 	check             bool
 	renderer          *sensorDisplayWidgetRenderer
 	sync.Mutex
@@ -74,6 +76,8 @@ type sensorDisplayWidgetRenderer struct {
 	dateAdded    *canvas.Text
 	latestUpdate *canvas.Text
 	hasHumidity  *canvas.Text
+	lastHeard    *canvas.Text
+	signal       *canvas.Text
 	objects      []fyne.CanvasObject
 }
 
@@ -148,6 +152,8 @@ func chooseSensors(title string, sensors map[string]*Sensor, action Action) {
 		temp.dateAdded = a.DateAdded
 		temp.latestUpdate = a.LastEdit
 		temp.hasHumidity = a.HasHumidity
+		temp.lastHeard = formatLastHeard(a)
+		temp.signal = formatSensorSignal(a)
 		sensorSelectDisp.Add(&temp)
 		choices = append(choices, &temp)
 		temp.Unlock()
@@ -263,13 +269,13 @@ func editSensors() {
 		s_Hide_widget.SetChecked(s.Hide)
 		s_HasHumidity_widget := widget.NewCheck("Check if sensor also provides humidity", showHumidityHandler)
 		s_HasHumidity_widget.SetChecked(s.HasHumidity)
-		s_ResetHiLo_widget := widget.NewCheck("Reset Hi/Lo", func(value bool) {
-			if value {
-				s.HighTemp = s.Temp
-				s.LowTemp = s.Temp
-				s.HighHumidity = s.Humidity
-				s.LowHumidity = s.Humidity
-			}
+		s_Outdoor_widget := widget.NewCheck("Check if sensor is outdoors, for degree days", nil)
+		s_Outdoor_widget.SetChecked(s.Outdoor)
+		s_Interval_widget := widget.NewEntry()
+		s_Interval_widget.SetText(strconv.Itoa(s.ExpectedInterval))
+		s_Interval_label := widget.NewLabel(fmt.Sprintf("Expected seconds between readings, 0 = learn (learned %.0f s)", s.LearnedInterval))
+		s_ResetHiLo_widget := widget.NewCheck("Reset today's Hi/Lo", func(value bool) {
+			resetHiLoFlag = value
 		})
 		s_ResetHiLo_widget.SetChecked(false)
 		s_Model_widget := widget.NewLabel("")
@@ -291,6 +297,9 @@ func editSensors() {
 			s_Location_widget,
 			s_Hide_widget,
 			s_HasHumidity_widget,
+			s_Outdoor_widget,
+			s_Interval_label,
+			s_Interval_widget,
 			s_ResetHiLo_widget,
 			s_Model_widget,
 			s_Id_widget,
@@ -305,12 +314,15 @@ func editSensors() {
 				s.Location = s_Location_widget.Text
 				s.Hide = s_Hide_widget.Checked
 				s.HasHumidity = s_HasHumidity_widget.Checked
+				s.Outdoor = s_Outdoor_widget.Checked
+				if interval, err := strconv.Atoi(s_Interval_widget.Text); err == nil && interval >= 0 {
+					s.ExpectedInterval = interval
+				} else {
+					SetStatus(fmt.Sprintf("Ignoring expected interval %q, not a whole number of seconds", s_Interval_widget.Text))
+				}
 				s.LastEdit = st
 				if resetHiLoFlag {
-					s.HighTemp = s.Temp
-					s.LowTemp = s.Temp
-					s.HighHumidity = s.Humidity
-					s.LowHumidity = s.Humidity
+					s.resetTodayStats()
 				}
 				activeSensors[key] = s
 				// If dashboard is visible, reload it since we changed a sensor in a widget
@@ -343,18 +355,61 @@ func editSensors() {
 func addSensors() {
 	for _, key := range resultKeys {
 		if checkSensor(key, availableSensors) && !availableSensors[key].Hide {
-			availableSensorsMutex.Lock()
-			s := *availableSensors[key]
-			availableSensorsMutex.Unlock()
-			activeSensorsMutex.Lock()
-			activeSensors[key] = &s
-			activeSensorsMutex.Unlock()
-			reloadDashboard()
-			SetStatus(fmt.Sprintf("Added sensor to active sensors: %s", key))
+			activateSensor(key)
 		}
 	}
 }
 
+// activateSensor - Copy a sensor from the available sensors to the active sensors
+func activateSensor(key string) error {
+	availableSensorsMutex.Lock()
+	as, ok := availableSensors[key]
+	if !ok {
+		availableSensorsMutex.Unlock()
+		return fmt.Errorf("no available sensor %s", key)
+	}
+	s := *as
+	availableSensorsMutex.Unlock()
+	activeSensorsMutex.Lock()
+	activeSensors[key] = &s
+	activeSensorsMutex.Unlock()
+	reloadDashboard()
+	SetStatus(fmt.Sprintf("Added sensor to active sensors: %s", key))
+	return nil
+}
+
+// deactivateSensor - Remove a sensor from the active sensors
+func deactivateSensor(key string) error {
+	activeSensorsMutex.Lock()
+	if !checkSensor(key, activeSensors) { // Be sure the sensor is there
+		activeSensorsMutex.Unlock()
+		return fmt.Errorf("no active sensor %s", key)
+	}
+	delete(activeSensors, key)
+	activeSensorsMutex.Unlock()
+	reloadDashboard()
+	SetStatus(fmt.Sprintf("Removed sensor from active sensors: %s", key))
+	return nil
+}
+
+// updateSensor - Change an active sensor with edit and stamp the time of the edit
+func updateSensor(key string, edit func(s *Sensor)) error {
+	activeSensorsMutex.Lock()
+	s, ok := activeSensors[key]
+	if !ok {
+		activeSensorsMutex.Unlock()
+		return fmt.Errorf("no active sensor %s", key)
+	}
+	edit(s)
+	s.LastEdit = time.Now().Local().Format(YYYYMMDD + " " + HHMMSS24h)
+	activeSensorsMutex.Unlock()
+	// If dashboard is visible, reload it since we changed a sensor in a widget
+	if dashFlag {
+		reloadDashboard()
+	}
+	return nil
+}
+
 /**********************************************************
 *
 * removeSensors()
@@ -362,13 +417,7 @@ func addSensors() {
 **********************************************************/
 func removeSensors() {
 	for _, key := range resultKeys {
-		if checkSensor(key, activeSensors) { // Be sure the sensor is there
-			activeSensorsMutex.Lock()
-			delete(activeSensors, key)
-			activeSensorsMutex.Unlock()
-			reloadDashboard()
-			SetStatus(fmt.Sprintf("Removed sensor from active sensors: %s", key))
-		}
+		deactivateSensor(key)
 	}
 }
 
@@ -422,6 +471,12 @@ func newSensorDisplayWidgetRenderer(sdw *sensorDisplayWidget) fyne.WidgetRendere
 	dateAdded := canvas.NewText("Date added:   "+sdw.dateAdded, sensorDisplayWidgetForegroundColor)
 	dateAdded.TextSize = 11
 
+	lh := canvas.NewText(sdw.lastHeard, sensorDisplayWidgetForegroundColor)
+	lh.TextSize = 10
+
+	sig := canvas.NewText(sdw.signal, sensorDisplayWidgetForegroundColor)
+	sig.TextSize = 10
+
 	r.widget = sdw
 	r.frame = frame
 	r.checkbox = check
@@ -433,10 +488,12 @@ func newSensorDisplayWidgetRenderer(sdw *sensorDisplayWidget) fyne.WidgetRendere
 	r.latestUpdate = latestUpdate
 	r.dateAdded = dateAdded
 	r.hasHumidity = hi
+	r.lastHeard = lh
+	r.signal = sig
 	if showCheckBoxesFlag {
-		r.objects = append(r.objects, frame, check, st, sn, mo, id, ch, hi, latestUpdate, dateAdded)
+		r.objects = append(r.objects, frame, check, st, sn, mo, id, ch, hi, latestUpdate, dateAdded, lh, sig)
 	} else {
-		r.objects = append(r.objects, frame, st, sn, mo, id, ch, hi, latestUpdate, dateAdded)
+		r.objects = append(r.objects, frame, st, sn, mo, id, ch, hi, latestUpdate, dateAdded, lh, sig)
 	}
 
 	r.widget.ExtendBaseWidget(sdw)
@@ -462,6 +519,8 @@ func (r *sensorDisplayWidgetRenderer) Layout(size fyne.Size) {
 	r.id.Move(fyne.NewPos(xpos, ypos))
 	xpos = xpos + r.id.Size().Width + 80
 	r.channel.Move(fyne.NewPos(xpos, ypos))
+	r.lastHeard.Move(fyne.NewPos(120, sensorDisplayWidgetSizeY-r.lastHeard.TextSize-4))
+	r.signal.Move(fyne.NewPos(120+r.lastHeard.MinSize().Width+20, sensorDisplayWidgetSizeY-r.signal.TextSize-4))
 	r.dateAdded.Move(fyne.NewPos((sensorDisplayWidgetSizeX-r.latestUpdate.MinSize().Width)-5, (sensorDisplayWidgetSizeY-r.dateAdded.TextSize)*0.25))
 	r.latestUpdate.Move(fyne.NewPos((sensorDisplayWidgetSizeX-r.latestUpdate.MinSize().Width)-5, (sensorDisplayWidgetSizeY-r.latestUpdate.TextSize)*0.75))
 }
@@ -487,6 +546,8 @@ func (r *sensorDisplayWidgetRenderer) Refresh() {
 	r.channel.Text = r.widget.channel
 	r.latestUpdate.Text = r.widget.latestUpdate
 	r.dateAdded.Text = r.widget.dateAdded
+	r.lastHeard.Text = r.widget.lastHeard
+	r.signal.Text = r.widget.signal
 }
 
 /************************************
@@ -531,5 +592,15 @@ func (sdw *sensorDisplayWidget) init(s *Sensor) {
 	sdw.dateAdded = s.DateAdded
 	sdw.latestUpdate = s.LastEdit
 	sdw.hasHumidity = s.HasHumidity
+	sdw.lastHeard = formatLastHeard(s)
+	sdw.signal = formatSensorSignal(s)
 	sdw.check = false
 }
+
+// formatSensorSignal - Reception summary line for the sensor lists
+func formatSensorSignal(s *Sensor) string {
+	if s.Signal.Readings == 0 {
+		return "Signal: no rtl_433 metadata received"
+	}
+	return s.Signal.FormatSignal()
+}
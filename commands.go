@@ -0,0 +1,741 @@
+/******************************************************************
+ *
+ * Commands - Scriptable operations on the same config.json and
+ *      history store as the GUI, without opening a window:
+ *          weatherdashboard sensors list|activate|deactivate|hide|rename
+ *          weatherdashboard subscriptions list|add|remove
+ *          weatherdashboard export [-from] [-to] [-format csv|jsonl]
+ *          weatherdashboard import file...
+ *          weatherdashboard replay capture.jsonl
+ *          weatherdashboard config validate
+ *      Each prints its usage with -h. Commands that change the
+ *      configuration save config.json when they succeed. Run the
+ *      commands that change it while the dashboard is closed, as the
+ *      dashboard saves its own copy when it exits.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A subcommand, run with the arguments after its name. Returns the exit status.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"sensors", "list|activate|deactivate|hide|rename  Show and change the active sensors", sensorsCommand},
+	{"subscriptions", "list|add|remove  Show and change the subscribed topics", subscriptionsCommand},
+	{"export", "[-from] [-to] [-format csv|jsonl]  Write history to standard output or a file", exportCommand},
+	{"import", "[-station] file...  Load data logs into the history", importCommand},
+	{"replay", "[-speed] [-shift] [-log] capture.jsonl  Process a capture of raw messages", replayCommand},
+	{"config", "validate  Check config.json", configCommand},
+}
+
+// findCommand - The subcommand named name, nil if there is none
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage - Print the subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: weatherdashboard [-headless] [-log]")
+	fmt.Fprintln(os.Stderr, "       weatherdashboard command [arguments]")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "   %-14s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+// loadCommandConfig - Read config.json for a command, false if it can't be read
+func loadCommandConfig() bool {
+	if err := jsonInput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read config.json: %s\n", err)
+		return false
+	}
+	return true
+}
+
+// saveCommandConfig - Save config.json after a command changed it. Returns the exit status.
+func saveCommandConfig() int {
+	if err := jsonOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to save config.json: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// commandFlags - Flags of a subcommand, printing usage on error
+func commandFlags(name string, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: weatherdashboard %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// commandSensorKey - Key of the active sensor with key or name s
+func commandSensorKey(s string) (string, error) {
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	if _, ok := activeSensors[s]; ok {
+		return s, nil
+	}
+	var found []string
+	for key, sens := range activeSensors {
+		if strings.EqualFold(sens.Name, s) {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no active sensor has key or name %q", s)
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("several sensors are named %q, use a key: %s", s, strings.Join(found, ", "))
+}
+
+// sensorFromKey - A new sensor of a key station:model:id:channel
+func sensorFromKey(key string) (Sensor, error) {
+	parts := strings.SplitN(key, ":", 4)
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" {
+		return Sensor{}, fmt.Errorf("%q is not a sensor key, station:model:id:channel", key)
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Sensor{}, fmt.Errorf("%q is not a sensor key, id %q is not a number", key, parts[2])
+	}
+	st := time.Now().Local().Format(YYYYMMDD + " " + HHMMSS24h)
+	return Sensor{Key: key, Station: parts[0], Model: parts[1], Id: id, Channel: parts[3], DateAdded: st, LastEdit: st, HasHumidity: true}, nil
+}
+
+/******************************************
+ * sensors
+ ******************************************/
+
+func sensorsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: weatherdashboard sensors list|activate|deactivate|hide|rename ...")
+		return 2
+	}
+	if !loadCommandConfig() {
+		return 1
+	}
+	switch args[0] {
+	case "list":
+		return sensorsList(args[1:])
+	case "activate":
+		return sensorsActivate(args[1:])
+	case "deactivate":
+		return sensorsDeactivate(args[1:])
+	case "hide":
+		return sensorsHide(args[1:])
+	case "rename":
+		return sensorsRename(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown sensors command %q\n", args[0])
+	return 2
+}
+
+func sensorsList(args []string) int {
+	fs := commandFlags("sensors list", "[-json]")
+	asJSON := fs.Bool("json", false, "List as JSON")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	keys := sortActiveSensors()
+	if *asJSON {
+		list := make([]Sensor, 0, len(keys))
+		for _, k := range keys {
+			list = append(list, *activeSensors[k])
+		}
+		data, _ := json.MarshalIndent(list, "", "    ")
+		fmt.Println(string(data))
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tNAME\tLOCATION\tSTATION\tHIDDEN\tLAST EDIT")
+	for _, k := range keys {
+		s := activeSensors[k]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\n", s.Key, s.Name, s.Location, s.Station, s.Hide, s.LastEdit)
+	}
+	tw.Flush()
+	return 0
+}
+
+func sensorsActivate(args []string) int {
+	fs := commandFlags("sensors activate", "[-name name] [-location location] station:model:id:channel")
+	name := fs.String("name", "", "Name of the sensor")
+	location := fs.String("location", "", "Location of the sensor")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	key := fs.Arg(0)
+	if checkSensor(key, activeSensors) {
+		fmt.Fprintf(os.Stderr, "Sensor %s is already active\n", key)
+		return 1
+	}
+	s, err := sensorFromKey(key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	s.Name, s.Location = *name, *location
+	// As if heard, then chosen from the available sensors
+	availableSensorsMutex.Lock()
+	availableSensors[key] = &s
+	availableSensorsMutex.Unlock()
+	if err := activateSensor(key); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return saveCommandConfig()
+}
+
+func sensorsDeactivate(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: weatherdashboard sensors deactivate key|name")
+		return 2
+	}
+	key, err := commandSensorKey(args[0])
+	if err == nil {
+		err = deactivateSensor(key)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return saveCommandConfig()
+}
+
+func sensorsHide(args []string) int {
+	fs := commandFlags("sensors hide", "[-show] key|name")
+	show := fs.Bool("show", false, "Show the sensor on the dashboard again")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	key, err := commandSensorKey(fs.Arg(0))
+	if err == nil {
+		err = updateSensor(key, func(s *Sensor) { s.Hide = !*show })
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return saveCommandConfig()
+}
+
+func sensorsRename(args []string) int {
+	fs := commandFlags("sensors rename", "[-location location] key|name new-name")
+	location := fs.String("location", "", "New location of the sensor, unchanged if not given")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	key, err := commandSensorKey(fs.Arg(0))
+	if err == nil {
+		err = updateSensor(key, func(s *Sensor) {
+			s.Name = fs.Arg(1)
+			if *location != "" {
+				s.Location = *location
+			}
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return saveCommandConfig()
+}
+
+/******************************************
+ * subscriptions
+ ******************************************/
+
+func subscriptionsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: weatherdashboard subscriptions list|add|remove ...")
+		return 2
+	}
+	if !loadCommandConfig() {
+		return 1
+	}
+	switch args[0] {
+	case "list":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TOPIC\tSTATION")
+		for _, m := range sortedSubscriptions() {
+			fmt.Fprintf(tw, "%s\t%s\n", m.Topic, m.Station)
+		}
+		tw.Flush()
+		return 0
+	case "add":
+		fs := commandFlags("subscriptions add", "-station station topic")
+		station := fs.String("station", "", "Station the topic is heard by, by default its first level")
+		if fs.Parse(args[1:]) != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		topic := fs.Arg(0)
+		for _, m := range sortedSubscriptions() {
+			if m.Topic == topic {
+				fmt.Fprintf(os.Stderr, "Already subscribed to %s\n", topic)
+				return 1
+			}
+		}
+		if *station == "" {
+			*station = strings.Split(topic, "/")[0]
+		}
+		addSubscription(topic, *station)
+		return saveCommandConfig()
+	case "remove":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: weatherdashboard subscriptions remove topic")
+			return 2
+		}
+		removed := 0
+		for _, m := range sortedSubscriptions() {
+			if m.Topic == args[1] && removeSubscription(m.Key) == nil {
+				removed++
+			}
+		}
+		if removed == 0 {
+			fmt.Fprintf(os.Stderr, "Not subscribed to %s\n", args[1])
+			return 1
+		}
+		return saveCommandConfig()
+	}
+	fmt.Fprintf(os.Stderr, "Unknown subscriptions command %q\n", args[0])
+	return 2
+}
+
+// sortedSubscriptions - Subscriptions by station and topic
+func sortedSubscriptions() []Subscription {
+	var list []Subscription
+	for key, m := range copySubscriptions() {
+		m.Key = key
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Station != list[j].Station {
+			return list[i].Station < list[j].Station
+		}
+		return list[i].Topic < list[j].Topic
+	})
+	return list
+}
+
+/******************************************
+ * export
+ ******************************************/
+
+// An exported reading: a sensor's measurements at one time
+type exportRow struct {
+	t      time.Time
+	sensor Sensor
+	values map[string]float64
+}
+
+func exportCommand(args []string) int {
+	fs := commandFlags("export", "[-from time] [-to time] [-format csv|jsonl] [-sensor key|name] [-bucket 1h] [-o file]")
+	from := fs.String("from", "", "Start of the export, e.g. 2024-06-01 or 2024-06-01T08:00:00Z, by default a day before -to")
+	to := fs.String("to", "", "End of the export, by default now")
+	format := fs.String("format", "csv", "csv or jsonl")
+	sensor := fs.String("sensor", "", "Only this sensor, by key or name")
+	bucket := fs.Duration("bucket", 0, "Export averages over buckets of this length, e.g. 5m or 1h, instead of every reading")
+	out := fs.String("o", "", "File to write, by default standard output")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if *format != "csv" && *format != "jsonl" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use csv or jsonl\n", *format)
+		return 2
+	}
+	end, start := time.Now(), time.Time{}
+	var ok bool
+	if *to != "" {
+		if end, ok = parseCommandTime(*to); !ok {
+			fmt.Fprintf(os.Stderr, "Unable to read -to time %q\n", *to)
+			return 2
+		}
+	}
+	start = end.Add(-24 * time.Hour)
+	if *from != "" {
+		if start, ok = parseCommandTime(*from); !ok {
+			fmt.Fprintf(os.Stderr, "Unable to read -from time %q\n", *from)
+			return 2
+		}
+	}
+	if !loadCommandConfig() {
+		return 1
+	}
+	var keys []string
+	if *sensor != "" {
+		key, err := commandSensorKey(*sensor)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		keys = []string{key}
+	} else {
+		keys = sortActiveSensors()
+	}
+	if settings.HistoryDir != "" {
+		if err := openHistory(settings.HistoryDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open history in %s: %s\n", settings.HistoryDir, err)
+			return 1
+		}
+		defer closeHistory()
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	rows, names := exportRows(keys, start, end, *bucket)
+	var err error
+	if *format == "jsonl" {
+		err = writeExportJSON(w, rows)
+	} else {
+		err = writeExportCSV(w, rows, names)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export stopped: %s\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d readings of %d sensors\n", len(rows), len(keys))
+	return 0
+}
+
+// parseCommandTime - A time given on the command line, a date or a time as the importer reads them
+func parseCommandTime(s string) (time.Time, bool) {
+	if t, err := time.ParseInLocation(YYYYMMDD, s, time.Local); err == nil {
+		return t, true
+	}
+	return parseImportTime(s, "")
+}
+
+// exportRows - History of sensors from time from up to time to, a row per reading, or per bucket
+// if bucket isn't 0, oldest first, and the measurements they have in log column order
+func exportRows(keys []string, from time.Time, to time.Time, bucket time.Duration) ([]exportRow, []string) {
+	var rows []exportRow
+	has := make(map[string]bool)
+	for _, key := range keys {
+		activeSensorsMutex.Lock()
+		s := *activeSensors[key]
+		activeSensorsMutex.Unlock()
+		byTime := make(map[int64]*exportRow)
+		add := func(name string, t time.Time, v float64) {
+			r, ok := byTime[t.UnixNano()]
+			if !ok {
+				r = &exportRow{t: t, sensor: s, values: make(map[string]float64)}
+				byTime[t.UnixNano()] = r
+			}
+			r.values[name] = v
+			has[name] = true
+		}
+		for _, name := range logCSVMeasurements {
+			if bucket > 0 {
+				for _, b := range historyStore.query(key, name, from, to, bucket) {
+					add(name, b.Start, b.Avg)
+				}
+				continue
+			}
+			for _, smp := range historyStore.read(key, name, from, to) {
+				add(name, smp.t, smp.v)
+			}
+		}
+		for _, r := range byTime {
+			rows = append(rows, *r)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].t.Equal(rows[j].t) {
+			return rows[i].t.Before(rows[j].t)
+		}
+		return rows[i].sensor.Key < rows[j].sensor.Key
+	})
+	var names []string
+	for _, name := range logCSVMeasurements {
+		if has[name] {
+			names = append(names, name)
+		}
+	}
+	return rows, names
+}
+
+// writeExportCSV - Rows as csv with a column for each of names, which the importer reads back
+func writeExportCSV(w io.Writer, rows []exportRow, names []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"time", "station", "sensor_key", "sensor", "location"}, names...))
+	for _, r := range rows {
+		row := []string{r.t.In(stationLocation(r.sensor.Station)).Format(time.RFC3339Nano), r.sensor.Station, r.sensor.Key, r.sensor.Name, r.sensor.Location}
+		for _, name := range names {
+			cell := ""
+			if v, ok := r.values[name]; ok {
+				cell = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+			row = append(row, cell)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeExportJSON - Rows as JSON Lines in the data log's jsonl format
+func writeExportJSON(w io.Writer, rows []exportRow) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		rec := logRecord{
+			Time:         r.t.In(stationLocation(r.sensor.Station)).Format(time.RFC3339Nano),
+			Station:      r.sensor.Station,
+			SensorKey:    r.sensor.Key,
+			Sensor:       r.sensor.Name,
+			Location:     r.sensor.Location,
+			Model:        r.sensor.Model,
+			Id:           r.sensor.Id,
+			Channel:      r.sensor.Channel,
+			Measurements: r.values,
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/******************************************
+ * replay
+ ******************************************/
+
+func replayCommand(args []string) int {
+	fs := commandFlags("replay", "[-speed n] [-shift] [-log] capture.jsonl")
+	speed := fs.Float64("speed", 0, "1 for real time, 10 for ten times as fast, 0 as fast as possible")
+	shift := fs.Bool("shift", false, "Replay as if the first message were received now")
+	logData := fs.Bool("log", false, "Write the readings to the data log")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if !loadCommandConfig() {
+		return 1
+	}
+	if err := loadAlertHistory(); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Unable to read alert history: %s\n", err)
+	}
+	if settings.HistoryDir != "" {
+		if err := openHistory(settings.HistoryDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open history in %s: %s\n", settings.HistoryDir, err)
+			return 1
+		}
+	}
+	logdata_flg = *logData
+	result, err := newReplayer(*speed, *shift).replayFile(fs.Arg(0))
+	if settings.MergeReceivers {
+		// Let the last merges finish
+		time.Sleep(time.Duration(settings.MergeWindowSecs * float64(time.Second)))
+	}
+	saveAndClose()
+	fmt.Printf("Replayed %d messages, %d bad lines\n", result.Messages, result.Bad)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay stopped: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+/******************************************
+ * config
+ ******************************************/
+
+func configCommand(args []string) int {
+	if len(args) != 1 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: weatherdashboard config validate")
+		return 2
+	}
+	data, err := os.ReadFile("config.json")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	problems, err := validateConfig(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config.json is not valid JSON: %s\n", err)
+		return 1
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("config.json has %d problems\n", len(problems))
+		return 1
+	}
+	fmt.Println("config.json is valid")
+	return 0
+}
+
+// validateConfig - Problems of a configuration, an error if it can't be read at all
+func validateConfig(data []byte) ([]string, error) {
+	var c Configuration
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	var problems []string
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	if len(c.Brokers) == 0 {
+		add("No brokers")
+	}
+	for _, b := range c.Brokers {
+		if b.Path == "" {
+			add("Broker with no path")
+		}
+		if b.Port <= 0 || b.Port > 65535 {
+			add("Broker %s: port %d is not a port number", b.Path, b.Port)
+		}
+	}
+	for _, m := range c.Subscriptions {
+		station := strings.Split(m.Topic, "/")[0]
+		switch {
+		case m.Topic == "":
+			add("Subscription with no topic")
+		case m.Station == "":
+			add("Subscription %s: no station", m.Topic)
+		case !strings.ContainsAny(station, "+#") && station != m.Station:
+			add("Subscription %s: readings are taken to be from station %q, not %q", m.Topic, station, m.Station)
+		}
+	}
+	for key, s := range c.ActiveSensors {
+		if s.Key != key {
+			add("Sensor %s: has key %q", key, s.Key)
+		}
+		wd := WeatherData{Station: s.Station, Model: s.Model, Id: s.Id, Channel: s.Channel}
+		if built := wd.BuildSensorKey(); built != key {
+			add("Sensor %s: station, model, id and channel make key %q", key, built)
+		}
+		if s.Virtual {
+			if _, err := compileVirtual(s.Expression); err != nil {
+				add("Virtual sensor %s: %s", key, err)
+			}
+			if importMeasurement(s.Measurement) != s.Measurement {
+				add("Virtual sensor %s: unknown measurement %q", key, s.Measurement)
+			}
+		}
+		for name := range s.Calibration {
+			if !containsString(calibrationNames, name) {
+				add("Sensor %s: %s can't be calibrated", key, name)
+			}
+		}
+	}
+	for name, st := range c.Stations {
+		if _, err := time.LoadLocation(st.Timezone); err != nil {
+			add("Station %s: unknown time zone %q", name, st.Timezone)
+		}
+	}
+	for _, r := range c.AlertRules {
+		if err := r.validate(); err != nil {
+			add("Alert rule: %s", err)
+		}
+	}
+	for _, n := range c.Notifiers {
+		if err := n.validate(); err != nil {
+			add("Notifier: %s", err)
+		}
+	}
+	st := c.Settings
+	if st.LogFormat != "" && !containsString(logFormats, st.LogFormat) {
+		add("LogFormat %q is not one of %s", st.LogFormat, strings.Join(logFormats, ", "))
+	}
+	if st.LogSplit != "" && !containsString(logSplits, st.LogSplit) {
+		add("LogSplit %q is not one of %s", st.LogSplit, strings.Join(logSplits, ", "))
+	}
+	if st.MergeStrategy != "" && st.MergeStrategy != "signal" && st.MergeStrategy != "arrival" {
+		add("MergeStrategy %q is not signal or arrival", st.MergeStrategy)
+	}
+	for res := range st.HistoryRetention {
+		known := res == rawResolution
+		for _, r := range rollupResolutions {
+			known = known || r.name == res
+		}
+		if !known {
+			add("HistoryRetention: unknown resolution %q", res)
+		}
+	}
+	if st.Simulator.Target != "" && !containsString(simTargets, st.Simulator.Target) {
+		add("Simulator Target %q is not one of %s", st.Simulator.Target, strings.Join(simTargets, ", "))
+	}
+	for _, d := range st.Simulator.Devices {
+		if d.Type != "acurite" && d.Type != "lacrosse" && d.Type != "fineoffset" {
+			add("Simulator device %d: unknown type %q", d.Id, d.Type)
+		}
+	}
+	for _, sink := range st.AlertSinks {
+		found := false
+		for _, n := range c.Notifiers {
+			found = found || n.Name == sink
+		}
+		if !found {
+			add("AlertSinks: no notifier %q", sink)
+		}
+	}
+	if st.APIAddr != "" {
+		if _, _, err := net.SplitHostPort(st.APIAddr); err != nil {
+			add("APIAddr: %s", err)
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"time"
 )
 
 var clientID string = "weatherdashboard"
@@ -52,33 +53,28 @@ func readConfig() {
 		fmt.Scanln(&m.Station)
 		// Add to subscriptions
 		skey := rand.Int()
+		subscriptionsMutex.Lock()
 		subscriptions[skey] = &m
+		subscriptionsMutex.Unlock()
 	}
+	restoreAlerts()
 
-	// Disable data logging
+	// Disable data logging. Data files are opened when first written, see logwriter.go.
 	logdata_flg = false
+}
 
-	//**********************************
-	// Open data output files, one for each subscription
-	//**********************************
-	for _, m := range subscriptions {
-		fp := "./WeatherData-" + m.Station + ".txt"
-		dfile := new(DataFile)
-		dfile.path = fp
-		dfile.file, err = os.OpenFile(fp, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-		if err != nil {
-			SetStatus(fmt.Sprintf("Unable to create/open output file. %s", err))
-			fmt.Printf("Unable to create/open output file. %s", err)
-			panic(err.Error)
-		}
-		dataFiles[m.Station] = *dfile // Add the new DataFile object to the array of data files
-		SetStatus(fmt.Sprintf("Opened data file %s", fp))
+// restoreAlerts - Restore alerts raised by the previous run
+func restoreAlerts() {
+	if err := loadAlertHistory(); err != nil && !os.IsNotExist(err) {
+		SetStatus(fmt.Sprintf("Unable to read alert history. %s", err))
 	}
 }
 
 func writeConfig() {
 	err := jsonOutput()
 	check(err)
+	err = saveAlertHistory()
+	check(err)
 }
 
 // jsonOutput() - Write out all configuration options to a .json file
@@ -94,15 +90,18 @@ func jsonOutput() (e error) {
 		as[key] = a
 	}
 
-	for key := range subscriptions {
-		s := *subscriptions[key]
-		subs[key] = s
+	for key, m := range copySubscriptions() {
+		subs[key] = *m
 	}
 
 	c := Configuration{
 		Brokers:       brokers,
 		Subscriptions: subs,
 		ActiveSensors: as,
+		Settings:      settings,
+		AlertRules:    copyAlertRules(),
+		Notifiers:     notifiers,
+		Stations:      copyStations(),
 	}
 
 	data, _ := json.MarshalIndent(c, "", "    ")
@@ -120,6 +119,7 @@ func jsonInput() (e error) {
 		Brokers:       brokers,
 		Subscriptions: subs,
 		ActiveSensors: as,
+		Settings:      settings, // Defaults survive for options missing from the file
 	}
 
 	inidata, err := os.ReadFile("config.json")
@@ -144,14 +144,45 @@ func jsonInput() (e error) {
 	}
 
 	// Load the input subscriptions
+	subscriptionsMutex.Lock()
 	for key, value := range c.Subscriptions {
 		subscriptions[key] = &value
 	}
+	subscriptionsMutex.Unlock()
 
 	// Load the input sensors, being sure to store the address of the sensor, not the sensor
 	for key, value := range c.ActiveSensors {
 		activeSensors[key] = &value
 	}
 
+	settings = c.Settings
+	for name, st := range c.Stations {
+		if _, err := time.LoadLocation(st.Timezone); err != nil {
+			SetStatus(fmt.Sprintf("Station %s: unknown time zone %q, using local time", name, st.Timezone))
+		}
+		setStationSettings(name, st)
+	}
+
+	// Load the notifiers, then the alert rules that use them, skipping any that can't be used
+	notifiers = nil
+	for _, n := range c.Notifiers {
+		if err := n.validate(); err != nil {
+			SetStatus(fmt.Sprintf("Skipping notifier: %s", err))
+			continue
+		}
+		notifiers = append(notifiers, n)
+	}
+	var rules []AlertRule
+	for _, r := range c.AlertRules {
+		if err := r.validate(); err != nil {
+			SetStatus(fmt.Sprintf("Skipping alert rule: %s", err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	rulesMutex.Lock()
+	alertRules = rules
+	rulesMutex.Unlock()
+
 	return nil
 }
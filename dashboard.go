@@ -15,9 +15,13 @@ import (
 	"fyne.io/fyne/v2/theme"
 )
 
-// SetStatus - publishes message on scrolling GUI status console
+// SetStatus - publishes message on scrolling GUI status console, or to the status log without the GUI
 func SetStatus(s string) {
 	status = s
+	if a == nil {
+		statusLog.Info(s)
+		return
+	}
 	ConsoleWrite(status)
 }
 
@@ -56,6 +60,9 @@ func removeAllWeatherWidgets() {
 
 // reloadDashboard() - Regenerate and reload the dashboard container
 func reloadDashboard() {
+	if a == nil {
+		return // No dashboard without the GUI
+	}
 
 	// Empty dashboard container
 	// Create new window if one does't already exist
@@ -74,7 +81,8 @@ func reloadDashboard() {
 	// Regenerate weatherWidgets
 	generateWeatherWidgets()
 
-	// Load the container with the new widgets
+	// Load the container with the forecast tiles, then the new widgets
+	tiles := addForecastTiles(dashboardContainer)
 	keys := sortWeatherWidgets() // Display in columns sorted by Station and Name
 	for _, k := range keys {
 		weatherWidgets[k].Refresh()
@@ -83,7 +91,7 @@ func reloadDashboard() {
 		go weatherWidgets[k].goHandler(k)
 	}
 	dashboardWindow.SetContent(dashboardContainer)
-	numRows := float32(math.Round(float64(len(weatherWidgets)) / float64(numColumns)))
+	numRows := float32(math.Round(float64(len(weatherWidgets)+tiles) / float64(numColumns)))
 	dashboardWindow.Resize(fyne.NewSize((widgetSizeX+widgetPadding)*float32(numColumns), (widgetSizeY+widgetPadding)*numRows))
 
 	// Show the reloaded container in window
@@ -115,6 +123,10 @@ func ConsoleWrite(text string) {
 
 // DisplayData - Call this function to display a weather data string in the weather display scrolling window
 func DisplayData(text string) {
+	if a == nil {
+		statusLog.Debug(text) // No live feed without the GUI
+		return
+	}
 	WeatherDataDisp.Add(&canvas.Text{
 		Text:      text,
 		Color:     th.Color(theme.ColorNameForeground, a.Settings().ThemeVariant()),
@@ -0,0 +1,314 @@
+/******************************************************************
+ *
+ * Data log - Format and files of the data log. LogFormat picks
+ *      the format of each reading:
+ *          text   the original "key: value, " lines
+ *          csv    a header of every measurement, a row per reading
+ *          jsonl  a JSON object per line with the whole reading
+ *      LogSplit picks the files: one per station, per sensor or per
+ *      station and day, named WeatherData-<station>[-<sensor or
+ *      date>].<txt|csv|jsonl>. Times in csv and jsonl are ISO-8601
+ *      with the station's time zone. Files are opened and rotated by
+ *      the log writer, logwriter.go.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	logFormats                  = []string{"text", "csv", "jsonl"}
+	logSplits                   = []string{"station", "sensor", "day"}
+	logSettingsFlag  bool       = false // Data log settings window flag. If true, window is open.
+	logSettingsMutex sync.Mutex         // Held while the data log settings are used or changed
+)
+
+// Columns of the csv format before the measurements
+var logCSVFields = []string{"time", "station", "sensor_key", "sensor", "location", "model", "id", "channel", "battery_ok"}
+
+// Measurements in the csv format, in column order. Files are appended to under the header they
+// were started with, so columns may be added at the end only, never removed or moved.
+var logCSVMeasurements = []string{
+	"temperature_F",
+	"humidity",
+	"wind_avg_mi_h",
+	"wind_max_mi_h",
+	"wind_avg_10m_mi_h",
+	"rssi",
+	"snr",
+	"noise",
+	"dew_point_F",
+	"heat_index_F",
+	"wind_chill_F",
+	"feels_like_F",
+	"abs_humidity_g_m3",
+	"rain_rate_in_h",
+	"rain_day_in",
+	"rain_24h_in",
+	"pressure_hPa",
+	"sea_level_hPa",
+	"wind_dir_deg",
+	"wind_dir_10m_deg",
+	"rain_in",
+	"rain_mm",
+}
+
+// A reading in the jsonl format
+type logRecord struct {
+	Time         string             `json:"time"`        // ISO-8601 arrival time with time zone
+	SensorTime   string             `json:"sensor_time"` // Time in the rtl_433 message
+	Station      string             `json:"station"`
+	SensorKey    string             `json:"sensor_key"`
+	Sensor       string             `json:"sensor"`
+	Location     string             `json:"location"`
+	Model        string             `json:"model"`
+	Id           int                `json:"id"`
+	Channel      string             `json:"channel"`
+	BatteryOk    *bool              `json:"battery_ok,omitempty"`
+	Measurements map[string]float64 `json:"measurements"`
+	Raw          map[string]float64 `json:"raw,omitempty"` // Values before calibration
+	Receivers    []string           `json:"receivers,omitempty"`
+	Signal       *logSignal         `json:"signal,omitempty"`
+}
+
+// Reception metadata of a reading in the jsonl format
+type logSignal struct {
+	Rssi     float64 `json:"rssi"`
+	Snr      float64 `json:"snr"`
+	Noise    float64 `json:"noise"`
+	Freq     float64 `json:"freq"`
+	Protocol int     `json:"protocol"`
+}
+
+// logTime - Arrival time of a reading in its station's time zone, from the message time if it has none
+func logTime(wd WeatherData) time.Time {
+	loc := stationLocation(wd.Station)
+	if !wd.Received.IsZero() {
+		return wd.Received.In(loc)
+	}
+	if t, err := time.ParseInLocation(YYYYMMDD+" "+HHMMSS24h, wd.Time, loc); err == nil {
+		return t
+	}
+	return time.Now().In(loc)
+}
+
+// logMeasurements - Measurements of a reading in the log, in csv column order
+func logMeasurements(wd WeatherData) map[string]float64 {
+	m := make(map[string]float64)
+	for _, name := range logCSVMeasurements {
+		if v, ok := wd.Measurement(name); ok {
+			m[name] = v
+		}
+	}
+	return m
+}
+
+// logExtension - File name extension of a log format
+func logExtension(format string) string {
+	switch format {
+	case "csv":
+		return ".csv"
+	case "jsonl":
+		return ".jsonl"
+	}
+	return ".txt"
+}
+
+// logFileName - Part of a file name from a station or sensor name, without path separators
+func logFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>| `, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// logFilePath - File a reading is logged to, by the LogFormat and LogSplit settings
+func logFilePath(wd WeatherData) string {
+	name := "./WeatherData-" + logFileName(wd.Station)
+	switch settings.LogSplit {
+	case "sensor":
+		sensor := wd.SensorName
+		if sensor == "" {
+			sensor = wd.BuildPhysicalKey()
+		}
+		name += "-" + logFileName(sensor)
+	case "day":
+		name += "-" + logTime(wd).Format(YYYYMMDD)
+	}
+	return name + logExtension(settings.LogFormat)
+}
+
+// formatLogText - A reading in the text format
+func formatLogText(wd WeatherData) string {
+	return fmt.Sprintf("station: %s, sensor: %s, location: %s, temp: %.1f, humidity: %.1f, time: %s, model: %s, id: %d, channel: %s%s\n",
+		wd.Station, wd.SensorName, wd.SensorLocation, wd.Temperature_F, wd.Humidity, wd.Time, wd.Model, wd.Id, wd.Channel, formatDerived(wd)+formatRaw(wd)+formatReceivers(wd)+formatSignal(wd))
+}
+
+// formatLogCSVHeader - Header line of the csv format
+func formatLogCSVHeader() string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(append(append([]string{}, logCSVFields...), logCSVMeasurements...))
+	w.Flush()
+	return b.String()
+}
+
+// formatLogCSV - A reading as a csv row
+func formatLogCSV(wd WeatherData) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	battery := ""
+	if wd.HasBattery {
+		battery = strconv.Itoa(wd.Battery_ok)
+	}
+	row := []string{logTime(wd).Format(time.RFC3339), wd.Station, wd.BuildSensorKey(), wd.SensorName, wd.SensorLocation,
+		wd.Model, strconv.Itoa(wd.Id), wd.Channel, battery}
+	for _, name := range logCSVMeasurements {
+		cell := ""
+		if v, ok := wd.Measurement(name); ok {
+			cell = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		row = append(row, cell)
+	}
+	w.Write(row)
+	w.Flush()
+	return b.String()
+}
+
+// formatLogJSON - A reading as a line of JSON
+func formatLogJSON(wd WeatherData) (string, error) {
+	rec := logRecord{
+		Time:         logTime(wd).Format(time.RFC3339Nano),
+		SensorTime:   wd.Time,
+		Station:      wd.Station,
+		SensorKey:    wd.BuildSensorKey(),
+		Sensor:       wd.SensorName,
+		Location:     wd.SensorLocation,
+		Model:        wd.Model,
+		Id:           wd.Id,
+		Channel:      wd.Channel,
+		Measurements: logMeasurements(wd),
+		Raw:          wd.Raw,
+		Receivers:    wd.Receivers,
+	}
+	if wd.HasBattery {
+		ok := wd.Battery_ok == 1
+		rec.BatteryOk = &ok
+	}
+	if wd.HasSignal() {
+		rec.Signal = &logSignal{Rssi: wd.Rssi, Snr: wd.Snr, Noise: wd.Noise, Freq: wd.Freq, Protocol: wd.Protocol}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// closeDataFiles - Sync and close the open data files
+func closeDataFiles() {
+	dataLog.close()
+}
+
+// logReading - Append a reading to its data file in the chosen format
+func logReading(wd WeatherData) error {
+	logSettingsMutex.Lock()
+	defer logSettingsMutex.Unlock()
+	var header, line string
+	switch settings.LogFormat {
+	case "csv":
+		header = formatLogCSVHeader()
+		line = formatLogCSV(wd)
+	case "jsonl":
+		var err error
+		if line, err = formatLogJSON(wd); err != nil {
+			return err
+		}
+	default:
+		line = formatLogText(wd)
+	}
+	return dataLog.write(logFilePath(wd), header, line, time.Now())
+}
+
+// logSettingsHandler - Opens a window to choose the format and files of the data log
+var logSettingsHandler = func() {
+	if logSettingsFlag {
+		return
+	}
+	logSettingsFlag = true
+	formatSelect := widget.NewSelect(logFormats, nil)
+	formatSelect.SetSelected(settings.LogFormat)
+	splitSelect := widget.NewSelect(logSplits, nil)
+	splitSelect.SetSelected(settings.LogSplit)
+	rotateEntry := widget.NewEntry()
+	rotateEntry.SetText(strconv.FormatFloat(settings.LogRotateMB, 'f', -1, 64))
+	dailyCheck := widget.NewCheck("Start new files each day", nil)
+	dailyCheck.SetChecked(settings.LogRotateDaily)
+	compressCheck := widget.NewCheck("Gzip rotated files", nil)
+	compressCheck.SetChecked(settings.LogCompress)
+	maxTotalEntry := widget.NewEntry()
+	maxTotalEntry.SetText(strconv.FormatFloat(settings.LogMaxTotalMB, 'f', -1, 64))
+	logWindow := a.NewWindow("Data Log Format")
+	logWindow.SetOnClosed(func() {
+		logSettingsFlag = false
+	})
+	save := widget.NewButton("Save", func() {
+		rotateMB, err := strconv.ParseFloat(strings.TrimSpace(rotateEntry.Text), 64)
+		if err != nil || rotateMB < 0 {
+			SetStatus(fmt.Sprintf("Data log settings not saved, rotation size %q is not a number of MB", rotateEntry.Text))
+			return
+		}
+		maxTotalMB, err := strconv.ParseFloat(strings.TrimSpace(maxTotalEntry.Text), 64)
+		if err != nil || maxTotalMB < 0 {
+			SetStatus(fmt.Sprintf("Data log settings not saved, total size %q is not a number of MB", maxTotalEntry.Text))
+			return
+		}
+		logSettingsMutex.Lock()
+		settings.LogRotateMB = rotateMB
+		settings.LogRotateDaily = dailyCheck.Checked
+		settings.LogCompress = compressCheck.Checked
+		settings.LogMaxTotalMB = maxTotalMB
+		if formatSelect.Selected != settings.LogFormat || splitSelect.Selected != settings.LogSplit {
+			// Later readings go to the files of the new format and split
+			settings.LogFormat = formatSelect.Selected
+			settings.LogSplit = splitSelect.Selected
+			closeDataFiles()
+		}
+		format, split := settings.LogFormat, settings.LogSplit
+		logSettingsMutex.Unlock()
+		SetStatus(fmt.Sprintf("Data log format %s, one file per %s", format, split))
+		logWindow.Close()
+	})
+	logWindow.SetContent(container.NewVBox(
+		widget.NewLabel("Format"),
+		formatSelect,
+		widget.NewLabel("One file per"),
+		splitSelect,
+		widget.NewLabel("Rotate files at MB, 0 for no limit"),
+		rotateEntry,
+		dailyCheck,
+		compressCheck,
+		widget.NewLabel("Keep rotated and daily files up to a total MB, 0 for no limit"),
+		maxTotalEntry,
+		container.NewHBox(save, widget.NewButton("Cancel", func() {
+			logWindow.Close()
+		})),
+	))
+	logWindow.Resize(fyne.NewSize(400, 400))
+	logWindow.Show()
+}
@@ -29,6 +29,7 @@ const (
 	numColumns            = 5
 	cornerRadius  float32 = 10
 	strokeWidth   float32 = 2
+	trendIconSize float32 = 12 // Trend arrows on the widget
 )
 const (
 	// YYYY-MM-DD: 2022-03-23
@@ -58,11 +59,28 @@ type WeatherDataRaw struct {
 	Id            int           `json:"id"`            //1997
 	Channel       CustomChannel `json:"channel"`       //"A" or 1
 	Sequence_num  int           `json:"sequence_num"`  //0
-	Battery_ok    int           `json:"battery_ok"`    //1
+	Battery_ok    *int          `json:"battery_ok"`    //1, missing if the sensor does not report battery
 	Wind_avg_mi_h float64       `json:"wind_avg_mi_h"` //4.73634
 	Temperature_F float64       `json:"temperature_F"` //69.4
 	Humidity      float64       `json:"humidity"`      // Can appear as integer or a decimal value
 	Mic           string        `json:"mic"`           //"CHECKSUM"
+	Rssi          float64       `json:"rssi"`          //-0.115 dB, only when rtl_433 metadata is enabled
+	Snr           float64       `json:"snr"`           //19.6 dB, only when rtl_433 metadata is enabled
+	Noise         float64       `json:"noise"`         //-19.7 dB, only when rtl_433 metadata is enabled
+	Freq          float64       `json:"freq"`          //433.92 MHz, only when rtl_433 metadata is enabled
+	Protocol      int           `json:"protocol"`      //40, rtl_433 decoder number
+	Mod           string        `json:"mod"`           //"ASK" or "FSK"
+	Rain_in       float64       `json:"rain_in"`       //12.34, running total of a rain gauge
+	Rain_mm       float64       `json:"rain_mm"`       //313.4, running total of a metric rain gauge
+	Pressure_hPa  float64       `json:"pressure_hPa"`  //985.3, station pressure
+	Pressure_inHg float64       `json:"pressure_inHg"` //29.10, station pressure
+	Pressure_kPa  float64       `json:"pressure_kPa"`  //98.53, station pressure
+	Wind_dir_deg  float64       `json:"wind_dir_deg"`  //247.5, direction the wind blows from
+	Wind_max_mi_h float64       `json:"wind_max_mi_h"` //12.3, gust
+	Wind_avg_km_h float64       `json:"wind_avg_km_h"` //7.6, metric anemometers
+	Wind_max_km_h float64       `json:"wind_max_km_h"` //19.8
+	Wind_avg_m_s  float64       `json:"wind_avg_m_s"`  //2.1
+	Wind_max_m_s  float64       `json:"wind_max_m_s"`  //5.5
 }
 
 type CustomChannel struct {
@@ -74,20 +92,60 @@ func (cc *CustomChannel) channel() string {
 }
 
 type WeatherData struct {
-	Time           string  `json:"time"`          //"2024-06-11 10:33:52"
-	Model          string  `json:"model"`         //"Acurite-5n1"
-	Message_type   int     `json:"message_type"`  //56
-	Id             int     `json:"id"`            //1997
-	Channel        string  `json:"channel"`       //"A" or 1
-	Sequence_num   int     `json:"sequence_num"`  //0
-	Battery_ok     int     `json:"battery_ok"`    //1
-	Wind_avg_mi_h  float64 `json:"wind_avg_mi_h"` //4.73634
-	Temperature_F  float64 `json:"temperature_F"` //69.4
-	Humidity       float64 `json:"humidity"`      // Can appear as integer or a decimal value
-	Mic            string  `json:"mic"`           //"CHECKSUM"
-	Station        string  `json:"station"`       // Sensor station
-	SensorName     string  `json:"sensorName"`
-	SensorLocation string  `json:"sensorLocation"`
+	Time          string  `json:"time"`          //"2024-06-11 10:33:52"
+	Model         string  `json:"model"`         //"Acurite-5n1"
+	Message_type  int     `json:"message_type"`  //56
+	Id            int     `json:"id"`            //1997
+	Channel       string  `json:"channel"`       //"A" or 1
+	Sequence_num  int     `json:"sequence_num"`  //0
+	Battery_ok    int     `json:"battery_ok"`    //1
+	HasBattery    bool    `json:"-"`             // Sensor reported battery_ok
+	Wind_avg_mi_h float64 `json:"wind_avg_mi_h"` //4.73634
+	Temperature_F float64 `json:"temperature_F"` //69.4
+	Humidity      float64 `json:"humidity"`      // Can appear as integer or a decimal value
+	Mic           string  `json:"mic"`           //"CHECKSUM"
+	Rssi          float64 `json:"rssi"`          //-0.115 dB
+	Snr           float64 `json:"snr"`           //19.6 dB
+	Noise         float64 `json:"noise"`         //-19.7 dB
+	Freq          float64 `json:"freq"`          //433.92 MHz
+	Protocol      int     `json:"protocol"`      //40
+	Mod           string  `json:"mod"`           //"ASK"
+	Rain_in       float64 `json:"rain_in"`       //12.34
+	Rain_mm       float64 `json:"rain_mm"`       //313.4
+	Pressure_hPa  float64 `json:"pressure_hPa"`  //985.3, or converted from pressure_inHg or pressure_kPa
+	Pressure_inHg float64 `json:"pressure_inHg"` //29.10
+	Pressure_kPa  float64 `json:"pressure_kPa"`  //98.53
+	SeaLevel_hPa  float64 `json:"sea_level_hPa"` // Pressure reduced to sea level for the station's elevation
+	Wind_dir_deg  float64 `json:"wind_dir_deg"`  //247.5
+	Wind_max_mi_h float64 `json:"wind_max_mi_h"` //12.3, or converted from km/h or m/s like the average
+	Wind_avg_km_h float64 `json:"wind_avg_km_h"` //7.6
+	Wind_max_km_h float64 `json:"wind_max_km_h"` //19.8
+	Wind_avg_m_s  float64 `json:"wind_avg_m_s"`  //2.1
+	Wind_max_m_s  float64 `json:"wind_max_m_s"`  //5.5
+	// Averages over the last 10 minutes of an anemometer's readings
+	WindAvg10m_mi_h float64 `json:"wind_avg_10m_mi_h"`
+	WindDir10m_deg  float64 `json:"wind_dir_10m_deg"`
+	// Computed from the running total of a rain gauge
+	RainRate_in_h float64 `json:"rain_rate_in_h"`
+	RainDay_in    float64 `json:"rain_day_in"`
+	Rain24h_in    float64 `json:"rain_24h_in"`
+	// Derived from temperature, humidity and wind
+	DewPoint_F       float64 `json:"dew_point_F"`
+	HeatIndex_F      float64 `json:"heat_index_F"`
+	WindChill_F      float64 `json:"wind_chill_F"`
+	FeelsLike_F      float64 `json:"feels_like_F"`
+	AbsHumidity_g_m3 float64 `json:"abs_humidity_g_m3"`
+	Station          string  `json:"station"` // Sensor station
+	SensorName       string  `json:"sensorName"`
+	SensorLocation   string  `json:"sensorLocation"`
+	// Stations that heard this reading when receivers are merged
+	Receivers []string `json:"receivers,omitempty"`
+	// Time the reading arrived at the dashboard
+	Received time.Time `json:"-"`
+	// Names of the fields present in the rtl_433 message
+	Fields map[string]bool `json:"-"`
+	// Values of calibrated measurements as the sensor reported them
+	Raw map[string]float64 `json:"raw,omitempty"`
 }
 
 type Sensor struct {
@@ -111,13 +169,73 @@ type Sensor struct {
 	// Visibility of sensor to menus and displays
 	Hide        bool `json:"Hide"`        // If set true, do not include in the list of weatherWidgets in dashboard
 	HasHumidity bool `json:"HasHumidity"` // If sensor does not provide humidity, set to false
+	// Radio reception summary, from rtl_433 metadata
+	Signal SignalSummary `json:"Signal"`
+	// Battery state, known once the sensor has reported battery_ok
+	BatteryKnown   bool           `json:"BatteryKnown"`
+	BatteryOk      bool           `json:"BatteryOk"`
+	BatteryHistory []BatteryEvent `json:"BatteryHistory"` // Changes of battery state, oldest first
+	// Silent sensor detection
+	LastHeard        string  `json:"LastHeard"`        // Arrival time of the latest reading
+	ExpectedInterval int     `json:"ExpectedInterval"` // Seconds between readings, 0 = use learned interval
+	LearnedInterval  float64 `json:"LearnedInterval"`  // Seconds between readings, learned from arrivals
+	Offline          bool    `json:"Offline"`          // Not heard for StaleFactor intervals
+	// Min/max/avg of each measurement, by measurement name
+	Stats map[string]*MeasurementStats `json:"Stats"`
+	// Rain accumulation, for rain gauges
+	Rain *RainTotals `json:"Rain,omitempty"`
+	// Daily temperatures for degree days, reported for outdoor sensors
+	Outdoor    bool            `json:"Outdoor"`
+	DegreeDays DegreeDayRecord `json:"DegreeDays"`
+	// Offset and scale of measurements, by measurement name
+	Calibration map[string]Calibration `json:"Calibration,omitempty"`
+	// Virtual sensors, computed from other sensors
+	Virtual     bool   `json:"Virtual"`
+	Expression  string `json:"Expression,omitempty"`  // Expression over other sensors, see virtual.go
+	Measurement string `json:"Measurement,omitempty"` // Measurement the expression gives, e.g. temperature_F
+}
+
+// A change of battery state reported by a sensor
+type BatteryEvent struct {
+	Time string `json:"Time"`
+	Ok   bool   `json:"Ok"`
+}
+
+// Summary of the radio metadata received for a sensor
+type SignalSummary struct {
+	Readings  int     `json:"Readings"` // Readings that carried metadata
+	LastRssi  float64 `json:"LastRssi"`
+	MinRssi   float64 `json:"MinRssi"`
+	MaxRssi   float64 `json:"MaxRssi"`
+	AvgRssi   float64 `json:"AvgRssi"`
+	LastSnr   float64 `json:"LastSnr"`
+	AvgSnr    float64 `json:"AvgSnr"`
+	LastNoise float64 `json:"LastNoise"`
+	AvgNoise  float64 `json:"AvgNoise"`
+	Freq      float64 `json:"Freq"`
+	Protocol  int     `json:"Protocol"`
+	Mod       string  `json:"Mod"`
 }
 
 type newData struct {
-	key      string
-	temp     float64
-	humidity float64
-	date     string
+	key        string
+	temp       float64
+	humidity   float64
+	date       string
+	batteryLow bool
+	dewPoint   float64
+	feelsLike  float64
+	tempTrend  trend
+	humTrend   trend
+	hasRain    bool
+	rainDay    float64
+	rain24h    float64
+	rainRate   float64
+	hasWind    bool
+	windSpeed  float64
+	windDir    float64
+	hasWindDir bool
+	windGust   float64
 }
 
 type Broker struct {
@@ -133,15 +251,48 @@ type Subscription struct {
 	Station string `json:"Station"`
 }
 
+// Program options saved with the configuration
+type Settings struct {
+	MergeReceivers  bool     `json:"MergeReceivers"`  // Merge copies of one sensor heard by several stations
+	MergeStrategy   string   `json:"MergeStrategy"`   // "signal" keeps best RSSI/SNR copy, "arrival" keeps first copy
+	MergeWindowSecs float64  `json:"MergeWindowSecs"` // Time to wait for copies from other stations
+	StaleFactor     float64  `json:"StaleFactor"`     // Sensor is offline after this many missed intervals
+	AlertSinks      []string `json:"AlertSinks"`      // Notifiers for battery and offline alerts
+	// Trend of each measurement over the latest readings
+	TrendWindowMins float64            `json:"TrendWindowMins"` // Readings used for trends, in minutes
+	TrendSteady     map[string]float64 `json:"TrendSteady"`     // Measurement : per hour rate below which it is steady
+	RainSeasonStart int                `json:"RainSeasonStart"` // Month the rain season begins, 1 = January
+	DegreeDays      DegreeDaySettings  `json:"DegreeDays"`      // Base temperatures and seasons
+	HistoryDir      string             `json:"HistoryDir"`      // Directory of the history store, "" to keep history in memory only
+	// Resolution ("raw", "5m", "1h" or "1d") : days of history kept, 0 = forever
+	HistoryRetention map[string]int    `json:"HistoryRetention"`
+	LogFormat        string            `json:"LogFormat"`      // Data log format, "text", "csv" or "jsonl"
+	LogSplit         string            `json:"LogSplit"`       // One data log file per "station", "sensor" or "day"
+	LogRotateMB      float64           `json:"LogRotateMB"`    // Rotate a data log file at this size, 0 = never
+	LogRotateDaily   bool              `json:"LogRotateDaily"` // Rotate data log files each day
+	LogCompress      bool              `json:"LogCompress"`    // Gzip rotated data log files
+	LogMaxTotalMB    float64           `json:"LogMaxTotalMB"`  // Delete the oldest rotated and daily files above this total, 0 = no limit
+	CaptureFile      string            `json:"CaptureFile"`    // File raw MQTT messages are captured to
+	Simulator        SimulatorSettings `json:"Simulator"`      // Simulated sensors for demos
+	APIAddr          string            `json:"APIAddr"`        // Address of the JSON API, e.g. "127.0.0.1:8080", "" for none
+}
+
 type Configuration struct {
 	Brokers       map[int]Broker
 	Subscriptions map[int]Subscription
 	ActiveSensors map[string]Sensor
+	Settings      Settings
+	AlertRules    []AlertRule
+	Notifiers     []Notifier
+	Stations      map[string]StationSettings
 }
 
 type DataFile struct {
-	file *os.File
-	path string
+	file      *os.File
+	path      string
+	size      int64     // Bytes in the file
+	day       string    // YYYY-MM-DD the file was started, for daily rotation
+	lastWrite time.Time // Time of the last line written, to close idle files
 }
 
 type ChoicesIntKey struct {
@@ -162,6 +313,21 @@ type weatherWidget struct {
 	lowHumidity       float64
 	latestUpdate      string
 	hasHumidity       bool
+	batteryLow        bool
+	stale             bool
+	dewPoint          float64
+	feelsLike         float64
+	tempTrend         trend
+	humidityTrend     trend
+	hasRain           bool
+	rainDay           float64
+	rain24h           float64
+	rainRate          float64
+	hasWind           bool
+	windSpeed         float64
+	windDir           float64
+	hasWindDir        bool
+	windGust          float64
 	channel           chan string
 	goHandler         func(key string)
 	renderer          *weatherWidgetRenderer
@@ -181,6 +347,17 @@ type weatherWidgetRenderer struct {
 	highHumidity *canvas.Text
 	lowHumidity  *canvas.Text
 	latestUpdate *canvas.Text
+	battery      *canvas.Text
+	stale        *canvas.Text
+	dewPoint     *canvas.Text
+	feelsLike    *canvas.Text
+	tempArrow    *canvas.Image
+	tempRate     *canvas.Text
+	humArrow     *canvas.Image
+	humRate      *canvas.Text
+	rain         *canvas.Text
+	wind         *canvas.Text
+	tempCaption  *canvas.Text
 	objects      []fyne.CanvasObject
 }
 
@@ -190,9 +367,46 @@ var (
 	activeSensorsMutex    sync.Mutex                        // Use to lock reads and writes to the map
 	availableSensorsMutex sync.Mutex                        // Use to lock reads and writes to the map
 	subscriptions         = make(map[int]*Subscription)     // Topics to be subscribed
+	subscriptionsMutex    sync.Mutex                        // Locks subscriptions, read them through copySubscriptions
 	weatherWidgets        = make(map[string]*weatherWidget) // Key is the Sensor key associated with the WW
-	dataFiles             = make(map[string]DataFile)       // Home:DataFile
 	brokers               = make(map[int]Broker)            // Brokers to connect with
+	settings              = Settings{                       // Program options, overridden by config.json
+		MergeStrategy:   "signal",
+		MergeWindowSecs: 2,
+		StaleFactor:     3,
+		TrendWindowMins: 60,
+		RainSeasonStart: 1,
+		HistoryDir:      "history",
+		LogFormat:       "text",
+		LogSplit:        "station",
+		LogRotateMB:     10,
+		LogCompress:     true,
+		LogMaxTotalMB:   500,
+		CaptureFile:     "capture.jsonl",
+		Simulator: SimulatorSettings{
+			Station:          "Demo",
+			Target:           "ingest",
+			DuplicateChance:  0.05,
+			DropoutChance:    0.01,
+			LowBatteryChance: 0.0005,
+		},
+		HistoryRetention: map[string]int{
+			rawResolution: 30,
+			"5m":          365,
+			"1h":          5 * 365,
+			"1d":          0,
+		},
+		DegreeDays: DegreeDaySettings{
+			HeatingBase:      65,
+			CoolingBase:      65,
+			GrowingBase:      50,
+			GrowingCap:       86,
+			ChillLow:         32,
+			ChillHigh:        45,
+			SeasonStart:      1,
+			ChillSeasonStart: 10,
+		},
+	}
 	// brokers               = []Broker{
 	// 	// {"path", 1883, "uid", "pwd"},
 	// }
@@ -224,11 +438,70 @@ func (wd *WeatherData) CopyWDRtoWD(from WeatherDataRaw) {
 	wd.Id = from.Id
 	wd.Channel = from.Channel.channel()
 	wd.Sequence_num = from.Sequence_num
-	wd.Battery_ok = from.Battery_ok
+	if from.Battery_ok != nil {
+		wd.Battery_ok = *from.Battery_ok
+		wd.HasBattery = true
+	}
 	wd.Wind_avg_mi_h = from.Wind_avg_mi_h
 	wd.Temperature_F = from.Temperature_F
 	wd.Humidity = from.Humidity
 	wd.Mic = from.Mic
+	wd.Rssi = from.Rssi
+	wd.Snr = from.Snr
+	wd.Noise = from.Noise
+	wd.Freq = from.Freq
+	wd.Protocol = from.Protocol
+	wd.Mod = from.Mod
+	wd.Rain_in = from.Rain_in
+	wd.Rain_mm = from.Rain_mm
+	wd.Pressure_hPa = from.Pressure_hPa
+	wd.Pressure_inHg = from.Pressure_inHg
+	wd.Pressure_kPa = from.Pressure_kPa
+	wd.Wind_dir_deg = from.Wind_dir_deg
+	wd.Wind_max_mi_h = from.Wind_max_mi_h
+	wd.Wind_avg_km_h = from.Wind_avg_km_h
+	wd.Wind_max_km_h = from.Wind_max_km_h
+	wd.Wind_avg_m_s = from.Wind_avg_m_s
+	wd.Wind_max_m_s = from.Wind_max_m_s
+}
+
+// HasSignal - True if the reading carried rtl_433 reception metadata
+func (wd *WeatherData) HasSignal() bool {
+	return wd.Freq != 0 || wd.Rssi != 0 || wd.Snr != 0
+}
+
+// AddSignal - Fold the reception metadata of a reading into the sensor's signal summary
+func (ss *SignalSummary) AddSignal(wd WeatherData) {
+	if !wd.HasSignal() {
+		return
+	}
+	ss.Readings++
+	n := float64(ss.Readings)
+	if ss.Readings == 1 || wd.Rssi < ss.MinRssi {
+		ss.MinRssi = wd.Rssi
+	}
+	if ss.Readings == 1 || wd.Rssi > ss.MaxRssi {
+		ss.MaxRssi = wd.Rssi
+	}
+	// Running averages
+	ss.AvgRssi += (wd.Rssi - ss.AvgRssi) / n
+	ss.AvgSnr += (wd.Snr - ss.AvgSnr) / n
+	ss.AvgNoise += (wd.Noise - ss.AvgNoise) / n
+	ss.LastRssi = wd.Rssi
+	ss.LastSnr = wd.Snr
+	ss.LastNoise = wd.Noise
+	ss.Freq = wd.Freq
+	ss.Protocol = wd.Protocol
+	ss.Mod = wd.Mod
+}
+
+// FormatSignal - One line summary of reception quality, empty if no metadata was seen
+func (ss *SignalSummary) FormatSignal() string {
+	if ss.Readings == 0 {
+		return ""
+	}
+	return fmt.Sprintf("RSSI %.1f dB (avg %.1f, %.1f..%.1f)  SNR %.1f dB (avg %.1f)  noise %.1f dB  %.3f MHz  %s proto %d",
+		ss.LastRssi, ss.AvgRssi, ss.MinRssi, ss.MaxRssi, ss.LastSnr, ss.AvgSnr, ss.LastNoise, ss.Freq, ss.Mod, ss.Protocol)
 }
 
 // buildSensorKey - Generate the sensor key from the WeatherData structure
@@ -237,6 +510,12 @@ func (wd *WeatherData) BuildSensorKey() string {
 	return key
 }
 
+// BuildPhysicalKey - Identify the sensor itself, regardless of the station that heard it
+func (wd *WeatherData) BuildPhysicalKey() string {
+	key := wd.Model + ":" + strconv.Itoa(wd.Id) + ":" + wd.Channel
+	return key
+}
+
 // Initialize sensor
 func (s *Sensor) init(key string) {
 	s.Key = key
@@ -0,0 +1,151 @@
+/******************************************************************
+ *
+ * Receiver merging - Combines copies of one sensor transmission
+ *      heard by several stations (rtl_433 receivers) into a single
+ *      reading. The copy kept is chosen by signal quality or by
+ *      arrival time, and the reading lists every station that heard it.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type mergeCopy struct {
+	data     WeatherData
+	received time.Time
+}
+
+type mergeGroup struct {
+	copies    []mergeCopy
+	receivers []string
+	flushed   bool // Chosen copy already sent on, later copies are dropped
+}
+
+var (
+	mergeGroups = make(map[string]*mergeGroup) // Physical sensor key : copies waiting to be merged
+	mergeMutex  sync.Mutex
+)
+
+// mergeReading - Collect one copy of a reading. The first copy of a transmission opens a
+// merge window; when it closes, the chosen copy is passed to emit along with the receivers.
+func mergeReading(wd WeatherData, received time.Time, emit func(WeatherData)) {
+	pkey := wd.BuildPhysicalKey()
+	window := mergeWindow()
+
+	mergeMutex.Lock()
+	g, ok := mergeGroups[pkey]
+	if !ok {
+		g = new(mergeGroup)
+		mergeGroups[pkey] = g
+		time.AfterFunc(window, func() {
+			flushMergeGroup(pkey, g, window, emit)
+		})
+	}
+	if !containsString(g.receivers, wd.Station) {
+		g.receivers = append(g.receivers, wd.Station)
+	}
+	if !g.flushed {
+		g.copies = append(g.copies, mergeCopy{wd, received})
+	}
+	mergeMutex.Unlock()
+}
+
+// flushMergeGroup - Send the chosen copy on, then hold the group open for another window
+// so that late copies of the same transmission are not treated as a new reading
+func flushMergeGroup(pkey string, g *mergeGroup, window time.Duration, emit func(WeatherData)) {
+	mergeMutex.Lock()
+	g.flushed = true
+	out := chooseCopy(g.copies, settings.MergeStrategy)
+	out.Receivers = append([]string(nil), g.receivers...)
+	sort.Strings(out.Receivers)
+	mergeMutex.Unlock()
+
+	out.Station = mergedStation(out)
+	emit(out)
+
+	time.AfterFunc(window, func() {
+		mergeMutex.Lock()
+		if mergeGroups[pkey] == g {
+			delete(mergeGroups, pkey)
+		}
+		mergeMutex.Unlock()
+	})
+}
+
+// chooseCopy - Pick the copy to keep. "arrival" keeps the first copy received, any other
+// strategy keeps the strongest copy by RSSI, then SNR, falling back to arrival order.
+func chooseCopy(copies []mergeCopy, strategy string) WeatherData {
+	best := copies[0]
+	for _, c := range copies[1:] {
+		switch strategy {
+		case "arrival":
+			if c.received.Before(best.received) {
+				best = c
+			}
+		default:
+			if c.data.Rssi > best.data.Rssi || (c.data.Rssi == best.data.Rssi && c.data.Snr > best.data.Snr) {
+				best = c
+			}
+		}
+	}
+	return best.data
+}
+
+// mergedStation - Keep a merged sensor under the station it is already known by,
+// preferring an active sensor, then an available one, so its key does not change
+// with whichever receiver heard it best.
+func mergedStation(wd WeatherData) string {
+	pkey := wd.BuildPhysicalKey()
+	activeSensorsMutex.Lock()
+	station, ok := stationForPhysicalKey(pkey, activeSensors)
+	activeSensorsMutex.Unlock()
+	if ok {
+		return station
+	}
+	availableSensorsMutex.Lock()
+	station, ok = stationForPhysicalKey(pkey, availableSensors)
+	availableSensorsMutex.Unlock()
+	if ok {
+		return station
+	}
+	return wd.Station
+}
+
+// stationForPhysicalKey - Find the station part of the first sensor key (sorted) for the physical sensor
+func stationForPhysicalKey(pkey string, sensors map[string]*Sensor) (string, bool) {
+	var found []string
+	for key := range sensors {
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) == 2 && parts[1] == pkey {
+			found = append(found, parts[0])
+		}
+	}
+	if len(found) == 0 {
+		return "", false
+	}
+	sort.Strings(found)
+	return found[0], true
+}
+
+// mergeWindow - Merge window from settings, with a sane default
+func mergeWindow() time.Duration {
+	if settings.MergeWindowSecs <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(settings.MergeWindowSecs * float64(time.Second))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+/******************************************************************
+ *
+ * Degree days - Heating, cooling and growing degree days from each
+ *      day's minimum and maximum temperature, and chill hours, the
+ *      hours spent between ChillLow and ChillHigh. A summary of every
+ *      finished day is kept with the sensor, so totals can be worked
+ *      out again when the base temperatures change. The days can also
+ *      be rebuilt from the recorded history, so days imported or
+ *      recorded before a sensor was marked outdoor count too. The
+ *      Degree Days window shows the outdoor sensors of each station
+ *      and exports the daily values as CSV.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	maxDailySummaries = 800              // About two years of days
+	maxChillGap       = 2 * time.Hour    // Longer silences are not counted as chill
+	degreeDayExport   = "degreedays.csv" // Written to the working directory
+)
+
+// Base temperatures and seasons for degree days, in the Settings of config.json
+type DegreeDaySettings struct {
+	HeatingBase      float64 `json:"HeatingBase"`      // °F, heating degree days count below this
+	CoolingBase      float64 `json:"CoolingBase"`      // °F, cooling degree days count above this
+	GrowingBase      float64 `json:"GrowingBase"`      // °F, growing degree days count above this
+	GrowingCap       float64 `json:"GrowingCap"`       // °F, daily temperatures are capped here for growing degree days
+	ChillLow         float64 `json:"ChillLow"`         // °F, chill hours count from this temperature
+	ChillHigh        float64 `json:"ChillHigh"`        // °F, up to this one
+	SeasonStart      int     `json:"SeasonStart"`      // Month the degree day season begins, 1 = January
+	ChillSeasonStart int     `json:"ChillSeasonStart"` // Month the chill hour season begins
+}
+
+// Temperatures of one finished day
+type DailySummary struct {
+	Date       string  `json:"Date"` // YYYY-MM-DD in the station's time zone
+	MinF       float64 `json:"MinF"`
+	MaxF       float64 `json:"MaxF"`
+	ChillHours float64 `json:"ChillHours"`
+}
+
+// Degree day state of a sensor
+type DegreeDayRecord struct {
+	Days       []DailySummary `json:"Days"`       // Finished days, oldest first
+	ChillToday float64        `json:"ChillToday"` // Chill hours so far today
+	LastTemp   float64        `json:"LastTemp"`   // Latest temperature, for timing chill hours
+	LastTime   time.Time      `json:"LastTime"`   // Zero until the first reading
+}
+
+// Degree days and chill hours over some days
+type degreeDays struct {
+	Days    int
+	Heating float64
+	Cooling float64
+	Growing float64
+	Chill   float64
+}
+
+var degreeDaysFlag bool = false // Degree days window flag. If true, window is open.
+
+// heatingDegreeDays, coolingDegreeDays and growingDegreeDays - Degree days of one day, by the mean temperature
+func heatingDegreeDays(min float64, max float64, base float64) float64 {
+	return math.Max(0, base-(min+max)/2)
+}
+
+func coolingDegreeDays(min float64, max float64, base float64) float64 {
+	return math.Max(0, (min+max)/2-base)
+}
+
+// growingDegreeDays - The maximum and minimum are held between the base and the cap
+// before averaging, as for the usual 86/50 method
+func growingDegreeDays(min float64, max float64, base float64, cap float64) float64 {
+	clamp := func(t float64) float64 {
+		return math.Min(math.Max(t, base), cap)
+	}
+	return (clamp(min)+clamp(max))/2 - base
+}
+
+// addChill - Count the time since the previous reading as chill if the temperature then was
+// in the chill range. Caller holds the lock on the sensor's table.
+func (s *Sensor) addChill(tempF float64, t time.Time) {
+	dd := &s.DegreeDays
+	if !dd.LastTime.IsZero() {
+		gap := t.Sub(dd.LastTime)
+		if gap > 0 && gap <= maxChillGap && dd.LastTemp >= settings.DegreeDays.ChillLow && dd.LastTemp <= settings.DegreeDays.ChillHigh {
+			dd.ChillToday += gap.Hours()
+		}
+	}
+	dd.LastTemp, dd.LastTime = tempF, t
+}
+
+// closeDay - Keep the summary of a finished day. Caller holds the lock on the sensor's table.
+func (s *Sensor) closeDay(day StatPeriod) {
+	dd := &s.DegreeDays
+	dd.Days = append(dd.Days, DailySummary{Date: day.Start, MinF: day.Min, MaxF: day.Max, ChillHours: dd.ChillToday})
+	if len(dd.Days) > maxDailySummaries {
+		dd.Days = dd.Days[len(dd.Days)-maxDailySummaries:]
+	}
+	dd.ChillToday = 0
+}
+
+// daysFromHistory - Summaries of the finished days of a sensor's recorded temperatures from
+// time from, by the station's days. Chill hours are counted by the hour, from each hour's average.
+func daysFromHistory(key string, station string, from time.Time, now time.Time) []DailySummary {
+	loc := stationLocation(station)
+	today := now.In(loc).Format(YYYYMMDD)
+	cfg := settings.DegreeDays
+	byDate := make(map[string]*DailySummary)
+	var dates []string
+	for _, b := range historyAggregate(key, "temperature_F", from, now, time.Hour) {
+		date := b.Start.In(loc).Format(YYYYMMDD)
+		if date >= today {
+			continue
+		}
+		d, ok := byDate[date]
+		if !ok {
+			d = &DailySummary{Date: date, MinF: b.Min, MaxF: b.Max}
+			byDate[date] = d
+			dates = append(dates, date)
+		}
+		d.MinF, d.MaxF = math.Min(d.MinF, b.Min), math.Max(d.MaxF, b.Max)
+		if b.Avg >= cfg.ChillLow && b.Avg <= cfg.ChillHigh {
+			d.ChillHours++
+		}
+	}
+	sort.Strings(dates)
+	days := make([]DailySummary, 0, len(dates))
+	for _, date := range dates {
+		days = append(days, *byDate[date])
+	}
+	return days
+}
+
+// recomputeDegreeDays - Rebuild the finished days of a sensor from the history. Days the
+// history no longer has are kept. Days summarized as they happened keep their chill hours, which
+// are timed by the reading rather than by the hour, and widen to the history's extremes.
+func recomputeDegreeDays(key string, now time.Time) error {
+	activeSensorsMutex.Lock()
+	s, ok := activeSensors[key]
+	station := ""
+	if ok {
+		station = s.Station
+	}
+	activeSensorsMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no active sensor %s", key)
+	}
+	recorded := daysFromHistory(key, station, now.AddDate(0, 0, -maxDailySummaries), now)
+
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	if s, ok = activeSensors[key]; !ok {
+		return fmt.Errorf("no active sensor %s", key)
+	}
+	byDate := make(map[string]DailySummary)
+	for _, d := range s.DegreeDays.Days {
+		byDate[d.Date] = d
+	}
+	for _, d := range recorded {
+		if live, ok := byDate[d.Date]; ok {
+			d.MinF, d.MaxF = math.Min(d.MinF, live.MinF), math.Max(d.MaxF, live.MaxF)
+			d.ChillHours = live.ChillHours
+		}
+		byDate[d.Date] = d
+	}
+	days := make([]DailySummary, 0, len(byDate))
+	for _, d := range byDate {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	if len(days) > maxDailySummaries {
+		days = days[len(days)-maxDailySummaries:]
+	}
+	s.DegreeDays.Days = days
+	return nil
+}
+
+// sumDegreeDays - Totals of the days from date from (YYYY-MM-DD), inclusive, on
+func sumDegreeDays(days []DailySummary, from string) degreeDays {
+	cfg := settings.DegreeDays
+	var total degreeDays
+	for _, d := range days {
+		if d.Date < from {
+			continue
+		}
+		total.Days++
+		total.Heating += heatingDegreeDays(d.MinF, d.MaxF, cfg.HeatingBase)
+		total.Cooling += coolingDegreeDays(d.MinF, d.MaxF, cfg.CoolingBase)
+		total.Growing += growingDegreeDays(d.MinF, d.MaxF, cfg.GrowingBase, cfg.GrowingCap)
+		total.Chill += d.ChillHours
+	}
+	return total
+}
+
+// formatDegreeDays - Summary of an outdoor sensor for the degree days window
+func formatDegreeDays(s *Sensor, now time.Time) string {
+	lt := now.In(stationLocation(s.Station))
+	days := s.DegreeDays.Days
+	yesterday := lt.AddDate(0, 0, -1).Format(YYYYMMDD)
+	var last []DailySummary
+	if n := len(days); n > 0 && days[n-1].Date == yesterday {
+		last = days[n-1:]
+	}
+	season := seasonStart(lt, settings.DegreeDays.SeasonStart)
+	chillSeason := seasonStart(lt, settings.DegreeDays.ChillSeasonStart)
+	rows := []struct {
+		label string
+		dd    degreeDays
+	}{
+		{"Yesterday", sumDegreeDays(last, yesterday)},
+		{"Month", sumDegreeDays(days, lt.Format(YYYYMM)+"-01")},
+		{"Season since " + season, sumDegreeDays(days, season+"-01")},
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%s (%s)\n", s.Name, s.Key))
+	for _, row := range rows {
+		b.WriteString(fmt.Sprintf("   %-20s days %3d   heating %7.1f   cooling %7.1f   growing %7.1f\n",
+			row.label, row.dd.Days, row.dd.Heating, row.dd.Cooling, row.dd.Growing))
+	}
+	chill := sumDegreeDays(days, chillSeason+"-01")
+	b.WriteString(fmt.Sprintf("   %-20s chill hours %.1f, %.1f so far today\n", "Chill since "+chillSeason, chill.Chill, s.DegreeDays.ChillToday))
+	return b.String()
+}
+
+// writeDegreeDaysCSV - Export the daily values of the outdoor sensors
+func writeDegreeDaysCSV(path string, sensors []*Sensor) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg := settings.DegreeDays
+	w := csv.NewWriter(f)
+	w.Write([]string{"station", "sensor", "key", "date", "min_F", "max_F", "heating_dd", "cooling_dd", "growing_dd", "chill_hours"})
+	num := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	for _, s := range sensors {
+		for _, d := range s.DegreeDays.Days {
+			w.Write([]string{s.Station, s.Name, s.Key, d.Date, num(d.MinF), num(d.MaxF),
+				num(heatingDegreeDays(d.MinF, d.MaxF, cfg.HeatingBase)),
+				num(coolingDegreeDays(d.MinF, d.MaxF, cfg.CoolingBase)),
+				num(growingDegreeDays(d.MinF, d.MaxF, cfg.GrowingBase, cfg.GrowingCap)),
+				num(d.ChillHours)})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// outdoorSensors - Copies of the active sensors marked outdoor, by station and name
+func outdoorSensors() []*Sensor {
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	var list []*Sensor
+	for _, s := range activeSensors {
+		if s.Outdoor {
+			c := *s
+			c.DegreeDays.Days = append([]DailySummary(nil), s.DegreeDays.Days...)
+			list = append(list, &c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Station != list[j].Station {
+			return list[i].Station < list[j].Station
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// degreeDaysHandler - Opens a window summarizing degree days by station
+var degreeDaysHandler = func() {
+	if degreeDaysFlag {
+		return
+	}
+	if len(outdoorSensors()) == 0 {
+		SetStatus("No outdoor sensors. Mark sensors as outdoor with Edit Active Sensors.")
+		return
+	}
+	degreeDaysFlag = true
+	summary := widget.NewLabel("")
+	summary.TextStyle = fyne.TextStyle{Monospace: true}
+	fill := func() {
+		cfg := settings.DegreeDays
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Bases: heating %.0f°F, cooling %.0f°F, growing %.0f°F capped at %.0f°F, chill %.0f-%.0f°F\n",
+			cfg.HeatingBase, cfg.CoolingBase, cfg.GrowingBase, cfg.GrowingCap, cfg.ChillLow, cfg.ChillHigh))
+		station := ""
+		for _, s := range outdoorSensors() {
+			if s.Station != station {
+				station = s.Station
+				b.WriteString("\nStation " + station + "\n")
+			}
+			b.WriteString(formatDegreeDays(s, time.Now()))
+		}
+		summary.SetText(b.String())
+	}
+	fill()
+	ddWindow := a.NewWindow("Degree Days")
+	ddWindow.SetOnClosed(func() {
+		degreeDaysFlag = false
+	})
+	export := widget.NewButton("Export CSV", func() {
+		if err := writeDegreeDaysCSV(degreeDayExport, outdoorSensors()); err != nil {
+			SetStatus(fmt.Sprintf("Unable to export degree days: %s", err))
+			return
+		}
+		SetStatus(fmt.Sprintf("Degree days exported to %s", degreeDayExport))
+	})
+	recompute := widget.NewButton("Recompute from History", func() {
+		go func() {
+			for _, s := range outdoorSensors() {
+				if err := recomputeDegreeDays(s.Key, time.Now()); err != nil {
+					SetStatus(fmt.Sprintf("Unable to recompute degree days of %s: %s", s.Name, err))
+				}
+			}
+			SetStatus("Degree days recomputed from the history")
+			fill()
+		}()
+	})
+	scroller := container.NewVScroll(summary)
+	scroller.SetMinSize(fyne.NewSize(800, 400))
+	ddWindow.SetContent(container.NewBorder(nil, container.NewHBox(widget.NewButton("Refresh", fill), recompute, export), nil, nil, scroller))
+	ddWindow.Show()
+}
@@ -0,0 +1,101 @@
+/******************************************************************
+ *
+ * Derived measurements - Dew point, heat index, wind chill,
+ *      feels-like temperature and absolute humidity, computed for
+ *      any reading with temperature and humidity (and wind speed,
+ *      for 5n1 units). They are added to the reading like the
+ *      measurements the sensor reported.
+ *
+ ******************************************************************/
+
+package main
+
+import "math"
+
+// addDerived - Compute the derived measurements the reading's fields allow
+func (wd *WeatherData) addDerived() {
+	t, hasTemp := wd.Measurement("temperature_F")
+	if !hasTemp {
+		return
+	}
+	rh, hasHumidity := wd.Measurement("humidity")
+	mph, hasWind := wd.Measurement("wind_avg_mi_h")
+	if wd.Fields == nil {
+		wd.Fields = make(map[string]bool)
+	}
+	if hasHumidity && rh > 0 {
+		wd.DewPoint_F = dewPointF(t, rh)
+		wd.HeatIndex_F = heatIndexF(t, rh)
+		wd.AbsHumidity_g_m3 = absoluteHumidity(t, rh)
+		wd.Fields["dew_point_F"] = true
+		wd.Fields["heat_index_F"] = true
+		wd.Fields["abs_humidity_g_m3"] = true
+	}
+	if hasWind {
+		wd.WindChill_F = windChillF(t, mph)
+		wd.Fields["wind_chill_F"] = true
+	}
+	wd.FeelsLike_F = feelsLikeF(t, rh, hasHumidity, mph, hasWind)
+	wd.Fields["feels_like_F"] = true
+}
+
+// fToC and cToF - Temperature conversions
+func fToC(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func cToF(c float64) float64 {
+	return c*9/5 + 32
+}
+
+// dewPointF - Dew point by the Magnus formula (Sonntag 1990 constants)
+func dewPointF(tF float64, rh float64) float64 {
+	const b, c = 17.62, 243.12
+	tc := fToC(tF)
+	gamma := math.Log(rh/100) + b*tc/(c+tc)
+	return cToF(c * gamma / (b - gamma))
+}
+
+// heatIndexF - NWS heat index: the simple formula, or the Rothfusz regression
+// with its adjustments once the simple result reaches 80°F
+func heatIndexF(t float64, rh float64) float64 {
+	hi := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+	if (hi+t)/2 < 80 {
+		return (hi + t) / 2
+	}
+	hi = -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh -
+		0.00683783*t*t - 0.05481717*rh*rh + 0.00122874*t*t*rh +
+		0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+	if rh < 13 && t >= 80 && t <= 112 {
+		hi -= ((13 - rh) / 4) * math.Sqrt((17-math.Abs(t-95))/17)
+	} else if rh > 85 && t >= 80 && t <= 87 {
+		hi += ((rh - 85) / 10) * ((87 - t) / 5)
+	}
+	return hi
+}
+
+// windChillF - NWS wind chill, defined at or below 50°F with wind of at least 3 mph
+func windChillF(t float64, mph float64) float64 {
+	if t > 50 || mph < 3 {
+		return t
+	}
+	v := math.Pow(mph, 0.16)
+	return 35.74 + 0.6215*t - 35.75*v + 0.4275*t*v
+}
+
+// feelsLikeF - Heat index when hot, wind chill when cold and windy, otherwise the temperature
+func feelsLikeF(t float64, rh float64, hasHumidity bool, mph float64, hasWind bool) float64 {
+	switch {
+	case hasHumidity && t >= 80:
+		return heatIndexF(t, rh)
+	case hasWind && t <= 50 && mph >= 3:
+		return windChillF(t, mph)
+	}
+	return t
+}
+
+// absoluteHumidity - Grams of water vapour per cubic metre of air
+func absoluteHumidity(tF float64, rh float64) float64 {
+	tc := fToC(tF)
+	return 6.112 * math.Exp(17.67*tc/(tc+243.5)) * rh * 2.1674 / (273.15 + tc)
+}
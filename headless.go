@@ -0,0 +1,50 @@
+/******************************************************************
+ *
+ * Headless - Run the ingest, data log, history, alerts and stats
+ *      without the Fyne GUI, e.g. as a service on a Raspberry Pi
+ *      with no display:
+ *          weatherdashboard -headless [-log]
+ *      Status messages go to standard error as JSON log records,
+ *      and the live feed is logged at debug level. SIGINT or SIGTERM
+ *      flush the data files and history and save the configuration
+ *      as closing the main window does. A missing or broken
+ *      config.json stops it, as there is nobody to ask for the
+ *      broker, and a broker that can't be reached yet, e.g. at boot
+ *      before the network is up, is retried until it can.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Log of status messages when there is no GUI
+var statusLog = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// runHeadless - Run without the GUI until interrupted or terminated, logging data if logData
+func runHeadless(logData bool) {
+	// Unlike the GUI, there is nobody to ask for the broker
+	if err := jsonInput(); err != nil {
+		statusLog.Error("Unable to run headless without a valid config.json", "error", err)
+		os.Exit(1)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	restoreAlerts()
+	logdata_flg = logData
+	startHistory()
+	startWatchers()
+	connectBrokers(true)
+	startAPI()
+	SetStatus("Running headless")
+
+	sig := <-stop
+	statusLog.Info("Shutting down", "signal", sig.String())
+	saveAndClose()
+}
@@ -0,0 +1,74 @@
+/******************************************************************
+ *
+ * History - Every measurement of each active sensor, kept in the
+ *      time series store (store.go) in the HistoryDir directory,
+ *      for the charts, reports and trends, and rolled up into
+ *      coarser resolutions for long ranges (rollups.go).
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var historyStore, _ = newTSStore("") // Memory only until openHistory
+
+// openHistory - Keep history in directory dir from now on
+func openHistory(dir string) error {
+	st, err := newTSStore(dir)
+	if err != nil {
+		return err
+	}
+	historyStore = st
+	return nil
+}
+
+// closeHistory - Flush and close the history files, at exit
+func closeHistory() {
+	historyStore.close()
+}
+
+// watchHistorySync - Flush new history to disk every historySyncInterval. Run as a goroutine.
+func watchHistorySync() {
+	for range time.Tick(historySyncInterval) {
+		historyStore.sync()
+	}
+}
+
+// recordHistory - Remember the measurements of a reading that arrived at time t
+func recordHistory(key string, wd WeatherData, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	values := wd.Measurements()
+	if dir, ok := wd.Measurement("wind_dir_deg"); ok {
+		values["wind_dir_deg"] = dir
+	}
+	for name, v := range values {
+		if err := historyStore.write(key, name, t, v); err != nil {
+			SetStatus(fmt.Sprintf("Unable to record history of %s: %s", key, err))
+			return
+		}
+	}
+}
+
+// historySeries - A sensor's samples of a measurement from time from up to time to
+func historySeries(key string, name string, from time.Time, to time.Time) []sample {
+	return historyStore.read(key, name, from, to)
+}
+
+// historyAggregate - Minimum, maximum and average of a sensor's measurement in buckets
+// of length bucket, from time from up to time to. A bucket of 0 suits the length of the range,
+// down to the raw samples for a day or less.
+func historyAggregate(key string, name string, from time.Time, to time.Time, bucket time.Duration) []historyBucket {
+	return historyStore.query(key, name, from, to, bucket)
+}
+
+// latestHistory - A sensor's most recent value of a measurement, false if there is none
+func latestHistory(key string, name string) (float64, bool) {
+	s, ok := historyStore.latest(key, name)
+	return s.v, ok
+}
@@ -0,0 +1,597 @@
+/******************************************************************
+ *
+ * Importer - Load old readings into the history store from data
+ *      logs in the text format of writeWeatherData, our csv and
+ *      jsonl formats, and csv exports of other tools. A csv file
+ *      needs a header row naming a time column (time, timestamp,
+ *      date or datetime), the sensor (sensor_key or key, or model,
+ *      id and channel, or sensor or name) and measurement columns
+ *      named as in measurementNames; temp, temperature and temp_F
+ *      are taken as temperature_F. Rows are matched to the active
+ *      sensors by key, then by name. A sample within
+ *      importDedupWindow of one already in the history, or of one
+ *      imported before, is a duplicate and skipped, so overlapping
+ *      files can be imported together or again. Once the raw files
+ *      of a day have been pruned, a sample in a bucket of the day's
+ *      rollups counts as a duplicate too. Samples in periods
+ *      already rolled up are added to the rollups too. Readings are
+ *      written in batches of importBatchSize as they are read, so
+ *      years of logs don't have to fit in memory.
+ *
+ *      Run from the Data menu, or as
+ *          weatherdashboard import [-station Home] file...
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	importDedupWindow = 5 * time.Second
+	importExamples    = 10    // Skipped lines listed in the summary
+	importBatchSize   = 10000 // Readings read before they are written to the history
+)
+
+var importFlag bool = false // Import window flag. If true, window is open.
+
+// Column names of other tools' csv exports, and the measurement each holds
+var importAliases = map[string]string{
+	"temp":          "temperature_F",
+	"temperature":   "temperature_F",
+	"temp_f":        "temperature_F",
+	"temperature_f": "temperature_F",
+	"hum":           "humidity",
+	"rh":            "humidity",
+}
+
+// Time layouts accepted in imported files, tried in order
+var importTimeLayouts = []string{
+	time.RFC3339Nano,
+	YYYYMMDD + " " + HHMMSS24h,
+	YYYYMMDD + "T" + HHMMSS24h,
+	YYYYMMDD + " 15:04",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+}
+
+// What an import did
+type importSummary struct {
+	Files      int
+	Lines      int
+	Readings   int            // Lines with a known sensor and a measurement
+	Samples    int            // Measurements written to the history
+	Duplicates int            // Measurements already in the history
+	Skipped    map[string]int // Reason : lines skipped
+	Examples   []string       // The first skipped lines, "file:line: reason"
+}
+
+// An imported reading before it is written
+type importReading struct {
+	key    string
+	t      time.Time
+	values map[string]float64
+}
+
+// skip - Count a skipped line
+func (sum *importSummary) skip(file string, line int, reason string) {
+	if sum.Skipped == nil {
+		sum.Skipped = make(map[string]int)
+	}
+	sum.Skipped[reason]++
+	if len(sum.Examples) < importExamples {
+		sum.Examples = append(sum.Examples, fmt.Sprintf("%s:%d: %s", file, line, reason))
+	}
+}
+
+// String - Summary for the status line or the terminal
+func (sum importSummary) String() string {
+	var b strings.Builder
+	skipped := 0
+	for _, n := range sum.Skipped {
+		skipped += n
+	}
+	b.WriteString(fmt.Sprintf("Imported %d readings (%d samples) from %d files, %d lines. %d duplicate samples, %d lines skipped.",
+		sum.Readings, sum.Samples, sum.Files, sum.Lines, sum.Duplicates, skipped))
+	reasons := make([]string, 0, len(sum.Skipped))
+	for reason := range sum.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		b.WriteString(fmt.Sprintf("\n   %6d %s", sum.Skipped[reason], reason))
+	}
+	for _, ex := range sum.Examples {
+		b.WriteString("\n   " + ex)
+	}
+	return b.String()
+}
+
+// importSensors - Finds the active sensor of an imported line
+type importSensors struct {
+	keys   map[string]bool
+	byName map[string][]*Sensor // Lower case name : sensors
+}
+
+func newImportSensors() importSensors {
+	is := importSensors{keys: make(map[string]bool), byName: make(map[string][]*Sensor)}
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	for key, s := range activeSensors {
+		is.keys[key] = true
+		name := strings.ToLower(s.Name)
+		is.byName[name] = append(is.byName[name], s)
+	}
+	return is
+}
+
+// find - Key of the sensor with key, or failing that the one named name, at station if one is given
+func (is importSensors) find(key string, station string, name string) (string, string) {
+	if is.keys[key] {
+		return key, ""
+	}
+	var found []*Sensor
+	for _, s := range is.byName[strings.ToLower(name)] {
+		if station == "" || s.Station == station || strings.HasPrefix(s.Key, station+":") {
+			found = append(found, s)
+		}
+	}
+	switch {
+	case name == "" && key == "":
+		return "", "no sensor key or name"
+	case len(found) == 1:
+		return found[0].Key, ""
+	case len(found) > 1:
+		return "", "sensor name matches several sensors"
+	}
+	return "", "unknown sensor"
+}
+
+// parseImportTime - Time of an imported line, in the station's time zone unless it has its own
+func parseImportTime(s string, station string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	loc := stationLocation(station)
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+		return time.Unix(0, int64(secs*1e9)), true // Unix time
+	}
+	return time.Time{}, false
+}
+
+// importMeasurement - Measurement name of an imported column or field, "" if it isn't one
+func importMeasurement(name string) string {
+	var wd WeatherData
+	if wd.measurementField(name) != nil {
+		return name
+	}
+	for _, m := range measurementNames {
+		if strings.EqualFold(m, name) {
+			return m
+		}
+	}
+	return importAliases[strings.ToLower(name)]
+}
+
+// parseTextLine - A reading from a line of the text data log
+func parseTextLine(line string, is importSensors, defaultStation string) (importReading, string) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(line, ", ") {
+		if k, v, ok := strings.Cut(part, ": "); ok {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	station := fields["station"]
+	if station == "" {
+		station = defaultStation
+	}
+	t, ok := parseImportTime(fields["time"], station)
+	if !ok {
+		return importReading{}, "bad or missing time"
+	}
+	id, _ := strconv.Atoi(fields["id"])
+	wd := WeatherData{Station: station, Model: fields["model"], Id: id, Channel: fields["channel"]}
+	key := ""
+	if wd.Model != "" {
+		key = wd.BuildSensorKey()
+	}
+	r := importReading{t: t, values: make(map[string]float64)}
+	var reason string
+	if r.key, reason = is.find(key, station, fields["sensor"]); reason != "" {
+		return r, reason
+	}
+	for k, v := range fields {
+		name := importMeasurement(k)
+		if name == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return r, "bad number for " + k
+		}
+		r.values[name] = f
+	}
+	// The text log always has temp and humidity, 0 when the sensor has none
+	for _, name := range []string{"temperature_F", "humidity"} {
+		if r.values[name] == 0 {
+			delete(r.values, name)
+		}
+	}
+	return r, ""
+}
+
+// parseJSONLine - A reading from a line of the jsonl data log
+func parseJSONLine(line string, is importSensors) (importReading, string) {
+	var rec logRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return importReading{}, "bad JSON"
+	}
+	t, ok := parseImportTime(rec.Time, rec.Station)
+	if !ok {
+		return importReading{}, "bad or missing time"
+	}
+	r := importReading{t: t, values: make(map[string]float64)}
+	var reason string
+	if r.key, reason = is.find(rec.SensorKey, rec.Station, rec.Sensor); reason != "" {
+		return r, reason
+	}
+	for k, v := range rec.Measurements {
+		if name := importMeasurement(k); name != "" {
+			r.values[name] = v
+		}
+	}
+	return r, ""
+}
+
+// csvColumns - Column positions of a csv header
+type csvColumns struct {
+	time, station, key, name, model, id, channel int
+	measurements                                 map[int]string // Column : measurement
+}
+
+func newCSVColumns(header []string) (csvColumns, bool) {
+	c := csvColumns{time: -1, station: -1, key: -1, name: -1, model: -1, id: -1, channel: -1, measurements: make(map[int]string)}
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		switch strings.ToLower(h) {
+		case "time", "timestamp", "date", "datetime":
+			c.time = i
+		case "station":
+			c.station = i
+		case "sensor_key", "key":
+			c.key = i
+		case "sensor", "name":
+			c.name = i
+		case "model":
+			c.model = i
+		case "id":
+			c.id = i
+		case "channel":
+			c.channel = i
+		default:
+			if name := importMeasurement(h); name != "" {
+				c.measurements[i] = name
+			}
+		}
+	}
+	return c, c.time >= 0 && len(c.measurements) > 0
+}
+
+// parseCSVRow - A reading from a csv row
+func (c csvColumns) parseCSVRow(row []string, is importSensors, defaultStation string) (importReading, string) {
+	col := func(i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	station := col(c.station)
+	if station == "" {
+		station = defaultStation
+	}
+	t, ok := parseImportTime(col(c.time), station)
+	if !ok {
+		return importReading{}, "bad or missing time"
+	}
+	key := col(c.key)
+	if key == "" && col(c.model) != "" {
+		id, _ := strconv.Atoi(col(c.id))
+		wd := WeatherData{Station: station, Model: col(c.model), Id: id, Channel: col(c.channel)}
+		key = wd.BuildSensorKey()
+	}
+	r := importReading{t: t, values: make(map[string]float64)}
+	var reason string
+	if r.key, reason = is.find(key, station, col(c.name)); reason != "" {
+		return r, reason
+	}
+	for i, name := range c.measurements {
+		v := col(i)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return r, "bad number for " + name
+		}
+		r.values[name] = f
+	}
+	return r, ""
+}
+
+// readImportFile - Read the readings of one file, by its format, passing them to batch
+// importBatchSize at a time
+func readImportFile(path string, is importSensors, defaultStation string, sum *importSummary, batch func([]importReading) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum.Files++
+	var readings []importReading
+	add := func(line int, r importReading, reason string) error {
+		if reason == "" && len(r.values) == 0 {
+			reason = "no measurements"
+		}
+		if reason != "" {
+			sum.skip(path, line, reason)
+			return nil
+		}
+		readings = append(readings, r)
+		if len(readings) < importBatchSize {
+			return nil
+		}
+		err := batch(readings)
+		readings = readings[:0]
+		return err
+	}
+	flush := func() error {
+		if len(readings) == 0 {
+			return nil
+		}
+		return batch(readings)
+	}
+	br := bufio.NewReader(f)
+	first, _ := br.Peek(512)
+	if strings.HasSuffix(strings.ToLower(path), ".csv") || (!strings.HasPrefix(string(first), "station: ") && !strings.HasPrefix(string(first), "{")) {
+		cr := csv.NewReader(br)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return fmt.Errorf("%s: no csv header: %w", path, err)
+		}
+		sum.Lines++
+		cols, ok := newCSVColumns(header)
+		if !ok {
+			return fmt.Errorf("%s: csv header needs a time column and a measurement column", path)
+		}
+		for line := 2; ; line++ {
+			row, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			sum.Lines++
+			if err != nil {
+				sum.skip(path, line, "bad csv")
+				continue
+			}
+			r, reason := cols.parseCSVRow(row, is, defaultStation)
+			if err := add(line, r, reason); err != nil {
+				return err
+			}
+		}
+		return flush()
+	}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		sum.Lines++
+		var r importReading
+		var reason string
+		switch {
+		case text == "":
+			sum.Lines--
+			continue
+		case strings.HasPrefix(text, "{"):
+			r, reason = parseJSONLine(text, is)
+		default:
+			r, reason = parseTextLine(text, is, defaultStation)
+		}
+		if err := add(line, r, reason); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// importFiles - Load the readings of files into the history. Lines without a station
+// are taken to be from defaultStation.
+func importFiles(paths []string, defaultStation string) (importSummary, error) {
+	var sum importSummary
+	is := newImportSensors()
+	temperatures := make(map[string]bool) // Sensors with imported temperatures
+	batch := func(readings []importReading) error {
+		sum.Readings += len(readings)
+		for _, r := range readings {
+			if _, ok := r.values["temperature_F"]; ok {
+				temperatures[r.key] = true
+			}
+		}
+		return importBatch(readings, &sum)
+	}
+	for _, path := range paths {
+		if err := readImportFile(path, is, defaultStation, &sum, batch); err != nil {
+			return sum, err
+		}
+	}
+	historyStore.sync()
+	// The imported days count toward the degree days of outdoor sensors
+	for _, s := range outdoorSensors() {
+		if temperatures[s.Key] {
+			if err := recomputeDegreeDays(s.Key, time.Now()); err != nil {
+				return sum, err
+			}
+		}
+	}
+	return sum, nil
+}
+
+// importBatch - Write readings to the history, skipping duplicates of samples already there,
+// including those of earlier batches
+func importBatch(readings []importReading, sum *importSummary) error {
+	series := make(map[[2]string][]sample) // Sensor key, measurement : samples
+	for _, r := range readings {
+		for name, v := range r.values {
+			id := [2]string{r.key, name}
+			series[id] = append(series[id], sample{r.t, v})
+		}
+	}
+	for id, samples := range series {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].t.Before(samples[j].t) })
+		from, to := samples[0].t.Add(-importDedupWindow), samples[len(samples)-1].t.Add(importDedupWindow)
+		known := historyStore.read(id[0], id[1], from, to) // Oldest first
+		pruned := make(map[string]func(time.Time) bool)    // UTC day : test of the rollups, nil if not pruned
+		var added []sample
+		for _, s := range samples {
+			day := s.t.UTC().Format(storeDayFormat)
+			rolledUp, ok := pruned[day]
+			if !ok {
+				rolledUp = historyStore.prunedRollups(id[0], id[1], s.t)
+				pruned[day] = rolledUp
+			}
+			if nearSample(known, s.t) || nearSample(added, s.t) || (rolledUp != nil && rolledUp(s.t)) {
+				sum.Duplicates++
+				continue
+			}
+			if err := historyStore.write(id[0], id[1], s.t, s.v); err != nil {
+				return err
+			}
+			added = append(added, s)
+			sum.Samples++
+		}
+		if err := historyStore.mergeRollups(id[0], id[1], added); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nearSample - Whether samples, oldest first, has one within importDedupWindow of time t
+func nearSample(samples []sample, t time.Time) bool {
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].t.Before(t.Add(-importDedupWindow)) })
+	return i < len(samples) && !samples[i].t.After(t.Add(importDedupWindow))
+}
+
+// importCommand - The import command: load files into the history of the configured sensors
+func importCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	station := fs.String("station", "", "Station of lines that don't name one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: weatherdashboard import [-station name] file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := jsonInput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read config.json: %s\n", err)
+		return 1
+	}
+	if settings.HistoryDir == "" {
+		fmt.Fprintln(os.Stderr, "HistoryDir is not set in config.json, nowhere to import to")
+		return 1
+	}
+	if err := openHistory(settings.HistoryDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open history in %s: %s\n", settings.HistoryDir, err)
+		return 1
+	}
+	defer closeHistory()
+	sum, err := importFiles(fs.Args(), *station)
+	fmt.Println(sum)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import stopped: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// importHandler - Opens a window to import data logs into the history
+var importHandler = func() {
+	if importFlag {
+		return
+	}
+	importFlag = true
+	filesEntry := widget.NewMultiLineEntry()
+	filesEntry.SetPlaceHolder("Files to import, one per line, e.g. ./WeatherData-Home.txt")
+	stationEntry := widget.NewSelectEntry(stationNames())
+	stationEntry.SetPlaceHolder("Station of lines that don't name one")
+	result := widget.NewLabel("")
+	result.TextStyle = fyne.TextStyle{Monospace: true}
+	importWindow := a.NewWindow("Import History")
+	importWindow.SetOnClosed(func() {
+		importFlag = false
+	})
+	var importButton *widget.Button
+	importButton = widget.NewButton("Import", func() {
+		var paths []string
+		for _, p := range strings.Split(filesEntry.Text, "\n") {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) == 0 {
+			SetStatus("No files to import")
+			return
+		}
+		importButton.Disable()
+		result.SetText("Importing...")
+		go func() {
+			sum, err := importFiles(paths, strings.TrimSpace(stationEntry.Text))
+			text := sum.String()
+			if err != nil {
+				text += "\nImport stopped: " + err.Error()
+			}
+			result.SetText(text)
+			importButton.Enable()
+			SetStatus(strings.SplitN(text, "\n", 2)[0])
+		}()
+	})
+	filesScroller := container.NewVScroll(filesEntry)
+	filesScroller.SetMinSize(fyne.NewSize(600, 100))
+	importWindow.SetContent(container.NewVBox(
+		widget.NewLabel("Data logs (text, csv or jsonl) to load into the history of the active sensors"),
+		filesScroller,
+		stationEntry,
+		container.NewHBox(importButton, widget.NewButton("Close", func() {
+			importWindow.Close()
+		})),
+		result,
+	))
+	importWindow.Show()
+}
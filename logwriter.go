@@ -0,0 +1,287 @@
+/******************************************************************
+ *
+ * Log writer - Files of the data log. A file is opened when the
+ *      first reading for it is written, so nothing is opened while
+ *      logging is off and new stations get files on demand. A file
+ *      is rotated when it would grow past LogRotateMB, or on the
+ *      first write of a new day if LogRotateDaily is set: it is
+ *      renamed with the time of rotation, e.g.
+ *          WeatherData-Home.20240618-153000.txt
+ *      and gzipped in the background if LogCompress is set. A file
+ *      not written for logIdleClose is closed, e.g. yesterday's file
+ *      of the "day" split or the old file of a renamed sensor. Files
+ *      this writer made that aren't open, rotated files and the
+ *      dated files of the "day" split, are deleted, oldest first,
+ *      while the data log files take more than LogMaxTotalMB. Other
+ *      files, e.g. data logs kept from before, count toward the
+ *      total but are never deleted.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFilePrefix     = "WeatherData-"
+	logRotationFormat = "20060102-150405"
+	bytesPerMB        = 1 << 20
+	logIdleClose      = 10 * time.Minute // Files not written for this long are closed
+)
+
+// The open data log files
+type logWriter struct {
+	mutex       sync.Mutex
+	files       map[string]*DataFile // File path : open file
+	compressing sync.WaitGroup       // Rotated files being gzipped and old files trimmed
+}
+
+var dataLog = newLogWriter()
+
+func newLogWriter() *logWriter {
+	return &logWriter{files: make(map[string]*DataFile)}
+}
+
+// write - Append line to the file at path, opening or rotating it first as needed.
+// A file that is new or empty starts with header.
+func (lw *logWriter) write(path string, header string, line string, now time.Time) error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	d, err := lw.open(path, now)
+	if err != nil {
+		return err
+	}
+	if lw.rotationDue(d, int64(len(line)), now) {
+		if err := lw.rotate(d, now); err != nil {
+			return err
+		}
+		if d, err = lw.open(path, now); err != nil {
+			return err
+		}
+	}
+	if d.size == 0 {
+		line = header + line
+	}
+	n, err := d.file.WriteString(line)
+	d.size += int64(n)
+	d.lastWrite = now
+	lw.closeIdle(path, now)
+	return err
+}
+
+// closeIdle - Close the files other than current not written for logIdleClose, then trim
+// the old files in the background. Caller holds the lock.
+func (lw *logWriter) closeIdle(current string, now time.Time) {
+	closed := false
+	for path, d := range lw.files {
+		if path != current && now.Sub(d.lastWrite) > logIdleClose {
+			d.file.Sync()
+			d.file.Close()
+			delete(lw.files, path)
+			closed = true
+		}
+	}
+	if !closed {
+		return
+	}
+	if maxTotal := int64(settings.LogMaxTotalMB * bytesPerMB); maxTotal > 0 {
+		lw.compressing.Add(1)
+		go func() {
+			defer lw.compressing.Done()
+			if err := lw.trim(filepath.Dir(current), maxTotal); err != nil {
+				SetStatus(fmt.Sprintf("Unable to remove old data logs: %s", err))
+			}
+		}()
+	}
+}
+
+// trim - Delete the data logs in dir that aren't open, oldest first, while they take more
+// than maxTotal bytes
+func (lw *logWriter) trim(dir string, maxTotal int64) error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	open := make(map[string]bool)
+	for path := range lw.files {
+		open[filepath.Clean(path)] = true
+	}
+	return trimLogFiles(dir, maxTotal, open)
+}
+
+// open - The open file at path, opening it for appending if it isn't. Caller holds the lock.
+func (lw *logWriter) open(path string, now time.Time) (*DataFile, error) {
+	if d, ok := lw.files[path]; ok {
+		return d, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// A file kept from an earlier day counts as that day's for daily rotation
+	day := now.Format(YYYYMMDD)
+	if info.Size() > 0 {
+		day = info.ModTime().Format(YYYYMMDD)
+	}
+	d := &DataFile{file: f, path: path, size: info.Size(), day: day, lastWrite: now}
+	lw.files[path] = d
+	return d, nil
+}
+
+// rotationDue - Whether a file must be rotated before n more bytes are written at time now
+func (lw *logWriter) rotationDue(d *DataFile, n int64, now time.Time) bool {
+	if d.size == 0 {
+		return false
+	}
+	if limit := int64(settings.LogRotateMB * bytesPerMB); limit > 0 && d.size+n > limit {
+		return true
+	}
+	return settings.LogRotateDaily && d.day != now.Format(YYYYMMDD)
+}
+
+// rotate - Close a file and rename it with the time, then compress it and trim the old files
+// in the background. Caller holds the lock.
+func (lw *logWriter) rotate(d *DataFile, now time.Time) error {
+	d.file.Sync()
+	d.file.Close()
+	delete(lw.files, d.path)
+	ext := filepath.Ext(d.path)
+	rotated := strings.TrimSuffix(d.path, ext) + "." + now.Format(logRotationFormat) + ext
+	if err := os.Rename(d.path, rotated); err != nil {
+		return err
+	}
+	compress, maxTotal := settings.LogCompress, int64(settings.LogMaxTotalMB*bytesPerMB)
+	lw.compressing.Add(1)
+	go func() {
+		defer lw.compressing.Done()
+		if compress {
+			if err := gzipFile(rotated); err != nil {
+				SetStatus(fmt.Sprintf("Unable to compress %s: %s", rotated, err))
+			}
+		}
+		if maxTotal > 0 {
+			if err := lw.trim(filepath.Dir(d.path), maxTotal); err != nil {
+				SetStatus(fmt.Sprintf("Unable to remove old data logs: %s", err))
+			}
+		}
+	}()
+	return nil
+}
+
+// close - Sync and close the open files, after waiting for compression to finish
+func (lw *logWriter) close() {
+	lw.compressing.Wait()
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	for path, d := range lw.files {
+		d.file.Sync()
+		d.file.Close()
+		delete(lw.files, path)
+	}
+}
+
+// gzipFile - Replace a file with its gzipped copy, path.gz
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// trimLogFiles - Delete rotated and daily data logs in dir, oldest first, while all data
+// logs there take more than maxTotal bytes. The files in open are kept.
+func trimLogFiles(dir string, maxTotal int64, open map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type logInfo struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var old []logInfo
+	total := int64(0)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), logFilePrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		path := filepath.Join(dir, e.Name())
+		if !open[path] && (isRotatedLog(e.Name()) || isDailyLog(e.Name())) {
+			old = append(old, logInfo{path, info.Size(), info.ModTime()})
+		}
+	}
+	sort.Slice(old, func(i, j int) bool { return old[i].modTime.Before(old[j].modTime) })
+	for _, r := range old {
+		if total <= maxTotal {
+			break
+		}
+		if err := os.Remove(r.path); err != nil {
+			return err
+		}
+		total -= r.size
+	}
+	return nil
+}
+
+// isRotatedLog - Whether a data log file name has a rotation time, as rotate gives it
+func isRotatedLog(name string) bool {
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	dot := strings.LastIndexByte(name, '.')
+	if dot < 0 {
+		return false
+	}
+	_, err := time.Parse(logRotationFormat, name[dot+1:])
+	return err == nil
+}
+
+// isDailyLog - Whether a data log file name ends with a date, as the "day" split names it
+func isDailyLog(name string) bool {
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if len(name) < len(YYYYMMDD)+1 || name[len(name)-len(YYYYMMDD)-1] != '-' {
+		return false
+	}
+	_, err := time.Parse(YYYYMMDD, name[len(name)-len(YYYYMMDD):])
+	return err == nil
+}
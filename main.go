@@ -7,10 +7,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -63,6 +65,26 @@ var (
 
 func main() {
 
+	// Subcommands work on the configuration and history without the GUI
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+		if !strings.HasPrefix(os.Args[1], "-") {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", os.Args[1])
+			usage()
+			os.Exit(2)
+		}
+	}
+	flag.Usage = usage
+	headless := flag.Bool("headless", false, "Run without the GUI, logging status to standard error")
+	logData := flag.Bool("log", false, "Start with data logging on")
+	flag.Parse()
+	if *headless {
+		runHeadless(*logData)
+		return
+	}
+
 	//**********************************
 	// Set up Fyne window before trying to write to Status line!!!
 	//**********************************
@@ -117,17 +139,30 @@ func main() {
 		}
 	})
 
+	mergeReceiversItem := fyne.NewMenuItem("Merge Receivers", nil)
+	mergeReceiversItem.ChildMenu = fyne.NewMenu("",
+		fyne.NewMenuItem("On", mergeReceiversOnHandler),
+		fyne.NewMenuItem("Off", mergeReceiversOffHandler),
+		fyne.NewMenuItem("Keep Best Signal", mergeBySignalHandler),
+		fyne.NewMenuItem("Keep First Arrival", mergeByArrivalHandler),
+	)
+
 	sensorMenu := fyne.NewMenu("Sensors",
 		listActiveSensorsItem,
 		listAvailableSensorsItem,
 		addActiveSensorItem,
 		editActiveSensorItem,
 		removeActiveSensorItem,
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Battery Status", batteryStatusHandler),
+		mergeReceiversItem,
+		fyne.NewMenuItem("Station Settings", stationSettingsHandler),
+		fyne.NewMenuItem("Virtual Sensors", virtualSensorsHandler),
 	)
 
 	listTopicsItem := fyne.NewMenuItem("List", func() {
 		if !listTopicsFlag {
-			chooseTopics("Current Subscribed Topics", subscriptions, ListTopics)
+			chooseTopics("Current Subscribed Topics", copySubscriptions(), ListTopics)
 		}
 	})
 	addTopicItem := fyne.NewMenuItem("New", addTopicHandler)
@@ -136,21 +171,38 @@ func main() {
 
 	dataDisplayItem := fyne.NewMenuItem("Station Data Live Feed", scrollDataHandler)
 	dashboardItem := fyne.NewMenuItem("Dashboard Widgets", dashboardHandler)
+	windRoseItem := fyne.NewMenuItem("Wind Rose", func() {
+		windRoseHandler("")
+	})
 	dataMenuSeparator := fyne.NewMenuItemSeparator()
 	toggleDataLoggingOnItem := fyne.NewMenuItem("Data Logging On", dataLoggingOnHandler)
 	toggleDataLoggingOffItem := fyne.NewMenuItem("DataLogging Off", dataLoggingOffHandler)
 	dataMenu := fyne.NewMenu("Data",
 		dataDisplayItem,
 		dashboardItem,
+		windRoseItem,
+		fyne.NewMenuItem("Degree Days", degreeDaysHandler),
 		dataMenuSeparator,
 		toggleDataLoggingOnItem,
 		toggleDataLoggingOffItem,
+		fyne.NewMenuItem("Data Log Format", logSettingsHandler),
+		fyne.NewMenuItem("Import History", importHandler),
+		fyne.NewMenuItem("Capture and Replay", captureReplayHandler),
+		fyne.NewMenuItem("Simulator", simulatorHandler),
 	)
 
 	zoomPlusViewItem := fyne.NewMenuItem("Zoom +", zoomPlusHandler)
 	zoomMinusViewItem := fyne.NewMenuItem("Zoom -", zoomMinusHandler)
 	// themeLightItem := fyne.NewMenuItem("Light", themeLightHandler)
 	// themeDarkItem := fyne.NewMenuItem("Dark", themeDarkHandler)
+	alertsMenu := fyne.NewMenu("Alerts",
+		fyne.NewMenuItem("Active Alerts", activeAlertsHandler),
+		fyne.NewMenuItem("Alert History", alertHistoryHandler),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Alert Rules", alertRulesHandler),
+		fyne.NewMenuItem("Test Notifiers", testNotifiersHandler),
+	)
+
 	viewMenu := fyne.NewMenu("View",
 		zoomPlusViewItem,
 		zoomMinusViewItem,
@@ -158,7 +210,7 @@ func main() {
 		// themeDarkItem,
 	)
 
-	menu := fyne.NewMainMenu(dataMenu, sensorMenu, subscriptionsMenu, viewMenu)
+	menu := fyne.NewMainMenu(dataMenu, sensorMenu, subscriptionsMenu, alertsMenu, viewMenu)
 
 	w.SetMainMenu(menu)
 	menu.Refresh()
@@ -186,6 +238,7 @@ func main() {
 	)
 
 	mainContainer := container.NewVBox(
+		alertBannerBox, // Active alerts, shown only while there are any
 		widget.NewLabel("Dashboard Status Scrolling Window"),
 		statusContainer,
 	)
@@ -197,10 +250,52 @@ func main() {
 	// Read configuration file
 	//**********************************
 	readConfig()
+	logdata_flg = *logData
+	startHistory()
 
 	// Build the widgets used by the dashboard before activating the GUI
 	generateWeatherWidgets()
 
+	startWatchers()
+	connectBrokers(false)
+	startAPI()
+
+	//**********************************
+	// Turn over control to the GUI
+	//**********************************
+	w.SetOnClosed(exitHandler)
+
+	w.ShowAndRun()
+
+	//*************************************************
+	// NOTE! Program blocked until GUI closes
+	//*************************************************
+}
+
+// startHistory - Keep the history of readings on disk, rolled up and synced in the background
+func startHistory() {
+	if settings.HistoryDir != "" {
+		if err := openHistory(settings.HistoryDir); err != nil {
+			SetStatus(fmt.Sprintf("Unable to open history in %s, keeping it in memory: %s", settings.HistoryDir, err))
+		} else {
+			go watchHistoryRollups()
+		}
+	}
+	go watchHistorySync()
+}
+
+// startWatchers - Start the background checks of the sensors
+func startWatchers() {
+	// Watch for sensors that stop transmitting
+	go watchStaleSensors()
+
+	// Start each day's statistics at midnight
+	go watchStatsRollover()
+}
+
+// connectBrokers - Connect to the configured brokers, subscribing to the topics on connect.
+// If retry, keep trying a broker that can't be reached in the background instead of closing.
+func connectBrokers(retry bool) {
 	//**********************************
 	// Set configuration for MQTT
 	//**********************************
@@ -212,10 +307,19 @@ func main() {
 		opts.SetPassword(b.Pwd)
 		opts.OnConnect = connectHandler
 		opts.OnConnectionLost = connectLostHandler
+		opts.SetAutoReconnect(true)
 
 		//**********************************
 		// Initialize MQTT client
 		//**********************************
+		if retry {
+			opts.SetConnectRetry(true)
+			opts.SetConnectRetryInterval(brokerRetryInterval)
+			Client = mqtt.NewClient(opts)
+			Client.Connect() // Completes once connected, connectHandler subscribes then
+			SetStatus(fmt.Sprintf("Connecting to broker %s:%d, retrying every %s until it is reachable", b.Path, b.Port, brokerRetryInterval))
+			continue
+		}
 		Client = mqtt.NewClient(opts)
 		if token := Client.Connect(); token.Wait() && token.Error() != nil {
 			SetStatus("Error connecting with broker. Closing program.")
@@ -226,16 +330,6 @@ func main() {
 		st := t.Format(YYYYMMDD + " " + HHMMSS24h)
 		SetStatus(fmt.Sprintf("%s : Client connected to broker %s", st, brokers[0].Path+":"+strconv.Itoa(brokers[0].Port)))
 	}
-	//**********************************
-	// Turn over control to the GUI
-	//**********************************
-	w.SetOnClosed(exitHandler)
-
-	w.ShowAndRun()
-
-	//*************************************************
-	// NOTE! Program blocked until GUI closes
-	//*************************************************
 }
 
 //*************************************************
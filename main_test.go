@@ -1,7 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 var t_outgoing1 = WeatherData{
@@ -39,3 +51,928 @@ func TestMaps(t *testing.T) {
 			" actual value (%s)", "Acurite-606TX", t_model)
 	}
 }
+
+func TestChooseCopy(t *testing.T) {
+	now := time.Now()
+	house := t_outgoing1
+	house.Station = "house"
+	house.Rssi = -8.2
+	barn := t_outgoing1
+	barn.Station = "barn"
+	barn.Rssi = -1.5
+	copies := []mergeCopy{{house, now}, {barn, now.Add(time.Second)}}
+
+	if best := chooseCopy(copies, "signal"); best.Station != "barn" {
+		t.Errorf("Expected strongest copy (%s) is not same as"+
+			" actual copy (%s)", "barn", best.Station)
+	}
+	if first := chooseCopy(copies, "arrival"); first.Station != "house" {
+		t.Errorf("Expected first copy (%s) is not same as"+
+			" actual copy (%s)", "house", first.Station)
+	}
+}
+
+func TestMergeReading(t *testing.T) {
+	saved := settings
+	defer func() { settings = saved }()
+	settings.MergeWindowSecs = 0.05
+	settings.MergeStrategy = "signal"
+	out := make(chan WeatherData, 2)
+	emit := func(wd WeatherData) { out <- wd }
+
+	house := t_outgoing1
+	house.Station = "house"
+	house.Rssi = -8.2
+	barn := t_outgoing1
+	barn.Station = "barn"
+	barn.Rssi = -1.5
+	mergeReading(house, time.Now(), emit)
+	mergeReading(barn, time.Now(), emit)
+
+	merged := <-out
+	if len(merged.Receivers) != 2 || merged.Receivers[0] != "barn" || merged.Receivers[1] != "house" {
+		t.Errorf("Expected receivers (barn, house) are not same as"+
+			" actual receivers (%v)", merged.Receivers)
+	}
+	select {
+	case extra := <-out:
+		t.Errorf("Expected one merged reading, also got reading from %s", extra.Station)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestSignalSummary(t *testing.T) {
+	var ss SignalSummary
+	wd := t_outgoing1
+	ss.AddSignal(wd) // No metadata, ignored
+	wd.Rssi, wd.Snr, wd.Noise, wd.Freq = -10, 15, -25, 433.92
+	ss.AddSignal(wd)
+	wd.Rssi, wd.Snr = -4, 21
+	ss.AddSignal(wd)
+	if ss.Readings != 2 || ss.MinRssi != -10 || ss.MaxRssi != -4 || ss.AvgRssi != -7 || ss.AvgSnr != 18 {
+		t.Errorf("Unexpected signal summary %+v", ss)
+	}
+}
+
+func TestUpdateBattery(t *testing.T) {
+	var s Sensor
+	wd := t_outgoing1
+	wd.HasBattery = true
+	if changed, _ := s.updateBattery(wd); !changed || s.BatteryLow() {
+		t.Errorf("Expected first battery report to be recorded as ok")
+	}
+	wd.Battery_ok = 0
+	if _, wentLow := s.updateBattery(wd); !wentLow || !s.BatteryLow() {
+		t.Errorf("Expected battery to change from ok to low")
+	}
+	if changed, _ := s.updateBattery(wd); changed || len(s.BatteryHistory) != 2 {
+		t.Errorf("Expected unchanged battery to leave history (%d events) alone", len(s.BatteryHistory))
+	}
+}
+
+func TestStaleSensor(t *testing.T) {
+	key := "barn:Acurite-Tower:42:B"
+	s := &Sensor{Key: key}
+	start := staleWatchStart.Truncate(time.Second).Add(time.Minute) // LastHeard keeps whole seconds
+	for i := 0; i < 4; i++ {
+		s.heard(start.Add(time.Duration(i) * 30 * time.Second))
+	}
+	if s.LearnedInterval != 30 {
+		t.Errorf("Expected learned interval (30) is not same as"+
+			" actual interval (%.1f)", s.LearnedInterval)
+	}
+	activeSensors[key] = s
+	defer delete(activeSensors, key)
+	last := start.Add(90 * time.Second)
+	if gone := checkStaleSensors(last.Add(60 * time.Second)); len(gone) != 0 {
+		t.Errorf("Sensor went offline after two missed intervals")
+	}
+	if gone := checkStaleSensors(last.Add(100 * time.Second)); len(gone) != 1 || gone[0].Key != key || !s.Offline {
+		t.Errorf("Expected sensor to go offline after three missed intervals")
+	}
+	if !s.heard(last.Add(120*time.Second)) || s.Offline {
+		t.Errorf("Expected sensor to come back online when heard")
+	}
+}
+
+func TestAlertRuleHysteresis(t *testing.T) {
+	r := AlertRule{Name: "Barn freeze", SensorKey: "*", Measurement: "temperature_F", Condition: "below", Threshold: 34, Hysteresis: 1}
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bad := (AlertRule{Name: "Barn:freeze", SensorKey: "*", Measurement: "temperature_F", Condition: "below"}); bad.validate() == nil {
+		t.Error("Expected a rule name with ':' to be rejected")
+	}
+	st := new(ruleState)
+	now := time.Now()
+	cases := []struct {
+		v           float64
+		holds, ends bool
+	}{
+		{33.5, true, false},
+		{34.5, false, false}, // Above threshold but within hysteresis, keeps firing
+		{35.2, false, true},
+	}
+	for _, c := range cases {
+		if _, holds, ends := r.test(st, now, c.v); holds != c.holds || ends != c.ends {
+			t.Errorf("At %.1f expected holds %t ends %t, got holds %t ends %t", c.v, c.holds, c.ends, holds, ends)
+		}
+	}
+}
+
+func TestAlertRuleRate(t *testing.T) {
+	r := AlertRule{Name: "Furnace", SensorKey: "*", Measurement: "temperature_F", Condition: "falling", Threshold: 2, RateWindowSecs: 3600}
+	st := new(ruleState)
+	start := time.Now()
+	var holds bool
+	for i := 0; i <= 6; i++ {
+		_, holds, _ = r.test(st, start.Add(time.Duration(i)*10*time.Minute), 68-float64(i)*0.5) // 3 degrees per hour
+	}
+	if !holds {
+		t.Errorf("Expected falling rule to hold at 3 degrees per hour")
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	r := AlertRule{QuietStart: "22:00", QuietEnd: "07:00"}
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.Local)
+	if !r.inQuietHours(day.Add(23*time.Hour)) || !r.inQuietHours(day.Add(6*time.Hour)) || r.inQuietHours(day.Add(12*time.Hour)) {
+		t.Errorf("Quiet hours spanning midnight not handled")
+	}
+}
+
+func TestDerived(t *testing.T) {
+	wd := WeatherData{Temperature_F: 90, Humidity: 60, Fields: map[string]bool{"temperature_F": true, "humidity": true}}
+	wd.addDerived()
+	if wd.DewPoint_F < 74 || wd.DewPoint_F > 76 {
+		t.Errorf("Dew point at 90F 60%% expected about 75F, got %.1f", wd.DewPoint_F)
+	}
+	if wd.HeatIndex_F < 99 || wd.HeatIndex_F > 101 || wd.FeelsLike_F != wd.HeatIndex_F {
+		t.Errorf("Heat index at 90F 60%% expected about 100F, got %.1f feels like %.1f", wd.HeatIndex_F, wd.FeelsLike_F)
+	}
+	if _, ok := wd.Measurement("wind_chill_F"); ok {
+		t.Errorf("Wind chill computed without wind")
+	}
+	if wc := windChillF(20, 15); wc < 5 || wc > 7 {
+		t.Errorf("Wind chill at 20F 15 mph expected about 6F, got %.1f", wc)
+	}
+}
+
+func TestStatsRollover(t *testing.T) {
+	s := &Sensor{Key: "k"}
+	day := time.Date(2024, 3, 31, 0, 0, 0, 0, time.Local)
+	for i, v := range []float64{41, 55, 48} {
+		s.updateStats(WeatherData{Temperature_F: v}, day.Add(time.Duration(8+4*i)*time.Hour))
+	}
+	if s.LowTemp != 41 || s.HighTemp != 55 {
+		t.Errorf("Expected today's lo/hi 41/55, got %.1f/%.1f", s.LowTemp, s.HighTemp)
+	}
+	s.Temp = 48
+	if !s.rollStats(day.Add(24*time.Hour + time.Minute)) {
+		t.Fatalf("Expected a new day after midnight")
+	}
+	ms := s.Stats["temperature_F"]
+	if ms.Today.Count != 0 || ms.Yesterday.Max != 55 || ms.Yesterday.MaxTime != "2024-03-31 12:00:00" || s.HighTemp != 48 {
+		t.Errorf("Day not rolled over: %+v, hi %.1f", ms, s.HighTemp)
+	}
+	if ms.Month.Start != "2024-04" || ms.Month.Count != 0 || ms.AllTime.Count != 3 || ms.AllTime.Avg() != 48 {
+		t.Errorf("Month or all time wrong: %+v", ms)
+	}
+}
+
+func TestTrend(t *testing.T) {
+	start := time.Now()
+	for i := 0; i <= 6; i++ {
+		addTrendSamples("trend", WeatherData{Temperature_F: 68 - float64(i), Humidity: 40}, start.Add(time.Duration(i)*10*time.Minute))
+	}
+	if tr := trendOf("trend", "temperature_F"); !tr.Ok || tr.Direction != trendFalling || tr.Rate > -5.9 || tr.Rate < -6.1 {
+		t.Errorf("Expected falling 6 degrees per hour, got %+v", tr)
+	}
+	if tr := trendOf("trend", "humidity"); !tr.Ok || tr.Direction != trendSteady {
+		t.Errorf("Expected steady humidity, got %+v", tr)
+	}
+	trendMutex.Lock()
+	n := len(trendSamples["trend"]["temperature_F"])
+	trendMutex.Unlock()
+	if n != 7 {
+		t.Errorf("Expected 7 samples inside the window, got %d", n)
+	}
+}
+
+func TestRainCounterReset(t *testing.T) {
+	s := &Sensor{Key: "gauge"}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	counters := []float64{10.00, 10.05, 10.15, 0.02, 0.10} // Batteries changed before the fourth reading
+	var wd WeatherData
+	for i, c := range counters {
+		wd = WeatherData{Rain_in: c, Fields: map[string]bool{"rain_in": true}}
+		s.updateRain(&wd, start.Add(time.Duration(i)*5*time.Minute))
+	}
+	if d := s.Rain.Day; d < 0.2499 || d > 0.2501 {
+		t.Errorf("Expected 0.25 in today, got %.4f", d)
+	}
+	if s.Rain.Resets != 1 {
+		t.Errorf("Expected one counter reset, got %d", s.Rain.Resets)
+	}
+	// All the rain fell within the last 15 minutes, four times that per hour
+	if r := wd.RainRate_in_h; r < 0.9999 || r > 1.0001 {
+		t.Errorf("Expected rain rate 1.0 in/h, got %.4f", r)
+	}
+	wd = WeatherData{Rain_mm: 5.08, Fields: map[string]bool{"rain_mm": true}} // 0.2 in
+	s.updateRain(&wd, start.Add(25*time.Hour))
+	if s.Rain.Day < 0.0999 || s.Rain.Day > 0.1001 || s.Rain.Month < 0.3499 || s.Rain.Month > 0.3501 || wd.Rain24h_in != s.Rain.Day {
+		t.Errorf("Expected a new day with the month total kept, got day %.4f month %.4f 24h %.4f", s.Rain.Day, s.Rain.Month, wd.Rain24h_in)
+	}
+}
+
+func TestSeaLevelAndZambretti(t *testing.T) {
+	wd := WeatherData{Pressure_inHg: 29.53, Temperature_F: 59, Fields: map[string]bool{"pressure_inHg": true, "temperature_F": true}}
+	wd.addPressure(300)
+	if p := wd.SeaLevel_hPa; p < 1034.5 || p > 1036.5 {
+		t.Errorf("Expected about 1035.6 hPa at sea level, got %.1f", p)
+	}
+	if z := zambretti(1020, trendSteady, 0, false, false, time.July); z != 'B' {
+		t.Errorf("Expected B for steady 1020 hPa, got %c", z)
+	}
+	if z := zambretti(1000, trendFalling, 0, false, false, time.January); z != 'X' {
+		t.Errorf("Expected X for falling 1000 hPa in winter, got %c", z)
+	}
+	start := time.Now().Add(-2 * time.Hour)
+	for i := 0; i <= 8; i++ {
+		w := WeatherData{SeaLevel_hPa: 1010 - float64(i)*0.5, Fields: map[string]bool{"sea_level_hPa": true}}
+		updateForecast("forecast", w, start.Add(time.Duration(i)*15*time.Minute))
+	}
+	if fc := forecastFor("forecast", time.Now()); !fc.Ok || fc.Trend != trendFalling || fc.Change > -5.9 || fc.Change < -6.1 {
+		t.Errorf("Expected falling 6 hPa in 3 hours, got %+v", fc)
+	}
+}
+
+func TestWind(t *testing.T) {
+	wd := WeatherData{Wind_avg_km_h: 16.0934, Fields: map[string]bool{"wind_avg_km_h": true}}
+	wd.addWind()
+	if v, ok := wd.Measurement("wind_avg_mi_h"); !ok || v < 9.99 || v > 10.01 {
+		t.Errorf("Expected 10 mph from 16.09 km/h, got %.2f %t", v, ok)
+	}
+	if dir, ok := averageDirection([]sample{{v: 350}, {v: 10}}); !ok || compassPoint(dir) != "N" {
+		t.Errorf("Expected north averaging 350 and 10 degrees, got %.1f", dir)
+	}
+	now := time.Now()
+	speeds := []sample{{now, 0.5}, {now.Add(time.Minute), 7}, {now.Add(2 * time.Minute), 12}, {now.Add(3 * time.Minute), 3}}
+	dirs := []sample{{now, 90}, {now.Add(time.Minute), 225}, {now.Add(2 * time.Minute), 230}, {now.Add(4 * time.Minute), 0}}
+	wr := newWindRose(speeds, dirs)
+	if wr.total != 3 || wr.calm != 1 || wr.counts[10][1] != 1 || wr.counts[10][2] != 1 || wr.maxSector() != 2 {
+		t.Errorf("Unexpected wind rose counts: total %d calm %d SW %v", wr.total, wr.calm, wr.counts[10])
+	}
+}
+
+func TestDegreeDays(t *testing.T) {
+	if hdd := heatingDegreeDays(40, 60, 65); hdd != 15 {
+		t.Errorf("Expected 15 heating degree days, got %.1f", hdd)
+	}
+	if gdd := growingDegreeDays(45, 95, 50, 86); gdd != 18 {
+		t.Errorf("Expected 18 growing degree days with 86/50 caps, got %.1f", gdd)
+	}
+	s := &Sensor{Key: "dd"}
+	day := time.Date(2024, 11, 4, 0, 0, 0, 0, time.Local)
+	for i, v := range []float64{34, 40, 50, 44} { // Two hours in the chill range, one above, then 44 until after midnight
+		s.updateStats(WeatherData{Temperature_F: v}, day.Add(time.Duration(20+i)*time.Hour))
+	}
+	s.updateStats(WeatherData{Temperature_F: 38}, day.Add(24*time.Hour+30*time.Minute))
+	days := s.DegreeDays.Days
+	if len(days) != 1 || days[0].Date != "2024-11-04" || days[0].MinF != 34 || days[0].MaxF != 50 || days[0].ChillHours != 2 {
+		t.Fatalf("Unexpected daily summaries %+v", days)
+	}
+	if s.DegreeDays.ChillToday != 1.5 {
+		t.Errorf("Expected the hour and a half across midnight to count for the new day, got %.2f", s.DegreeDays.ChillToday)
+	}
+	if dd := sumDegreeDays(days, "2024-11-01"); dd.Days != 1 || dd.Heating != 23 {
+		t.Errorf("Expected 23 heating degree days in November, got %+v", dd)
+	}
+
+	// Days in the history, e.g. imported, are added; chill hours counted live are kept
+	st, err := newTSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *tsStore) { historyStore = saved }(historyStore)
+	historyStore = st
+	saved := settings
+	defer func() { settings = saved }()
+	settings.DegreeDays.ChillLow, settings.DegreeDays.ChillHigh = 32, 45
+	for i, v := range []float64{30, 36, 55} { // 3 November, one hour in the chill range
+		st.write(s.Key, "temperature_F", day.Add(time.Duration(i-12)*time.Hour), v)
+	}
+	st.write(s.Key, "temperature_F", day.Add(20*time.Hour), 36) // 4 November, summarized already
+	activeSensorsMutex.Lock()
+	activeSensors[s.Key] = s
+	activeSensorsMutex.Unlock()
+	defer func() {
+		activeSensorsMutex.Lock()
+		delete(activeSensors, s.Key)
+		activeSensorsMutex.Unlock()
+	}()
+	if err := recomputeDegreeDays(s.Key, day.AddDate(0, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	days = s.DegreeDays.Days
+	if len(days) != 2 || days[0].Date != "2024-11-03" || days[0].MinF != 30 || days[0].MaxF != 55 || days[0].ChillHours != 1 {
+		t.Fatalf("Expected 3 November from the history, got %+v", days)
+	}
+	if days[1].Date != "2024-11-04" || days[1].ChillHours != 2 || days[1].MinF != 34 {
+		t.Errorf("Expected 4 November from the history with its chill hours kept, got %+v", days[1])
+	}
+}
+
+func TestVirtualExpression(t *testing.T) {
+	readings := map[string][]float64{
+		"name:Upstairs*":   {68, 70, 75},
+		"Outdoor":          {40},
+		"location:Freezer": {-2, 1},
+	}
+	resolve := func(ref virtualRef) []float64 {
+		return readings[ref.selector]
+	}
+	cases := []struct {
+		src  string
+		want float64
+	}{
+		{`avg(temperature_F("name:Upstairs*"))`, 71},
+		{`temperature_F("Outdoor") - avg(temperature_F("name:Upstairs*"))`, -31},
+		{`max(temperature_F("location:Freezer"))`, 1},
+		{`-(temperature_F("Outdoor") - 32) * 5 / 9`, -40.0 / 9},
+		{`count(temperature_F("name:Upstairs*"), temperature_F("Outdoor"))`, 4},
+	}
+	for _, c := range cases {
+		vp, err := parseVirtual(c.src)
+		if err != nil {
+			t.Errorf("%s: %s", c.src, err)
+			continue
+		}
+		if v, err := vp.evaluate(resolve); err != nil || math.Abs(v-c.want) > 1e-9 {
+			t.Errorf("%s: expected %.3f, got %.3f %v", c.src, c.want, v, err)
+		}
+	}
+	for _, src := range []string{`temperature_F("name:Upstairs*") + 1`, `temperature_F("Attic")`} {
+		vp, err := parseVirtual(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+		if _, err := vp.evaluate(resolve); err == nil {
+			t.Errorf("%s: expected an error for a reference without exactly one value", src)
+		}
+	}
+	for _, src := range []string{``, `1 + 2`, `avg(`, `bogus("x")`, `temperature_F(Outdoor)`} {
+		if _, err := parseVirtual(src); err == nil {
+			t.Errorf("%q: expected a parse error", src)
+		}
+	}
+	s := &Sensor{Key: "Home:Acurite:1:A", Name: "Upstairs Bed", Location: "Freezer chest", Station: "Home"}
+	if !virtualSelects("name:upstairs*", s) || !virtualSelects("location:Freezer*", s) || virtualSelects("station:Barn", s) {
+		t.Error("Unexpected selector matching")
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	s := &Sensor{Calibration: map[string]Calibration{
+		"temperature_F": {Offset: -1.5},
+		"humidity":      {Offset: 5, Scale: 1.1},
+		"wind_dir_deg":  {Offset: 20},
+	}}
+	wd := WeatherData{Temperature_F: 71.5, Humidity: 90, Wind_dir_deg: 350,
+		Fields: map[string]bool{"temperature_F": true, "humidity": true, "wind_dir_deg": true}}
+	s.calibrate(&wd)
+	if wd.Temperature_F != 70 || wd.Humidity != 100 || math.Abs(wd.Wind_dir_deg-10) > 1e-9 {
+		t.Errorf("Unexpected calibrated values %.2f %.2f %.2f", wd.Temperature_F, wd.Humidity, wd.Wind_dir_deg)
+	}
+	if wd.Raw["temperature_F"] != 71.5 || wd.Raw["humidity"] != 90 || wd.Raw["wind_dir_deg"] != 350 {
+		t.Errorf("Expected raw values to be kept, got %v", wd.Raw)
+	}
+	if _, err := parseCalibration("x", ""); err == nil {
+		t.Error("Expected an error for a bad offset")
+	}
+	if c, err := parseCalibration(" 1 ", ""); err != nil || c.apply(2) != 3 {
+		t.Errorf("Expected an offset of 1 with the default scale, got %+v %v", c, err)
+	}
+}
+
+func TestHistoryStore(t *testing.T) {
+	dir := t.TempDir()
+	st, err := newTSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 23, 50, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ { // Across midnight, so two day files
+		if err := st.write("Home:Acurite-606TX:237:A", "temperature_F", start.Add(time.Duration(i)*5*time.Minute), float64(60+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st.close()
+
+	// A torn record at the end of the second day and a corrupted one in the first
+	series := filepath.Join(dir, storeEscape("Home:Acurite-606TX:237:A"), "temperature_F")
+	f, _ := os.OpenFile(filepath.Join(series, "20240302.dat"), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+	first := filepath.Join(series, "20240301.dat")
+	data, _ := os.ReadFile(first)
+	data[8] ^= 0xff
+	os.WriteFile(first, data, 0644)
+
+	st, _ = newTSStore(dir)
+	got := st.read("Home:Acurite-606TX:237:A", "temperature_F", start, start.Add(time.Hour))
+	if len(got) != 5 || got[0].v != 61 || got[4].v != 65 || st.corrupt.Load() != 1 {
+		t.Fatalf("Expected 5 good samples after skipping a corrupt one, got %v (%d corrupt)", got, st.corrupt.Load())
+	}
+	if err := st.write("Home:Acurite-606TX:237:A", "temperature_F", start.Add(30*time.Minute), 66); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := st.latest("Home:Acurite-606TX:237:A", "temperature_F"); !ok || s.v != 66 {
+		t.Errorf("Expected latest 66, got %v %t", s, ok)
+	}
+	ts := st.series[storeEscape("Home:Acurite-606TX:237:A")+"/temperature_F"]
+	st.sync() // Written since the last sync, kept open
+	if ts.file == nil {
+		t.Error("Expected the file written to be kept open")
+	}
+	st.sync() // Not written since, closed
+	if ts.file != nil {
+		t.Error("Expected the idle file to be closed")
+	}
+	st.close()
+	if info, _ := os.Stat(filepath.Join(series, "20240302.dat")); info.Size() != 5*storeRecordSize {
+		t.Errorf("Expected the torn record to be cut off, file is %d bytes", info.Size())
+	}
+	buckets := aggregate(got, start, 10*time.Minute)
+	if len(buckets) != 3 || buckets[0].Count != 1 || buckets[1].Min != 62 || buckets[1].Max != 63 || buckets[1].Avg != 62.5 {
+		t.Errorf("Unexpected buckets %+v", buckets)
+	}
+}
+
+func TestHistoryRollups(t *testing.T) {
+	st, err := newTSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "Home:Acurite-606TX:237:A"
+	start := time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)
+	for i := 0; i < 180; i++ { // Three hours, a reading a minute
+		st.write(key, "temperature_F", start.Add(time.Duration(i)*time.Minute), float64(i%60))
+	}
+	if _, err := st.rollup(start.Add(3*time.Hour + 2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(st.dir, storeEscape(key), "temperature_F")
+	if fives := st.readRollups(dir, rollupResolutions[0], start, start.Add(3*time.Hour)); len(fives) != 36 || fives[1].Min != 5 || fives[1].Max != 9 || fives[1].Avg != 7 {
+		t.Fatalf("Expected 36 five minute buckets, got %d %+v", len(fives), fives)
+	}
+	hours := st.readRollups(dir, rollupResolutions[1], start, start.Add(3*time.Hour))
+	if len(hours) != 3 || hours[2].Count != 60 || hours[2].Max != 59 || hours[2].Avg != 29.5 {
+		t.Fatalf("Expected 3 hourly buckets, got %+v", hours)
+	}
+	// A month on, both raw day files expire while the rollups stay
+	defer func(saved map[string]int) { settings.HistoryRetention = saved }(settings.HistoryRetention)
+	settings.HistoryRetention = map[string]int{rawResolution: 30}
+	if n, err := st.rollup(start.AddDate(0, 1, 5)); err != nil || n != 2 {
+		t.Fatalf("Expected the raw day files to be removed, removed %d %v", n, err)
+	}
+	st.mutex.Lock()
+	st.series = make(map[string]*tsSeries) // Forget the memory copy
+	st.mutex.Unlock()
+	got := st.query(key, "temperature_F", start, start.Add(3*time.Hour), time.Hour)
+	if len(got) != 3 || got[0].Count != 60 || got[0].Min != 0 || got[0].Max != 59 {
+		t.Errorf("Expected hourly buckets from the rollups, got %+v", got)
+	}
+	if daily := st.query(key, "temperature_F", start, start.AddDate(0, 0, 120), 0); len(daily) != 2 || daily[0].Count != 120 || daily[1].Count != 60 {
+		t.Errorf("Expected a daily bucket for each UTC day of a long range, got %+v", daily)
+	}
+}
+
+func TestLogFormats(t *testing.T) {
+	saved := settings
+	defer func() { settings = saved }()
+	wd := WeatherData{Time: "2024-06-17 19:16:31", Model: "Acurite-606TX", Id: 237, Channel: "A", Station: "Home", SensorName: "Porch",
+		Temperature_F: 70.5, Humidity: 41, HasBattery: true, Battery_ok: 1, Received: time.Date(2024, 6, 18, 0, 16, 31, 0, time.UTC),
+		Fields: map[string]bool{"temperature_F": true, "humidity": true}, Raw: map[string]float64{"temperature_F": 72}}
+
+	out := formatLogCSVHeader() + formatLogCSV(wd)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "time,station,sensor_key,") {
+		t.Fatalf("Expected a header and a row, got %q", out)
+	}
+	header, row := strings.Split(lines[0], ","), strings.Split(lines[1], ",")
+	if len(header) != len(row) || row[len(logCSVFields)] != "70.5" || row[len(logCSVFields)+1] != "41" || row[len(logCSVFields)+2] != "" {
+		t.Errorf("Unexpected csv row %q", lines[1])
+	}
+	if _, err := time.Parse(time.RFC3339, row[0]); err != nil {
+		t.Errorf("Expected an ISO-8601 time, got %q", row[0])
+	}
+	rainy := wd
+	rainy.RainDay_in, rainy.Fields = 0.04, map[string]bool{"rain_day_in": true}
+	if text := formatDerived(rainy); text != ", rain_day_in: 0.04" {
+		t.Errorf("Expected rain to hundredths of an inch, got %q", text)
+	}
+
+	line, err := formatLogJSON(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec logRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.SensorKey != "Home:Acurite-606TX:237:A" || rec.Measurements["temperature_F"] != 70.5 || rec.Raw["temperature_F"] != 72 || rec.BatteryOk == nil || !*rec.BatteryOk {
+		t.Errorf("Unexpected jsonl record %s", line)
+	}
+
+	settings.LogFormat, settings.LogSplit = "jsonl", "sensor"
+	if p := logFilePath(wd); p != "./WeatherData-Home-Porch.jsonl" {
+		t.Errorf("Unexpected per sensor file %s", p)
+	}
+	settings.LogFormat, settings.LogSplit = "csv", "day"
+	if p := logFilePath(wd); !strings.HasPrefix(p, "./WeatherData-Home-2024-06-1") || !strings.HasSuffix(p, ".csv") {
+		t.Errorf("Unexpected per day file %s", p)
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	saved := settings
+	defer func() { settings = saved }()
+	settings.LogRotateMB, settings.LogRotateDaily, settings.LogCompress, settings.LogMaxTotalMB = 100.0/bytesPerMB, true, true, 0
+	dir := t.TempDir()
+	path := filepath.Join(dir, logFilePrefix+"Home.csv")
+	lw := newLogWriter()
+	now := time.Date(2024, 6, 18, 12, 0, 0, 0, time.Local)
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 3; i++ { // Header and two lines fit, the third rotates for size
+		if err := lw.write(path, "header\n", line, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lw.write(path, "header\n", line, now.Add(24*time.Hour)); err != nil { // A new day rotates again
+		t.Fatal(err)
+	}
+	lw.close()
+	if data, _ := os.ReadFile(path); string(data) != "header\n"+line {
+		t.Errorf("Expected the current file to start over with the header, got %q", data)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, logFilePrefix+"Home.*.csv.gz"))
+	if len(rotated) != 2 {
+		t.Fatalf("Expected two gzipped rotated files, got %v", rotated)
+	}
+	if !isRotatedLog(filepath.Base(rotated[0])) || isRotatedLog(logFilePrefix+"Home.csv") {
+		t.Error("Rotated file names not recognized")
+	}
+	os.Chtimes(rotated[0], now, now) // The older of the two
+	current, _ := os.Stat(path)
+	newer, _ := os.Stat(rotated[1])
+	if err := trimLogFiles(dir, current.Size()+newer.Size(), map[string]bool{path: true}); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, logFilePrefix+"Home.*.csv.gz")); len(left) != 1 || left[0] != rotated[1] {
+		t.Errorf("Expected the oldest rotated file to be removed, left %v", left)
+	}
+
+	// Yesterday's file of the day split is closed when idle, and trimmed
+	settings.LogRotateMB, settings.LogRotateDaily, settings.LogMaxTotalMB = 0, false, 1.0/bytesPerMB
+	dir = t.TempDir()
+	yesterday := filepath.Join(dir, logFilePrefix+"Home-2024-06-17.csv")
+	today := filepath.Join(dir, logFilePrefix+"Home-2024-06-18.csv")
+	kept := filepath.Join(dir, logFilePrefix+"Home.txt") // A data log from before, never deleted
+	os.WriteFile(kept, []byte(line), 0644)
+	os.Chtimes(kept, now.AddDate(0, 0, -10), now.AddDate(0, 0, -10))
+	lw = newLogWriter()
+	lw.write(yesterday, "header\n", line, now)
+	lw.write(today, "header\n", line, now.Add(logIdleClose+time.Minute))
+	lw.compressing.Wait()
+	if _, open := lw.files[yesterday]; open || len(lw.files) != 1 {
+		t.Errorf("Expected only today's file open, got %d", len(lw.files))
+	}
+	if _, err := os.Stat(yesterday); !os.IsNotExist(err) {
+		t.Errorf("Expected yesterday's file to be removed above the total")
+	}
+	lw.close()
+	if _, err := os.Stat(today); err != nil {
+		t.Errorf("Expected today's open file to be kept: %s", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("Expected the data log from before to be kept: %s", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	st, err := newTSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *tsStore) { historyStore = saved }(historyStore)
+	historyStore = st
+	key := "Home:Acurite-606TX:237:A"
+	activeSensorsMutex.Lock()
+	activeSensors[key] = &Sensor{Key: key, Model: "Acurite-606TX", Id: 237, Channel: "A", Station: "Home", Name: "Porch"}
+	activeSensorsMutex.Unlock()
+	defer func() {
+		activeSensorsMutex.Lock()
+		delete(activeSensors, key)
+		activeSensorsMutex.Unlock()
+	}()
+	// A sample already in the history, rolled up
+	loc := stationLocation("Home")
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, loc)
+	st.write(key, "temperature_F", start, 50)
+	if _, err := st.rollup(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	text := filepath.Join(dir, "WeatherData-Home.txt")
+	os.WriteFile(text, []byte(strings.Join([]string{
+		"station: Home, sensor: Porch, location: , temp: 50.0, humidity: 0.0, time: 2024-03-01 10:00:02, model: Acurite-606TX, id: 237, channel: A",
+		"station: Home, sensor: Porch, location: , temp: 52.0, humidity: 0.0, time: 2024-03-01 10:01:00, model: Acurite-606TX, id: 237, channel: A",
+		"station: Home, sensor: Shed, location: , temp: 40.0, humidity: 0.0, time: 2024-03-01 10:01:00, model: LaCrosse-TX141, id: 9, channel: ",
+		"station: Home, sensor: Porch, location: , temp: 53.0, humidity: 0.0, time: yesterday, model: Acurite-606TX, id: 237, channel: A",
+	}, "\n")+"\n"), 0644)
+	export := filepath.Join(dir, "export.csv")
+	os.WriteFile(export, []byte("Timestamp,Sensor,Temp,Humidity\n"+
+		"2024-03-01 10:01,porch,52.0,\n"+ // Also in the text log
+		"2024-03-01 10:06,porch,54.5,40\n"), 0644)
+	sum, err := importFiles([]string{text, export}, "Home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Files != 2 || sum.Lines != 7 || sum.Readings != 4 || sum.Samples != 3 || sum.Duplicates != 2 {
+		t.Errorf("Expected 4 readings of 2 files, 3 samples and 2 duplicates, got %+v", sum)
+	}
+	if sum.Skipped["unknown sensor"] != 1 || sum.Skipped["bad or missing time"] != 1 || len(sum.Examples) != 2 {
+		t.Errorf("Expected an unknown sensor and a bad time skipped, got %v %v", sum.Skipped, sum.Examples)
+	}
+	if got := st.read(key, "temperature_F", start, start.Add(time.Hour)); len(got) != 3 || got[1].v != 52 || got[2].v != 54.5 {
+		t.Errorf("Expected the imported temperatures in the history, got %+v", got)
+	}
+	fives := st.readRollups(filepath.Join(st.dir, storeEscape(key), "temperature_F"), rollupResolutions[0], start, start.Add(time.Hour))
+	if len(fives) != 1 || fives[0].Count != 2 || fives[0].Max != 52 {
+		t.Errorf("Expected imported samples merged into the rollups, got %+v", fives)
+	}
+	// Importing again adds nothing
+	if sum, _ := importFiles([]string{text, export}, "Home"); sum.Samples != 0 || sum.Duplicates != 5 {
+		t.Errorf("Expected every sample to be a duplicate the second time, got %+v", sum)
+	}
+	// Nor once the raw files are pruned and only the rollups are left
+	saved := settings
+	defer func() { settings = saved }()
+	settings.HistoryRetention = map[string]int{rawResolution: 30, "5m": 365, "1h": 0, "1d": 0}
+	if _, err := st.rollup(start.AddDate(0, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got := st.read(key, "temperature_F", start, start.Add(time.Hour)); len(got) != 0 {
+		t.Fatalf("Expected the raw samples pruned, got %+v", got)
+	}
+	if sum, _ := importFiles([]string{text, export}, "Home"); sum.Samples != 0 || sum.Duplicates != 5 {
+		t.Errorf("Expected every sample to be a duplicate of the rollups, got %+v", sum)
+	}
+	hours := st.readRollups(filepath.Join(st.dir, storeEscape(key), "temperature_F"), rollupResolutions[1], start, start.Add(time.Hour))
+	if len(hours) != 1 || hours[0].Count != 3 {
+		t.Errorf("Expected the hour's rollup unchanged, got %+v", hours)
+	}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	cw := new(captureWriter)
+	if err := cw.start(path); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 6, 18, 15, 30, 0, 0, time.UTC)
+	payloads := []string{`{"model":"Acurite-606TX","id":237,"temperature_C":20.5}`, "\xff\xfe", `{"model":"Acurite-606TX","id":237,"temperature_C":20.6}`}
+	for i, p := range payloads {
+		cw.record("tcp://pi:1883", "Home/rtl_433", []byte(p), start.Add(time.Duration(i)*30*time.Second))
+	}
+	cw.stop()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not a capture line\n")
+	f.Close()
+
+	var got []*replayMessage
+	var slept []time.Duration
+	rp := newReplayer(10, false)
+	rp.sleep = func(d time.Duration) { slept = append(slept, d) }
+	rp.handler = func(msg mqtt.Message) { got = append(got, msg.(*replayMessage)) }
+	result, err := rp.replayFile(path)
+	if err != nil || result.Messages != 3 || result.Bad != 1 {
+		t.Fatalf("Expected 3 messages and a bad line, got %+v %v", result, err)
+	}
+	for i, m := range got {
+		if string(m.payload) != payloads[i] || m.topic != "Home/rtl_433" || !m.received.Equal(start.Add(time.Duration(i)*30*time.Second)) {
+			t.Errorf("Message %d replayed as %q %s %s", i, m.payload, m.topic, m.received)
+		}
+	}
+	if len(slept) != 2 || slept[0] != 3*time.Second {
+		t.Errorf("Expected two waits of 3s at 10x, got %v", slept)
+	}
+	// As fast as possible, shifted to start now
+	got, slept = nil, nil
+	rp = newReplayer(0, true)
+	rp.sleep = func(d time.Duration) { slept = append(slept, d) }
+	rp.now = func() time.Time { return start.Add(24 * time.Hour) }
+	rp.handler = func(msg mqtt.Message) { got = append(got, msg.(*replayMessage)) }
+	if _, err := rp.replayFile(path); err != nil || len(slept) != 0 || len(got) != 3 || !got[2].received.Equal(start.Add(24*time.Hour+time.Minute)) {
+		t.Errorf("Expected a shifted replay without waits, got %d messages, waits %v, %v", len(got), slept, err)
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	opts := SimulatorSettings{Station: "Demo", Seed: 42, DuplicateChance: 0.1, DropoutChance: 0.05, LowBatteryChance: 0.01}
+	start := time.Date(2024, 6, 18, 0, 0, 0, 0, stationLocation("Demo"))
+	sim := newSimulator(opts, start)
+	type got struct {
+		topic string
+		wd    WeatherDataRaw
+		t     time.Time
+	}
+	var sent []got
+	sim.send = func(topic string, payload []byte, t time.Time) error {
+		var wd WeatherDataRaw
+		if err := json.Unmarshal(payload, &wd); err != nil {
+			return err
+		}
+		sent = append(sent, got{topic, wd, t})
+		return nil
+	}
+	n, err := sim.step(start.Add(24 * time.Hour))
+	if err != nil || n != len(sent) {
+		t.Fatalf("Expected every message sent, got %d of %d, %v", n, len(sent), err)
+	}
+	models := make(map[string]int)
+	temps := make(map[string]map[int][]float64) // Model : hour : temperatures
+	lowBattery, duplicates := 0, 0
+	for i, m := range sent {
+		if m.topic != "Demo/rtl_433/simulator/events" {
+			t.Fatalf("Unexpected topic %s", m.topic)
+		}
+		models[m.wd.Model]++
+		if temps[m.wd.Model] == nil {
+			temps[m.wd.Model] = make(map[int][]float64)
+		}
+		temps[m.wd.Model][m.t.Hour()] = append(temps[m.wd.Model][m.t.Hour()], m.wd.Temperature_F)
+		if m.wd.Battery_ok != nil && *m.wd.Battery_ok == 0 {
+			lowBattery++
+		}
+		if m.wd.Model != "Acurite-Tower" && i > 0 && sent[i-1].wd.Model == m.wd.Model && sent[i-1].t.Equal(m.t) {
+			duplicates++
+		}
+	}
+	// A day of readings every 30s, 50s and 16s, less the dropouts
+	if models["Acurite-Tower"] < 3*2880*9/10 || models["LaCrosse-TX141THBv2"] < 1728*9/10 || models["Fineoffset-WH24"] < 5400*9/10 {
+		t.Errorf("Expected about a day of readings of each sensor, got %v", models)
+	}
+	mean := func(v []float64) float64 { return sumOf(v) / float64(len(v)) }
+	if afternoon, night := mean(temps["Acurite-Tower"][15]), mean(temps["Acurite-Tower"][3]); afternoon < night+20 {
+		t.Errorf("Expected a warm afternoon and a cool night, got %.1f and %.1f", afternoon, night)
+	}
+	if lowBattery == 0 || duplicates == 0 {
+		t.Errorf("Expected low battery readings and duplicate bursts, got %d and %d", lowBattery, duplicates)
+	}
+	// The same seed gives the same readings
+	again := newSimulator(opts, start)
+	count := 0
+	again.send = func(topic string, payload []byte, t time.Time) error { count++; return nil }
+	if n, _ := again.step(start.Add(24 * time.Hour)); n != len(sent) {
+		t.Errorf("Expected the same %d messages from the same seed, got %d", len(sent), n)
+	}
+}
+
+func TestHeadlessStatus(t *testing.T) {
+	var buf strings.Builder
+	defer func(saved *slog.Logger) { statusLog = saved }(statusLog)
+	statusLog = slog.New(slog.NewJSONHandler(&buf, nil))
+	SetStatus("Subscribed to topic Home/rtl_433")
+	DisplayData("station: Home, temp: 70.0") // Debug, below the default level
+	var rec map[string]interface{}
+	if err := json.Unmarshal([]byte(buf.String()), &rec); err != nil {
+		t.Fatalf("Expected one JSON log record, got %q", buf.String())
+	}
+	if rec["msg"] != "Subscribed to topic Home/rtl_433" || rec["level"] != "INFO" || status != rec["msg"] {
+		t.Errorf("Expected the status logged at info level, got %v", rec)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	good := `{"Brokers":{"1":{"Path":"pi","Port":1883}},
+		"Subscriptions":{"2":{"Topic":"Home/rtl_433/+/events","Station":"Home"}},
+		"ActiveSensors":{"Home:Acurite-Tower:1001:A":{"Key":"Home:Acurite-Tower:1001:A","Station":"Home","Model":"Acurite-Tower","Id":1001,"Channel":"A"}},
+		"Settings":{"LogFormat":"csv","HistoryRetention":{"raw":30,"1h":0}}}`
+	if problems, err := validateConfig([]byte(good)); err != nil || len(problems) != 0 {
+		t.Errorf("Expected a valid configuration, got %v %v", problems, err)
+	}
+	bad := `{"Brokers":{"1":{"Path":"pi","Port":0}},
+		"Subscriptions":{"2":{"Topic":"Barn/rtl_433/events","Station":"Home"}},
+		"ActiveSensors":{"Home:Acurite-Tower:1001:A":{"Key":"Home:Acurite-Tower:1001:A","Station":"Home","Model":"Acurite-Tower","Id":1002,"Channel":"A",
+			"Calibration":{"battery_ok":{"Offset":1}}}},
+		"Stations":{"Home":{"Timezone":"Mars/Olympus"}},
+		"Settings":{"LogSplit":"hour","HistoryRetention":{"2h":10},"AlertSinks":["pager"]}}`
+	problems, err := validateConfig([]byte(bad))
+	if err != nil || len(problems) != 8 {
+		t.Errorf("Expected 8 problems, got %d %v", len(problems), err)
+		for _, p := range problems {
+			t.Log(p)
+		}
+	}
+	if _, err := validateConfig([]byte("{")); err == nil {
+		t.Errorf("Expected an error for a file that isn't JSON")
+	}
+	if s, err := sensorFromKey("Home:LaCrosse-TX141THBv2:2002:"); err != nil || s.Id != 2002 || s.Channel != "" || s.Station != "Home" {
+		t.Errorf("Expected a sensor from its key, got %+v %v", s, err)
+	}
+	if _, err := sensorFromKey("Home:LaCrosse"); err == nil {
+		t.Errorf("Expected an error for a short key")
+	}
+}
+
+func TestAPI(t *testing.T) {
+	st, err := newTSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *tsStore) { historyStore = saved }(historyStore)
+	historyStore = st
+	key := "Home:Acurite-Tower:3003:B"
+	availableSensorsMutex.Lock()
+	availableSensors[key] = &Sensor{Key: key, Model: "Acurite-Tower", Id: 3003, Channel: "B", Station: "Home"}
+	availableSensorsMutex.Unlock()
+	defer func() {
+		availableSensorsMutex.Lock()
+		delete(availableSensors, key)
+		availableSensorsMutex.Unlock()
+		activeSensorsMutex.Lock()
+		delete(activeSensors, key)
+		activeSensorsMutex.Unlock()
+	}()
+	srv := httptest.NewServer(newAPIHandler())
+	defer srv.Close()
+	call := func(method string, path string, body string, want int) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s %s: expected %d, got %d", method, path, want, resp.StatusCode)
+		}
+		return resp
+	}
+
+	call("GET", "/api/sensors/"+key, "", http.StatusNotFound).Body.Close()
+	call("POST", "/api/sensors/"+key+"/activate", "", http.StatusOK).Body.Close()
+	call("POST", "/api/sensors/"+key+"/activate", "", http.StatusConflict).Body.Close()
+	resp := call("PATCH", "/api/sensors/"+key, `{"Name":"Garden"}`, http.StatusOK)
+	var s apiSensor
+	json.NewDecoder(resp.Body).Decode(&s)
+	resp.Body.Close()
+	if s.Name != "Garden" || s.Key != key {
+		t.Errorf("Expected the renamed sensor, got %+v", s.Sensor)
+	}
+	resp = call("GET", "/api/sensors", "", http.StatusOK)
+	var list []apiSensor
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	found := false
+	for _, as := range list {
+		found = found || as.Key == key
+	}
+	if !found {
+		t.Errorf("Expected %s in the active sensors", key)
+	}
+	call("POST", "/api/sensors/"+key+"/deactivate", "", http.StatusNoContent).Body.Close()
+	call("POST", "/api/sensors/"+key+"/deactivate", "", http.StatusNotFound).Body.Close()
+
+	resp = call("POST", "/api/subscriptions", `{"Topic":"Barn/rtl_433/+/events"}`, http.StatusCreated)
+	var m Subscription
+	json.NewDecoder(resp.Body).Decode(&m)
+	resp.Body.Close()
+	if m.Station != "Barn" || !checkMessage(m.Key, subscriptions) {
+		t.Errorf("Expected a subscription for station Barn, got %+v", m)
+	}
+	call("DELETE", fmt.Sprintf("/api/subscriptions/%d", m.Key), "", http.StatusNoContent).Body.Close()
+	call("DELETE", fmt.Sprintf("/api/subscriptions/%d", m.Key), "", http.StatusNotFound).Body.Close()
+	call("POST", "/api/subscriptions", `{"Topic":" "}`, http.StatusBadRequest).Body.Close()
+
+	resp = call("GET", "/api/broker", "", http.StatusOK)
+	var b apiBrokerStatus
+	json.NewDecoder(resp.Body).Decode(&b)
+	resp.Body.Close()
+	if b.Connected {
+		t.Errorf("Expected no broker connection")
+	}
+}
+
+func TestEmailSubject(t *testing.T) {
+	subject := emailSubject(Alert{Message: "Sensor offline: Porch\r\nBcc: someone@example.com"})
+	if strings.ContainsAny(subject, "\r\n") {
+		t.Errorf("Expected no line breaks in the subject, got %q", subject)
+	}
+	if subject := emailSubject(Alert{Message: "Température 90"}); !strings.HasPrefix(subject, "=?utf-8?q?") {
+		t.Errorf("Expected an encoded subject, got %q", subject)
+	}
+}
@@ -0,0 +1,162 @@
+/******************************************************************
+ *
+ * Measurements - Named values carried by a reading, using the
+ *      rtl_433 field names, so that rules and displays can treat
+ *      every measurement the same way
+ *
+ ******************************************************************/
+
+package main
+
+import "time"
+
+// Measurement names, in display order
+var measurementNames = []string{
+	"temperature_F",
+	"humidity",
+	"wind_avg_mi_h",
+	"wind_max_mi_h",
+	"wind_avg_10m_mi_h",
+	"rssi",
+	"snr",
+	"noise",
+	"dew_point_F",
+	"heat_index_F",
+	"wind_chill_F",
+	"feels_like_F",
+	"abs_humidity_g_m3",
+	"rain_rate_in_h",
+	"rain_day_in",
+	"rain_24h_in",
+	"pressure_hPa",
+	"sea_level_hPa",
+}
+
+// A measurement value at a point in time
+type sample struct {
+	t time.Time
+	v float64
+}
+
+// Measurement - Value of a named measurement and whether the reading carried it
+func (wd *WeatherData) Measurement(name string) (float64, bool) {
+	p := wd.measurementField(name)
+	if p == nil {
+		return 0, false
+	}
+	if wd.Fields != nil {
+		return *p, wd.Fields[name]
+	}
+	// Without the list of fields received, treat zero as not reported
+	return *p, *p != 0
+}
+
+// SetMeasurement - Set a measurement by name and mark it received, false if the name is unknown
+func (wd *WeatherData) SetMeasurement(name string, v float64) bool {
+	p := wd.measurementField(name)
+	if p == nil {
+		return false
+	}
+	if wd.Fields == nil {
+		wd.Fields = make(map[string]bool)
+	}
+	*p = v
+	wd.Fields[name] = true
+	return true
+}
+
+// measurementField - The field holding a measurement, nil if the name is unknown
+func (wd *WeatherData) measurementField(name string) *float64 {
+	switch name {
+	case "temperature_F":
+		return &wd.Temperature_F
+	case "humidity":
+		return &wd.Humidity
+	case "wind_avg_mi_h":
+		return &wd.Wind_avg_mi_h
+	case "wind_max_mi_h":
+		return &wd.Wind_max_mi_h
+	case "wind_avg_km_h":
+		return &wd.Wind_avg_km_h
+	case "wind_max_km_h":
+		return &wd.Wind_max_km_h
+	case "wind_avg_m_s":
+		return &wd.Wind_avg_m_s
+	case "wind_max_m_s":
+		return &wd.Wind_max_m_s
+	case "wind_avg_10m_mi_h":
+		return &wd.WindAvg10m_mi_h
+	case "wind_dir_10m_deg":
+		return &wd.WindDir10m_deg
+	case "rssi":
+		return &wd.Rssi
+	case "snr":
+		return &wd.Snr
+	case "noise":
+		return &wd.Noise
+	case "dew_point_F":
+		return &wd.DewPoint_F
+	case "heat_index_F":
+		return &wd.HeatIndex_F
+	case "wind_chill_F":
+		return &wd.WindChill_F
+	case "feels_like_F":
+		return &wd.FeelsLike_F
+	case "abs_humidity_g_m3":
+		return &wd.AbsHumidity_g_m3
+	case "rain_in":
+		return &wd.Rain_in
+	case "rain_mm":
+		return &wd.Rain_mm
+	case "rain_rate_in_h":
+		return &wd.RainRate_in_h
+	case "rain_day_in":
+		return &wd.RainDay_in
+	case "rain_24h_in":
+		return &wd.Rain24h_in
+	case "pressure_hPa":
+		return &wd.Pressure_hPa
+	case "pressure_inHg":
+		return &wd.Pressure_inHg
+	case "pressure_kPa":
+		return &wd.Pressure_kPa
+	case "sea_level_hPa":
+		return &wd.SeaLevel_hPa
+	case "wind_dir_deg":
+		return &wd.Wind_dir_deg
+	}
+	return nil
+}
+
+// Measurements - All measurements the reading carried
+func (wd *WeatherData) Measurements() map[string]float64 {
+	m := make(map[string]float64)
+	for _, name := range measurementNames {
+		if v, ok := wd.Measurement(name); ok {
+			m[name] = v
+		}
+	}
+	return m
+}
+
+// ratePerHour - Least squares slope of the samples in units per hour, 0 if it can't be computed
+func ratePerHour(samples []sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	t0 := samples[0].t
+	var n, sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.t.Sub(t0).Hours()
+		n++
+		sx += x
+		sy += s.v
+		sxx += x * x
+		sxy += x * s.v
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
@@ -53,7 +53,55 @@ func editSpecificSensorHandler(key string) {
 	s_Hide_widget.SetChecked(s.Hide)
 	s_HasHumidity_widget := widget.NewCheck("Check if sensor also provides humidity", showHumidityHandler)
 	s_HasHumidity_widget.SetChecked(s.HasHumidity)
-	s_ResetHiLo_widget := widget.NewCheck("Reset Hi/Lo", func(value bool) {
+	s_Outdoor_widget := widget.NewCheck("Check if sensor is outdoors, for degree days", nil)
+	s_Outdoor_widget.SetChecked(s.Outdoor)
+	s_Interval_widget := widget.NewEntry()
+	s_Interval_widget.SetText(strconv.Itoa(s.ExpectedInterval))
+	s_Interval_label := widget.NewLabel(fmt.Sprintf("Expected seconds between readings, 0 = learn (learned %.0f s)", s.LearnedInterval))
+	// Calibration is edited one measurement at a time in a working copy
+	calibration := make(map[string]Calibration)
+	for name, c := range s.Calibration {
+		calibration[name] = c
+	}
+	calName := ""
+	s_Calibration_label := widget.NewLabel(formatCalibration(calibration))
+	s_CalOffset_widget := widget.NewEntry()
+	s_CalOffset_widget.SetPlaceHolder("Offset, e.g. -1.5")
+	s_CalScale_widget := widget.NewEntry()
+	s_CalScale_widget.SetPlaceHolder("Scale, e.g. 1.05, empty for 1")
+	// Keep the offset and scale typed for the selected measurement
+	storeCalibration := func() bool {
+		if calName == "" {
+			return true
+		}
+		c, err := parseCalibration(s_CalOffset_widget.Text, s_CalScale_widget.Text)
+		if err != nil {
+			SetStatus(fmt.Sprintf("Calibration of %s not changed, %s", calName, err))
+			return false
+		}
+		if c.identity() {
+			delete(calibration, calName)
+		} else {
+			calibration[calName] = c
+		}
+		s_Calibration_label.SetText(formatCalibration(calibration))
+		return true
+	}
+	s_CalName_widget := widget.NewSelect(calibrationNames, func(name string) {
+		storeCalibration()
+		calName = name
+		c := calibration[name]
+		s_CalOffset_widget.SetText("")
+		s_CalScale_widget.SetText("")
+		if c.Offset != 0 {
+			s_CalOffset_widget.SetText(strconv.FormatFloat(c.Offset, 'f', -1, 64))
+		}
+		if c.Scale != 0 {
+			s_CalScale_widget.SetText(strconv.FormatFloat(c.Scale, 'f', -1, 64))
+		}
+	})
+	s_CalName_widget.PlaceHolder = "Measurement to calibrate"
+	s_ResetHiLo_widget := widget.NewCheck("Reset today's Hi/Lo", func(value bool) {
 		if value {
 			resetHiLoFlag = true
 		}
@@ -85,6 +133,11 @@ func editSpecificSensorHandler(key string) {
 		s_Location_widget,
 		s_Hide_widget,
 		s_HasHumidity_widget,
+		s_Outdoor_widget,
+		s_Interval_label,
+		s_Interval_widget,
+		s_Calibration_label,
+		container.NewGridWithColumns(3, s_CalName_widget, s_CalOffset_widget, s_CalScale_widget),
 		s_ResetHiLo_widget,
 		s_Model_widget,
 		s_Id_widget,
@@ -99,12 +152,18 @@ func editSpecificSensorHandler(key string) {
 			s.Location = s_Location_widget.Text
 			s.Hide = s_Hide_widget.Checked
 			s.HasHumidity = s_HasHumidity_widget.Checked
+			s.Outdoor = s_Outdoor_widget.Checked
+			if interval, err := strconv.Atoi(s_Interval_widget.Text); err == nil && interval >= 0 {
+				s.ExpectedInterval = interval
+			} else {
+				SetStatus(fmt.Sprintf("Ignoring expected interval %q, not a whole number of seconds", s_Interval_widget.Text))
+			}
+			if storeCalibration() {
+				s.Calibration = calibration
+			}
 			s.LastEdit = st
 			if resetHiLoFlag {
-				s.HighTemp = s.Temp
-				s.LowTemp = s.Temp
-				s.HighHumidity = s.Humidity
-				s.LowHumidity = s.Humidity
+				s.resetTodayStats()
 			}
 			resetHiLoFlag = false
 			activeSensors[key] = s
@@ -132,17 +191,23 @@ func editSpecificSensorHandler(key string) {
  *
  ******************************************************************/
 var exitHandler = func() {
+	saveAndClose()
+
+	// Now, exit program
+	os.Exit(0)
+}
+
+// saveAndClose - Flush and close the data files and save the configuration, before exiting
+func saveAndClose() {
+	stopAPI()
 	// Close data files
-	for _, d := range dataFiles {
-		d.file.Sync()
-		d.file.Close()
-	}
+	stopSimulator()
+	closeDataFiles()
+	closeHistory()
+	capture.stop()
 
 	// Output current configuration for later reload
 	writeConfig()
-
-	// Now, exit program
-	os.Exit(0)
 }
 
 var scrollDataHandler = func() {
@@ -176,6 +241,26 @@ var dataLoggingOffHandler = func() {
 	SetStatus("Data logging turned off")
 }
 
+var mergeReceiversOnHandler = func() {
+	settings.MergeReceivers = true
+	SetStatus(fmt.Sprintf("Merging receivers, keeping %s copy", settings.MergeStrategy))
+}
+
+var mergeReceiversOffHandler = func() {
+	settings.MergeReceivers = false
+	SetStatus("Merging receivers turned off")
+}
+
+var mergeBySignalHandler = func() {
+	settings.MergeStrategy = "signal"
+	SetStatus("Merged readings keep the copy with the best signal")
+}
+
+var mergeByArrivalHandler = func() {
+	settings.MergeStrategy = "arrival"
+	SetStatus("Merged readings keep the first copy to arrive")
+}
+
 // View handlers
 var zoomPlusHandler = func() {
 	windowScale = windowScale * 1.1
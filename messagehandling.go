@@ -21,16 +21,50 @@ import (
  **********************************************************************************/
 
 var messageHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
+	// A replayed message keeps the time it was captured with
+	if m, ok := msg.(*replayMessage); ok {
+		processMessage(m.topic, m.payload, m.received)
+		return
+	}
+	received := time.Now()
+	if err := capture.record(captureBroker(client), msg.Topic(), msg.Payload(), received); err != nil {
+		SetStatus(fmt.Sprintf("Unable to capture message: %s", err))
+	}
+	processMessage(msg.Topic(), msg.Payload(), received)
+}
+
+// processMessage - Decode one rtl_433 JSON message and pass the reading on for processing.
+// When receivers are merged, copies heard by several stations are combined first.
+func processMessage(topic string, payload []byte, received time.Time) {
 	var incoming WeatherDataRaw
 	var outgoing WeatherData
 
-	err := json.Unmarshal(msg.Payload(), &incoming)
+	err := json.Unmarshal(payload, &incoming)
 	if err != nil {
 		fmt.Println("messageHandler: Unable to unmarshal JSON due to ", err)
 		SetStatus(fmt.Sprintf("messageHandler: Unable to unmarshal JSON due to %s", err))
+		return
 	}
 	outgoing.CopyWDRtoWD(incoming)
-	outgoing.Station = strings.Split(msg.Topic(), "/")[0] // station, or home, is the first segment of the msg.Topic
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(payload, &fields) == nil {
+		outgoing.Fields = make(map[string]bool, len(fields))
+		for name := range fields {
+			outgoing.Fields[name] = true
+		}
+	}
+	outgoing.Station = strings.Split(topic, "/")[0] // station, or home, is the first segment of the msg.Topic
+	outgoing.Received = received
+	if settings.MergeReceivers {
+		mergeReading(outgoing, received, handleReading)
+		return
+	}
+	handleReading(outgoing)
+}
+
+// handleReading - Route a reading to the available sensors table, or to the widgets,
+// data log and live feed if its sensor is active
+func handleReading(outgoing WeatherData) {
 	skey := outgoing.BuildSensorKey()
 	// Add sensor to availableSensors table(map) if not already there AND if not already in activeSensors
 	if !checkSensor(skey, activeSensors) {
@@ -46,15 +80,62 @@ var messageHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Messa
 			availableSensorsMutex.Unlock()
 			SetStatus(fmt.Sprintf("Added sensor to visible sensors: %s, model: %s, station: %s", skey, sens.Model, sens.Station))
 		}
+		availableSensorsMutex.Lock()
+		availableSensors[skey].Signal.AddSignal(outgoing)
+		availableSensors[skey].heard(outgoing.Received)
+		availableSensorsMutex.Unlock()
 	} else {
+		activeSensorsMutex.Lock()
+		activeSensors[skey].Signal.AddSignal(outgoing)
+		wasOffline := activeSensors[skey].heard(outgoing.Received)
+		activeSensorsMutex.Unlock()
+		if wasOffline {
+			clearAlert("offline:"+skey, fmt.Sprintf("sensor back online: %s", skey))
+		}
+		checkBattery(skey, outgoing)
 		// Sensor is active, write record to output file
 		s := *activeSensors[skey]
 		outgoing.Station = s.Station
 		outgoing.SensorName = s.Name
 		outgoing.SensorLocation = s.Location
+		outgoing.addWind()
+		s.calibrate(&outgoing)
+		outgoing.addDerived()
+		outgoing.addPressure(stationSettings(s.Station).Elevation)
+		outgoing.addWindAverages(skey, outgoing.Received)
+		activeSensorsMutex.Lock()
+		activeSensors[skey].updateRain(&outgoing, outgoing.Received)
+		activeSensors[skey].updateStats(outgoing, outgoing.Received)
+		gust := windGustToday(activeSensors[skey])
+		activeSensorsMutex.Unlock()
+		recordHistory(skey, outgoing, outgoing.Received)
+		addTrendSamples(skey, outgoing, outgoing.Received)
+		if updateForecast(s.Station, outgoing, outgoing.Received) {
+			refreshForecastTile(s.Station)
+		}
+		evaluateRules(skey, outgoing)
 		// Update Sensor's WeatherWidget if not hidden and widget exists
 		if checkWeatherWidget(skey) && !s.Hide {
-			nd := newData{skey, outgoing.Temperature_F, outgoing.Humidity, outgoing.Time}
+			nd := newData{
+				key:        skey,
+				temp:       outgoing.Temperature_F,
+				humidity:   outgoing.Humidity,
+				date:       outgoing.Time,
+				batteryLow: s.BatteryLow(),
+				dewPoint:   outgoing.DewPoint_F,
+				feelsLike:  outgoing.FeelsLike_F,
+				tempTrend:  trendOf(skey, "temperature_F"),
+				humTrend:   trendOf(skey, "humidity"),
+				hasRain:    outgoing.Fields["rain_day_in"],
+				rainDay:    outgoing.RainDay_in,
+				rain24h:    outgoing.Rain24h_in,
+				rainRate:   outgoing.RainRate_in_h,
+				hasWind:    outgoing.Fields["wind_avg_mi_h"],
+				windSpeed:  outgoing.Wind_avg_mi_h,
+				windDir:    outgoing.Wind_dir_deg,
+				hasWindDir: outgoing.Fields["wind_dir_deg"],
+				windGust:   gust,
+			}
 			// Use a go routine to prevent blocking of this event handler
 			// Each incoming data record gets its own goroutine
 			go notifyWidget(nd)
@@ -68,11 +149,17 @@ var messageHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Messa
 			writeWeatherData(outgoing)
 		}
 		// Always write record to the data display scrolling console
-		DisplayData(fmt.Sprintf("station: %s, sensor: %s, location: %s, temp: %.1f, humidity: %.1f, time: %s, model: %s, id: %d, channel: %s",
-			outgoing.Station, outgoing.SensorName, outgoing.SensorLocation, outgoing.Temperature_F, outgoing.Humidity, outgoing.Time, outgoing.Model, outgoing.Id, outgoing.Channel))
+		DisplayData(fmt.Sprintf("station: %s, sensor: %s, location: %s, temp: %.1f, humidity: %.1f, time: %s, model: %s, id: %d, channel: %s%s",
+			outgoing.Station, outgoing.SensorName, outgoing.SensorLocation, outgoing.Temperature_F, outgoing.Humidity, outgoing.Time, outgoing.Model, outgoing.Id, outgoing.Channel, formatDerived(outgoing)+formatRaw(outgoing)+formatReceivers(outgoing)+formatSignal(outgoing)))
+		// Recompute the virtual sensors that use this one
+		if !s.Virtual {
+			updateVirtualSensors(skey, outgoing.Time, outgoing.Received)
+		}
 	}
 }
 
+const brokerRetryInterval = 30 * time.Second // Between tries of a broker that can't be reached, headless
+
 var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 	go sub(client)
 }
@@ -102,28 +189,35 @@ func notifyWidget(nd newData) {
 		activeSensors[key].Humidity = humidity
 	}
 	weatherWidgets[key].latestUpdate = date
+	weatherWidgets[key].batteryLow = nd.batteryLow
+	weatherWidgets[key].dewPoint = nd.dewPoint
+	weatherWidgets[key].feelsLike = nd.feelsLike
+	weatherWidgets[key].tempTrend = nd.tempTrend
+	weatherWidgets[key].humidityTrend = nd.humTrend
+	if nd.hasWind {
+		weatherWidgets[key].hasWind = true
+		weatherWidgets[key].windSpeed = nd.windSpeed
+		weatherWidgets[key].windDir = nd.windDir
+		weatherWidgets[key].hasWindDir = nd.hasWindDir
+		weatherWidgets[key].windGust = nd.windGust
+	}
+	if nd.hasRain {
+		weatherWidgets[key].hasRain = true
+		weatherWidgets[key].rainDay = nd.rainDay
+		weatherWidgets[key].rain24h = nd.rain24h
+		weatherWidgets[key].rainRate = nd.rainRate
+	}
+	weatherWidgets[key].stale = false
 
 	activeSensorsMutex.Lock()
 
 	activeSensors[key].DataDate = date
 
-	//Calculate & update highs and lows
-	if (temp != 0) && (temp > weatherWidgets[key].highTemp) {
-		weatherWidgets[key].highTemp = temp
-		activeSensors[key].HighTemp = temp
-	}
-	if (temp != 0) && (temp < weatherWidgets[key].lowTemp) {
-		weatherWidgets[key].lowTemp = temp
-		activeSensors[key].LowTemp = temp
-	}
-	if (humidity != 0) && (humidity > (weatherWidgets[key].highHumidity)) {
-		weatherWidgets[key].highHumidity = humidity
-		activeSensors[key].HighHumidity = humidity
-	}
-	if (humidity != 0) && (humidity < weatherWidgets[key].lowHumidity) {
-		weatherWidgets[key].lowHumidity = humidity
-		activeSensors[key].LowHumidity = humidity
-	}
+	// Highs and lows come from today's statistics
+	weatherWidgets[key].highTemp = activeSensors[key].HighTemp
+	weatherWidgets[key].lowTemp = activeSensors[key].LowTemp
+	weatherWidgets[key].highHumidity = activeSensors[key].HighHumidity
+	weatherWidgets[key].lowHumidity = activeSensors[key].LowHumidity
 
 	activeSensorsMutex.Unlock()
 
@@ -132,7 +226,7 @@ func notifyWidget(nd newData) {
 }
 
 func sub(client mqtt.Client) {
-	for _, m := range subscriptions {
+	for _, m := range copySubscriptions() {
 		client.Subscribe(m.Topic, 0, messageHandler)
 		SetStatus(fmt.Sprintf("Subscribed to topic %s", m.Topic))
 	}
@@ -196,8 +290,38 @@ func checkMessage(key int, m map[int]*Subscription) bool {
 
 // writeWeatherData - Output weather record to appropriate file based on the station (home)
 func writeWeatherData(wd WeatherData) {
-	datafile := dataFiles[wd.Station].file
-	_, err := datafile.WriteString(fmt.Sprintf("station: %s, sensor: %s, location: %s, temp: %.1f, humidity: %.1f, time: %s, model: %s, id: %d, channel: %s\n",
-		wd.Station, wd.SensorName, wd.SensorLocation, wd.Temperature_F, wd.Humidity, wd.Time, wd.Model, wd.Id, wd.Channel))
-	check(err)
+	if err := logReading(wd); err != nil {
+		SetStatus(fmt.Sprintf("Unable to write data log: %s", err))
+	}
+}
+
+// formatDerived - Derived measurements of a reading, empty if there are none
+func formatDerived(wd WeatherData) string {
+	str := ""
+	for _, name := range []string{"dew_point_F", "heat_index_F", "wind_chill_F", "feels_like_F", "abs_humidity_g_m3", "rain_rate_in_h", "rain_day_in", "rain_24h_in", "sea_level_hPa", "wind_avg_10m_mi_h", "wind_dir_10m_deg"} {
+		if v, ok := wd.Measurement(name); ok {
+			format := ", %s: %.1f"
+			if strings.HasPrefix(name, "rain_") { // Gauges count 0.01 in
+				format = ", %s: %.2f"
+			}
+			str = str + fmt.Sprintf(format, name, v)
+		}
+	}
+	return str
+}
+
+// formatSignal - Reception metadata of a reading, empty if rtl_433 metadata is off
+func formatSignal(wd WeatherData) string {
+	if !wd.HasSignal() {
+		return ""
+	}
+	return fmt.Sprintf(", rssi: %.1f, snr: %.1f, noise: %.1f, freq: %.3f, protocol: %d", wd.Rssi, wd.Snr, wd.Noise, wd.Freq, wd.Protocol)
+}
+
+// formatReceivers - List the stations that heard a merged reading, empty if not merged
+func formatReceivers(wd WeatherData) string {
+	if len(wd.Receivers) == 0 {
+		return ""
+	}
+	return ", receivers: " + strings.Join(wd.Receivers, "|")
 }
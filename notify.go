@@ -0,0 +1,336 @@
+/******************************************************************
+ *
+ * Notifiers - Deliver alerts to people who aren't watching the
+ *      dashboard: desktop notifications, an MQTT topic, an HTTP
+ *      webhook, SMTP email or a local command. Each alert rule names
+ *      the notifiers it uses; battery and offline alerts use the
+ *      AlertSinks setting. Failed deliveries are retried and reported
+ *      on the status console.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+const (
+	defaultNotifyRetries    = 3
+	defaultNotifyRetryDelay = 10 // Seconds before the first retry, doubled for each retry after
+	notifyTimeout           = 30 * time.Second
+)
+
+var notifierTypes = []string{"desktop", "mqtt", "webhook", "email", "command"}
+
+type Notifier struct {
+	Name           string   `json:"Name"`           // Used by alert rules to pick notifiers
+	Type           string   `json:"Type"`           // "desktop", "mqtt", "webhook", "email" or "command"
+	Topic          string   `json:"Topic"`          // mqtt: topic the alert is published to
+	URL            string   `json:"URL"`            // webhook: address the alert is POSTed to
+	Template       string   `json:"Template"`       // webhook: JSON body, a Go template over the Alert
+	SMTPHost       string   `json:"SMTPHost"`       // email: mail server
+	SMTPPort       int      `json:"SMTPPort"`       // email: mail server port, default 25
+	Username       string   `json:"Username"`       // email: login, if the server needs one
+	Password       string   `json:"Password"`       // email: password, if the server needs one
+	From           string   `json:"From"`           // email: sender address
+	To             []string `json:"To"`             // email: recipient addresses
+	Command        string   `json:"Command"`        // command: program to run
+	Args           []string `json:"Args"`           // command: arguments
+	Retries        int      `json:"Retries"`        // Attempts after the first, default 3, -1 for none
+	RetryDelaySecs int      `json:"RetryDelaySecs"` // Wait before the first retry, default 10
+}
+
+// alertSink - Something an alert can be delivered to
+type alertSink interface {
+	send(al Alert) error
+}
+
+var (
+	notifiers   []Notifier                // Notifiers from config.json
+	notifyLog   = SetStatus               // Where delivery problems are reported
+	notifySleep = time.Sleep              // Wait between retries
+	mqttPublish = publishToBroker         // How the mqtt notifier publishes
+	desktopSend = sendDesktopNotification // How the desktop notifier shows alerts
+)
+
+// notifyAlert - Deliver an alert to the named notifiers, each in its own goroutine
+func notifyAlert(al Alert, names []string) {
+	for _, name := range names {
+		n, ok := findNotifier(name)
+		if !ok {
+			notifyLog(fmt.Sprintf("Alert not sent, no notifier named %s", name))
+			continue
+		}
+		sink, err := newSink(n)
+		if err != nil {
+			notifyLog(fmt.Sprintf("Alert not sent to %s, %s", n.Name, err))
+			continue
+		}
+		go deliverAlert(n, sink, al)
+	}
+}
+
+// deliverAlert - Send an alert, retrying with a growing delay. Returns the last error.
+func deliverAlert(n Notifier, sink alertSink, al Alert) error {
+	attempts := n.Retries + 1
+	if n.Retries == 0 {
+		attempts = defaultNotifyRetries + 1
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := time.Duration(n.RetryDelaySecs) * time.Second
+	if delay <= 0 {
+		delay = defaultNotifyRetryDelay * time.Second
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			notifySleep(delay)
+			delay *= 2
+		}
+		if err = sink.send(al); err == nil {
+			return nil
+		}
+		notifyLog(fmt.Sprintf("Alert %d to %s failed (attempt %d): %s", al.Id, n.Name, attempt+1, err))
+	}
+	notifyLog(fmt.Sprintf("Giving up on alert %d to %s", al.Id, n.Name))
+	return err
+}
+
+func findNotifier(name string) (Notifier, bool) {
+	for _, n := range notifiers {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return Notifier{}, false
+}
+
+// newSink - Build the sink for a notifier
+func newSink(n Notifier) (alertSink, error) {
+	if err := n.validate(); err != nil {
+		return nil, err
+	}
+	switch n.Type {
+	case "desktop":
+		return desktopSink{}, nil
+	case "mqtt":
+		return mqttSink{topic: n.Topic}, nil
+	case "webhook":
+		body := n.Template
+		if body == "" {
+			body = "{{json .}}"
+		}
+		tmpl, err := template.New(n.Name).Funcs(template.FuncMap{"json": jsonValue}).Parse(body)
+		if err != nil {
+			return nil, err
+		}
+		return webhookSink{url: n.URL, body: tmpl, client: &http.Client{Timeout: notifyTimeout}}, nil
+	case "email":
+		port := n.SMTPPort
+		if port == 0 {
+			port = 25
+		}
+		return emailSink{addr: n.SMTPHost + ":" + strconv.Itoa(port), n: n}, nil
+	case "command":
+		return commandSink{command: n.Command, args: n.Args}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", n.Type)
+}
+
+// validate - Check a notifier has what its type needs
+func (n *Notifier) validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return errors.New("notifier has no name")
+	}
+	switch n.Type {
+	case "desktop":
+	case "mqtt":
+		if n.Topic == "" {
+			return fmt.Errorf("notifier %s: mqtt needs a Topic", n.Name)
+		}
+	case "webhook":
+		if !strings.HasPrefix(n.URL, "http://") && !strings.HasPrefix(n.URL, "https://") {
+			return fmt.Errorf("notifier %s: webhook needs an http(s) URL", n.Name)
+		}
+	case "email":
+		if n.SMTPHost == "" || n.From == "" || len(n.To) == 0 {
+			return fmt.Errorf("notifier %s: email needs SMTPHost, From and To", n.Name)
+		}
+	case "command":
+		if n.Command == "" {
+			return fmt.Errorf("notifier %s: command needs a Command", n.Name)
+		}
+	default:
+		return fmt.Errorf("notifier %s: unknown type %q, use one of %s", n.Name, n.Type, strings.Join(notifierTypes, ", "))
+	}
+	return nil
+}
+
+// jsonValue - Template function quoting a value as JSON
+func jsonValue(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+/******************************************
+ * Sinks
+ ******************************************/
+
+type desktopSink struct{}
+
+func (desktopSink) send(al Alert) error {
+	return desktopSend(al)
+}
+
+func sendDesktopNotification(al Alert) error {
+	if a == nil {
+		return errors.New("no desktop to notify")
+	}
+	a.SendNotification(fyne.NewNotification("Weather Dashboard alert", al.Message))
+	return nil
+}
+
+type mqttSink struct {
+	topic string
+}
+
+func (m mqttSink) send(al Alert) error {
+	payload, err := json.Marshal(al)
+	if err != nil {
+		return err
+	}
+	return mqttPublish(m.topic, payload)
+}
+
+// publishToBroker - Publish on the connected broker and wait for it to accept the message
+func publishToBroker(topic string, payload []byte) error {
+	if Client == nil || !Client.IsConnected() {
+		return errors.New("not connected to a broker")
+	}
+	token := Client.Publish(topic, 1, false, payload)
+	if !token.WaitTimeout(notifyTimeout) {
+		return errors.New("timed out publishing to broker")
+	}
+	return token.Error()
+}
+
+type webhookSink struct {
+	url    string
+	body   *template.Template
+	client *http.Client
+}
+
+func (wh webhookSink) send(al Alert) error {
+	var body bytes.Buffer
+	if err := wh.body.Execute(&body, al); err != nil {
+		return err
+	}
+	resp, err := wh.client.Post(wh.url, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+type emailSink struct {
+	addr string
+	n    Notifier
+}
+
+func (e emailSink) send(al Alert) error {
+	var auth smtp.Auth
+	if e.n.Username != "" {
+		auth = smtp.PlainAuth("", e.n.Username, e.n.Password, e.n.SMTPHost)
+	}
+	msg := "From: " + e.n.From + "\r\n" +
+		"To: " + strings.Join(e.n.To, ", ") + "\r\n" +
+		"Subject: " + emailSubject(al) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"\r\n" +
+		formatAlert(al) + "\r\n"
+	return smtp.SendMail(e.addr, auth, e.n.From, e.n.To, []byte(msg))
+}
+
+// emailSubject - Subject header of an alert. The message comes from sensor names and keys, which
+// arrive in MQTT payloads, so line breaks are removed to keep it from adding headers.
+func emailSubject(al Alert) string {
+	subject := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, "Weather alert: "+al.Message)
+	return mime.QEncoding.Encode("utf-8", subject)
+}
+
+type commandSink struct {
+	command string
+	args    []string
+}
+
+// send - Run the command with the alert in ALERT_* environment variables and as JSON on stdin
+func (c commandSink) send(al Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	payload, err := json.Marshal(al)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, c.command, c.args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"ALERT_ID="+strconv.Itoa(al.Id),
+		"ALERT_TIME="+al.Time,
+		"ALERT_KIND="+al.Kind,
+		"ALERT_RULE="+al.Rule,
+		"ALERT_SENSOR="+al.SensorKey,
+		"ALERT_MESSAGE="+al.Message,
+		"ALERT_VALUE="+strconv.FormatFloat(al.Value, 'f', -1, 64),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// testNotifiersHandler - Send a test alert to every notifier
+var testNotifiersHandler = func() {
+	al := Alert{
+		Time:    time.Now().Local().Format(YYYYMMDD + " " + HHMMSS24h),
+		Kind:    "test",
+		Message: "Test alert from the weather dashboard",
+	}
+	var names []string
+	for _, n := range notifiers {
+		names = append(names, n.Name)
+	}
+	if len(names) == 0 {
+		SetStatus("No notifiers configured")
+		return
+	}
+	SetStatus(fmt.Sprintf("Sending test alert to %s", strings.Join(names, ", ")))
+	notifyAlert(al, names)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var t_alert = Alert{
+	Id:        7,
+	Time:      "2024-06-17 19:16:31",
+	SensorKey: "barn:Acurite-606TX:237:A",
+	Kind:      "rule",
+	Rule:      "Barn freeze",
+	Message:   "Barn freeze: barn Outdoor temperature_F below 34.0 (now 33.2)",
+	Value:     33.2,
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	got := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- string(body)
+	}))
+	defer server.Close()
+
+	sink, err := newSink(Notifier{Name: "hook", Type: "webhook", URL: server.URL, Template: `{"text": {{json .Message}}, "value": {{.Value}}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.send(t_alert); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"text": "Barn freeze: barn Outdoor temperature_F below 34.0 (now 33.2)", "value": 33.2}`
+	if body := <-got; body != expected {
+		t.Errorf("Expected body (%s) is not same as actual body (%s)", expected, body)
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 1)
+	go fakeSMTPServer(ln, got)
+
+	addr := ln.Addr().(*net.TCPAddr)
+	sink, err := newSink(Notifier{Name: "mail", Type: "email", SMTPHost: "127.0.0.1", SMTPPort: addr.Port, From: "dash@example.com", To: []string{"farm@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.send(t_alert); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-got; !strings.Contains(msg, "Subject: Weather alert: Barn freeze") {
+		t.Errorf("Unexpected message sent: %s", msg)
+	}
+}
+
+// fakeSMTPServer - Accept one message, just enough SMTP for net/smtp
+func fakeSMTPServer(ln net.Listener, got chan string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+	reply("220 localhost ESMTP")
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case inData && line == ".\r\n":
+			inData = false
+			got <- data.String()
+			reply("250 OK")
+		case inData:
+			data.WriteString(line)
+		case strings.HasPrefix(line, "DATA"):
+			inData = true
+			reply("354 Go ahead")
+		case strings.HasPrefix(line, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "alert.txt")
+	sink, err := newSink(Notifier{Name: "script", Type: "command", Command: "sh", Args: []string{"-c", `echo "$ALERT_RULE" > ` + out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.send(t_alert); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); strings.TrimSpace(string(data)) != "Barn freeze" {
+		t.Errorf("Expected command to see rule (Barn freeze), got (%s)", data)
+	}
+}
+
+func TestMQTTNotifierRetries(t *testing.T) {
+	defer func(p func(string, []byte) error, s func(time.Duration), l func(string)) {
+		mqttPublish, notifySleep, notifyLog = p, s, l
+	}(mqttPublish, notifySleep, notifyLog)
+	var logged []string
+	notifyLog = func(s string) { logged = append(logged, s) }
+	notifySleep = func(time.Duration) {}
+	tries := 0
+	mqttPublish = func(topic string, payload []byte) error {
+		tries++
+		if tries < 3 {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}
+
+	n := Notifier{Name: "broker", Type: "mqtt", Topic: "alerts/weather", Retries: 2}
+	sink, err := newSink(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverAlert(n, sink, t_alert); err != nil || tries != 3 {
+		t.Errorf("Expected delivery on third try, got %d tries, error %v", tries, err)
+	}
+	if len(logged) != 2 {
+		t.Errorf("Expected two failed attempts to be logged, got %d", len(logged))
+	}
+}
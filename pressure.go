@@ -0,0 +1,338 @@
+/******************************************************************
+ *
+ * Pressure and forecast - Sea level pressure from the station
+ *      pressure reported by a sensor and the station's elevation,
+ *      and a short-term local forecast by the Zambretti algorithm
+ *      from the sea level pressure, its change over three hours and
+ *      the wind direction. Each station with a pressure sensor gets
+ *      a forecast tile on the dashboard.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+)
+
+const (
+	hPaPerInHg          = 33.8639
+	forecastWindow      = 3 * time.Hour // Pressure change is measured over this time
+	forecastMinSpan     = time.Hour     // Readings must span this long before there is a forecast
+	zambrettiSteadyHPa  = 1.6           // Change over three hours smaller than this is steady
+	standardTemperature = 15.0          // °C, used when the reading has no temperature
+)
+
+// Zambretti forecasts, A to Z
+var zambrettiText = []string{
+	"Settled fine", "Fine weather", "Becoming fine", "Fine, becoming less settled",
+	"Fine, possible showers", "Fairly fine, improving", "Fairly fine, possible showers early",
+	"Fairly fine, showery later", "Showery early, improving", "Changeable, mending",
+	"Fairly fine, showers likely", "Rather unsettled, clearing later", "Unsettled, probably improving",
+	"Showery, bright intervals", "Showery, becoming less settled", "Changeable, some rain",
+	"Unsettled, short fine intervals", "Unsettled, rain later", "Unsettled, some rain",
+	"Mostly very unsettled", "Occasional rain, worsening", "Rain at times, very unsettled",
+	"Rain at frequent intervals", "Rain, very unsettled", "Stormy, may improve", "Stormy, much rain",
+}
+
+// Forecast letters for the Zambretti numbers of falling, steady and rising pressure
+var (
+	zambrettiFalling = "ABDHORUXZ"
+	zambrettiSteady  = "ABEKNPSWXZ"
+	zambrettiRising  = "ABCFGIJLMQTYZ"
+)
+
+// Pressure adjustment in hPa for wind from each of the 16 compass points, N first,
+// in the northern hemisphere
+var zambrettiWind = []float64{6, 5, 5, 2, -0.5, -2, -5, -8.5, -12, -10, -6, -4.5, -3, -0.5, 1.5, 3}
+
+// Pressure trend and latest wind of a station
+type stationForecast struct {
+	samples  []sample  // Sea level pressure in hPa, oldest first
+	windDir  float64   // Degrees the wind blows from
+	windTime time.Time // Zero if the station has not reported wind direction
+}
+
+// A forecast and what it was made from
+type forecast struct {
+	Pressure float64 // Sea level pressure, hPa
+	Change   float64 // hPa over three hours
+	Trend    int     // trendFalling, trendSteady or trendRising
+	Letter   byte    // Zambretti letter, 'A' to 'Z'
+	Text     string
+	Ok       bool // False until there is enough pressure history
+}
+
+var (
+	forecasts          = make(map[string]*stationForecast) // Station : pressure history
+	forecastMutex      sync.Mutex
+	forecastTiles      = make(map[string]*forecastTile) // Station : dashboard tile, nil while a reload adds it
+	forecastTilesMutex sync.Mutex
+)
+
+// addPressure - Add station and sea level pressure in hPa to a reading that carries pressure
+// in any unit, for a station at elevation metres
+func (wd *WeatherData) addPressure(elevation float64) {
+	p, ok := wd.Measurement("pressure_hPa")
+	if !ok {
+		if v, inHg := wd.Measurement("pressure_inHg"); inHg {
+			p, ok = v*hPaPerInHg, true
+		} else if v, kPa := wd.Measurement("pressure_kPa"); kPa {
+			p, ok = v*10, true
+		}
+	}
+	if !ok || p <= 0 {
+		return
+	}
+	tc := standardTemperature
+	if t, hasTemp := wd.Measurement("temperature_F"); hasTemp {
+		tc = fToC(t)
+	}
+	if wd.Fields == nil {
+		wd.Fields = make(map[string]bool)
+	}
+	wd.Pressure_hPa = p
+	wd.SeaLevel_hPa = seaLevelPressure(p, elevation, tc)
+	wd.Fields["pressure_hPa"] = true
+	wd.Fields["sea_level_hPa"] = true
+}
+
+// seaLevelPressure - Reduce station pressure to sea level by the hypsometric formula
+func seaLevelPressure(p float64, elevation float64, tc float64) float64 {
+	return p * math.Pow(1-0.0065*elevation/(tc+0.0065*elevation+273.15), -5.257)
+}
+
+// updateForecast - Add a reading that arrived at time t to its station's forecast.
+// Returns true if the reading had pressure or wind direction.
+func updateForecast(station string, wd WeatherData, t time.Time) bool {
+	p, hasPressure := wd.Measurement("sea_level_hPa")
+	dir, hasWind := wd.Measurement("wind_dir_deg")
+	if !hasPressure && !hasWind {
+		return false
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	forecastMutex.Lock()
+	defer forecastMutex.Unlock()
+	sf, ok := forecasts[station]
+	if !ok {
+		sf = new(stationForecast)
+		forecasts[station] = sf
+	}
+	if hasWind {
+		sf.windDir, sf.windTime = dir, t
+	}
+	if hasPressure {
+		sf.samples = append(sf.samples, sample{t, p})
+		drop := 0
+		for drop < len(sf.samples) && t.Sub(sf.samples[drop].t) > forecastWindow {
+			drop++
+		}
+		sf.samples = sf.samples[drop:]
+	}
+	return true
+}
+
+// forecastFor - Zambretti forecast for a station at time now
+func forecastFor(station string, now time.Time) forecast {
+	forecastMutex.Lock()
+	sf, ok := forecasts[station]
+	if !ok || len(sf.samples) == 0 {
+		forecastMutex.Unlock()
+		return forecast{}
+	}
+	samples := append([]sample(nil), sf.samples...)
+	windDir, windTime := sf.windDir, sf.windTime
+	forecastMutex.Unlock()
+
+	fc := forecast{Pressure: samples[len(samples)-1].v}
+	if samples[len(samples)-1].t.Sub(samples[0].t) < forecastMinSpan {
+		return fc
+	}
+	fc.Change = ratePerHour(samples) * forecastWindow.Hours()
+	switch {
+	case fc.Change <= -zambrettiSteadyHPa:
+		fc.Trend = trendFalling
+	case fc.Change >= zambrettiSteadyHPa:
+		fc.Trend = trendRising
+	}
+	hasWind := !windTime.IsZero() && now.Sub(windTime) <= forecastWindow
+	fc.Letter = zambretti(fc.Pressure, fc.Trend, windDir, hasWind, stationSettings(station).Latitude < 0, now.In(stationLocation(station)).Month())
+	fc.Text = zambrettiText[fc.Letter-'A']
+	fc.Ok = true
+	return fc
+}
+
+// zambretti - Forecast letter for sea level pressure p in hPa and its trend, adjusted
+// for wind direction and season
+func zambretti(p float64, trend int, windDir float64, hasWind bool, southern bool, month time.Month) byte {
+	if hasWind {
+		point := int(math.Mod(windDir+11.25+360, 360) / 22.5)
+		if southern {
+			point = (point + 8) % 16
+		}
+		p += zambrettiWind[point]
+	}
+	summer := month >= time.April && month <= time.September
+	if southern {
+		summer = !summer
+	}
+	if summer && trend == trendRising {
+		p += 7
+	} else if !summer && trend == trendFalling {
+		p -= 7
+	}
+	var z float64
+	var letters string
+	var first int
+	switch trend {
+	case trendFalling:
+		z, letters, first = 127-0.12*p, zambrettiFalling, 1
+	case trendRising:
+		z, letters, first = 185-0.16*p, zambrettiRising, 20
+	default:
+		z, letters, first = 144-0.13*p, zambrettiSteady, 10
+	}
+	i := int(math.Round(z)) - first
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(letters) {
+		i = len(letters) - 1
+	}
+	return letters[i]
+}
+
+// formatForecastTrend - Pressure change for the forecast tile
+func formatForecastTrend(fc forecast) string {
+	if !fc.Ok {
+		return "Collecting pressure trend"
+	}
+	switch fc.Trend {
+	case trendFalling:
+		return fmt.Sprintf("Falling %.1f hPa/3h", -fc.Change)
+	case trendRising:
+		return fmt.Sprintf("Rising %.1f hPa/3h", fc.Change)
+	}
+	return fmt.Sprintf("Steady %+.1f hPa/3h", fc.Change)
+}
+
+/******************************************
+ * Forecast tile
+ ******************************************/
+
+// Dashboard tile with a station's pressure and forecast
+type forecastTile struct {
+	box      *fyne.Container
+	title    *canvas.Text
+	pressure *canvas.Text
+	trend    *canvas.Text
+	line1    *canvas.Text
+	line2    *canvas.Text
+}
+
+// newForecastTile - Tile for a station, drawn like the weather widgets
+func newForecastTile(station string) *forecastTile {
+	frame := &canvas.Rectangle{
+		FillColor:   widgetBackgroundColor,
+		StrokeColor: widgetFrameColor,
+		StrokeWidth: strokeWidth,
+	}
+	frame.SetMinSize(fyne.NewSize(widgetSizeX, widgetSizeY))
+	frame.Resize(fyne.NewSize(widgetSizeX, widgetSizeY))
+	frame.CornerRadius = cornerRadius
+
+	ft := &forecastTile{
+		title:    canvas.NewText(station+" forecast", color.Black),
+		pressure: canvas.NewText("", color.Black),
+		trend:    canvas.NewText("", color.Black),
+		line1:    canvas.NewText("", color.Black),
+		line2:    canvas.NewText("", color.Black),
+	}
+	ft.title.TextSize = 18
+	ft.pressure.TextSize = 14
+	ft.pressure.TextStyle = fyne.TextStyle{Bold: true}
+	ft.trend.TextSize = 10
+	ft.line1.TextSize = 14
+	ft.line1.TextStyle = fyne.TextStyle{Bold: true}
+	ft.line2.TextSize = 14
+	ft.line2.TextStyle = fyne.TextStyle{Bold: true}
+	ft.box = container.NewWithoutLayout(frame, ft.title, ft.pressure, ft.trend, ft.line1, ft.line2)
+	return ft
+}
+
+// update - Show a forecast on the tile
+func (ft *forecastTile) update(fc forecast) {
+	ft.pressure.Text = strconv.FormatFloat(fc.Pressure, 'f', 1, 64) + " hPa  " + strconv.FormatFloat(fc.Pressure/hPaPerInHg, 'f', 2, 64) + " inHg"
+	ft.trend.Text = formatForecastTrend(fc)
+	ft.line1.Text, ft.line2.Text = "", ""
+	if fc.Ok {
+		first, rest, _ := strings.Cut(fc.Text, ", ")
+		ft.line1.Text, ft.line2.Text = first, rest
+	}
+	for i, t := range []*canvas.Text{ft.title, ft.pressure, ft.trend, ft.line1, ft.line2} {
+		y := []float32{0, 35, 57, 80, 100}[i]
+		t.Move(fyne.NewPos((widgetSizeX/2)-(t.MinSize().Width)/2, y))
+	}
+	ft.box.Refresh()
+}
+
+// forecastStations - Stations with pressure history, sorted
+func forecastStations() []string {
+	forecastMutex.Lock()
+	var names []string
+	for name, sf := range forecasts {
+		if len(sf.samples) > 0 {
+			names = append(names, name)
+		}
+	}
+	forecastMutex.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// addForecastTiles - Put a tile for each station with pressure at the start of the dashboard.
+// Returns the number of tiles.
+func addForecastTiles(c *fyne.Container) int {
+	tiles := make(map[string]*forecastTile)
+	for _, station := range forecastStations() {
+		ft := newForecastTile(station)
+		tiles[station] = ft
+		ft.update(forecastFor(station, time.Now()))
+		c.Add(ft.box)
+	}
+	forecastTilesMutex.Lock()
+	forecastTiles = tiles
+	forecastTilesMutex.Unlock()
+	return len(tiles)
+}
+
+// refreshForecastTile - Redraw a station's tile after a new reading. A station that has
+// just reported pressure gets its tile by reloading the dashboard, if it is showing.
+func refreshForecastTile(station string) {
+	fc := forecastFor(station, time.Now())
+	forecastTilesMutex.Lock()
+	ft, ok := forecastTiles[station]
+	if !ok && dashFlag && fc.Pressure != 0 {
+		forecastTiles[station] = nil // Reloading, so later readings don't reload again
+		forecastTilesMutex.Unlock()
+		reloadDashboard()
+		return
+	}
+	forecastTilesMutex.Unlock()
+	if ft != nil {
+		ft.update(fc)
+	}
+}
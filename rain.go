@@ -0,0 +1,158 @@
+/******************************************************************
+ *
+ * Rain - Accumulation and rain rate for rain gauges. Gauges report
+ *      a running total (rain_in or rain_mm) that wraps or restarts
+ *      at zero when the batteries are changed, so the rain that fell
+ *      is the increase of the total between readings. A decrease is
+ *      taken as a restart, counting the new total as fallen since.
+ *      Hourly, daily, monthly and season totals follow the station's
+ *      calendar; the season starts in the RainSeasonStart month.
+ *      Totals are kept with the sensor, so they survive a restart.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	rainRateWindow = 15 * time.Minute // Rain in this window, scaled to an hour, is the rain rate
+	rainMmPerIn    = 25.4
+)
+
+// Rain accumulation of a gauge, in inches
+type RainTotals struct {
+	Counter     float64     `json:"Counter"`     // Latest running total reported by the gauge
+	HaveCounter bool        `json:"HaveCounter"` // False until the first reading
+	Hour        float64     `json:"Hour"`
+	Day         float64     `json:"Day"`
+	Month       float64     `json:"Month"`
+	Season      float64     `json:"Season"`
+	HourStart   string      `json:"HourStart"`   // "YYYY-MM-DD HH" of the hour counted in Hour
+	DayStart    string      `json:"DayStart"`    // YYYY-MM-DD of the day counted in Day
+	MonthStart  string      `json:"MonthStart"`  // YYYY-MM of the month counted in Month
+	SeasonStart string      `json:"SeasonStart"` // YYYY-MM the season counted in Season began
+	Rate        float64     `json:"Rate"`        // Inches per hour over the rain rate window
+	Recent      []RainEvent `json:"Recent"`      // Rain in the last 24 hours, oldest first
+	Resets      int         `json:"Resets"`      // Times the gauge's counter went backwards
+}
+
+// Rain counted from one reading
+type RainEvent struct {
+	Time   time.Time `json:"Time"`
+	Amount float64   `json:"Amount"`
+}
+
+// rainCounter - The gauge's running total in inches, if the reading carried one
+func rainCounter(wd *WeatherData) (float64, bool) {
+	if v, ok := wd.Measurement("rain_in"); ok {
+		return v, true
+	}
+	if v, ok := wd.Measurement("rain_mm"); ok {
+		return v / rainMmPerIn, true
+	}
+	return 0, false
+}
+
+// updateRain - Count the rain since the gauge's last reading, which arrived at time t,
+// and add the rain rate and totals to the reading. Caller holds the lock on the sensor's table.
+func (s *Sensor) updateRain(wd *WeatherData, t time.Time) {
+	counter, ok := rainCounter(wd)
+	if !ok {
+		return
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	if s.Rain == nil {
+		s.Rain = new(RainTotals)
+	}
+	rt := s.Rain
+	rt.roll(t.In(stationLocation(s.Station)))
+	amount := 0.0
+	if rt.HaveCounter {
+		amount = counter - rt.Counter
+		if amount < 0 {
+			// Counter wrapped or the gauge restarted, everything it counted since fell after our last reading
+			amount = counter
+			rt.Resets++
+		}
+	}
+	rt.Counter = counter
+	rt.HaveCounter = true
+	if amount > 0 {
+		rt.Hour += amount
+		rt.Day += amount
+		rt.Month += amount
+		rt.Season += amount
+		rt.Recent = append(rt.Recent, RainEvent{Time: t, Amount: amount})
+	}
+	rt.prune(t)
+
+	if wd.Fields == nil {
+		wd.Fields = make(map[string]bool)
+	}
+	wd.RainRate_in_h = rt.Rate
+	wd.RainDay_in = rt.Day
+	wd.Rain24h_in = rt.last24h()
+	wd.Fields["rain_rate_in_h"] = true
+	wd.Fields["rain_day_in"] = true
+	wd.Fields["rain_24h_in"] = true
+}
+
+// roll - Start new hour, day, month and season totals when local time lt is past them
+func (rt *RainTotals) roll(lt time.Time) bool {
+	changed := false
+	if hour := lt.Format(YYYYMMDD + " 15"); rt.HourStart != hour {
+		rt.HourStart, rt.Hour, changed = hour, 0, true
+	}
+	if day := lt.Format(YYYYMMDD); rt.DayStart != day {
+		rt.DayStart, rt.Day, changed = day, 0, true
+	}
+	if month := lt.Format(YYYYMM); rt.MonthStart != month {
+		rt.MonthStart, rt.Month, changed = month, 0, true
+	}
+	if season := seasonStart(lt, settings.RainSeasonStart); rt.SeasonStart != season {
+		rt.SeasonStart, rt.Season, changed = season, 0, true
+	}
+	return changed
+}
+
+// prune - Forget rain older than 24 hours before t and update the rain rate
+func (rt *RainTotals) prune(t time.Time) {
+	drop := 0
+	for drop < len(rt.Recent) && t.Sub(rt.Recent[drop].Time) > 24*time.Hour {
+		drop++
+	}
+	rt.Recent = rt.Recent[drop:]
+	recent := 0.0
+	for _, ev := range rt.Recent {
+		if t.Sub(ev.Time) <= rainRateWindow {
+			recent += ev.Amount
+		}
+	}
+	rt.Rate = recent * float64(time.Hour) / float64(rainRateWindow)
+}
+
+// last24h - Rain in the rolling 24 hours
+func (rt *RainTotals) last24h() float64 {
+	total := 0.0
+	for _, ev := range rt.Recent {
+		total += ev.Amount
+	}
+	return total
+}
+
+// formatRain - Totals of a gauge for the statistics window
+func formatRain(rt *RainTotals) string {
+	return fmt.Sprintf("Rain (in)\n   Hour %.2f   Today %.2f   24 hours %.2f   Month %.2f   Season since %s %.2f   Rate %.2f/h   Counter resets %d\n",
+		rt.Hour, rt.Day, rt.last24h(), rt.Month, rt.SeasonStart, rt.Season, rt.Rate, rt.Resets)
+}
+
+// formatRainLine - Short rain summary for the widget
+func formatRainLine(day, last24h, rate float64) string {
+	return fmt.Sprintf("Rain %.2f today, %.2f 24h, %.2f/h", day, last24h, rate)
+}
@@ -0,0 +1,402 @@
+/******************************************************************
+ *
+ * Rollups - Downsampled history. Every rollupInterval the history
+ *      store's raw samples are summarized into 5 minute buckets,
+ *      those into hourly buckets and those into daily buckets, all
+ *      on UTC boundaries. Each resolution is kept for the days set
+ *      in HistoryRetention and older files are deleted, so raw
+ *      readings can go after a month while the rollups last for
+ *      years. Rollups are in a subdirectory of each series, named
+ *      after the resolution, in files of 40 byte records:
+ *          8 bytes  bucket start, Unix nanoseconds, big endian
+ *          4 bytes  number of samples
+ *          8 bytes  minimum, 8 bytes maximum, 8 bytes sum, float64
+ *          4 bytes  CRC-32 (IEEE) of the 36 bytes before
+ *      A bucket is written once it has ended, so a reading that
+ *      arrives later than rollupLateness is left out of it.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	rollupRecordSize = 40
+	rollupInterval   = 5 * time.Minute
+	rollupLateness   = time.Minute // Buckets are rolled up this long after they end
+	rawResolution    = "raw"
+)
+
+// A downsampled resolution and the UTC period of its files
+type rollupResolution struct {
+	name   string
+	step   time.Duration
+	layout string
+}
+
+// Resolutions, finest first. Each is rolled up from the one before, the first from raw samples.
+var rollupResolutions = []rollupResolution{
+	{"5m", 5 * time.Minute, storeDayFormat},
+	{"1h", time.Hour, "200601"},
+	{"1d", 24 * time.Hour, "2006"},
+}
+
+// watchHistoryRollups - Roll up and prune the history every rollupInterval. Run as a goroutine.
+func watchHistoryRollups() {
+	for {
+		if n, err := historyStore.rollup(time.Now()); err != nil {
+			SetStatus(fmt.Sprintf("History rollup stopped: %s", err))
+		} else if n > 0 {
+			SetStatus(fmt.Sprintf("History rollup removed %d expired files", n))
+		}
+		time.Sleep(rollupInterval)
+	}
+}
+
+// rollup - Write the rollups of every series that have ended by time now, then delete files
+// older than their resolution's retention. A series that fails is reported and skipped.
+// Returns the number of files deleted.
+func (st *tsStore) rollup(now time.Time) (int, error) {
+	if st.dir == "" {
+		return 0, nil
+	}
+	keys, err := os.ReadDir(st.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, k := range keys {
+		if !k.IsDir() {
+			continue
+		}
+		names, err := os.ReadDir(filepath.Join(st.dir, k.Name()))
+		if err != nil {
+			SetStatus(fmt.Sprintf("History rollup skipped %s: %s", k.Name(), err))
+			continue
+		}
+		for _, n := range names {
+			if !n.IsDir() {
+				continue
+			}
+			dir := filepath.Join(st.dir, k.Name(), n.Name())
+			if err := st.rollupSeries(dir, now); err != nil {
+				SetStatus(fmt.Sprintf("History rollup of %s failed: %s", dir, err))
+				continue
+			}
+			count, err := pruneSeries(dir, now)
+			removed += count
+			if err != nil {
+				SetStatus(fmt.Sprintf("History pruning of %s failed: %s", dir, err))
+			}
+		}
+	}
+	return removed, nil
+}
+
+// rollupSeries - Write each resolution's buckets of a series that ended before time now,
+// after the last bucket already written. The files of finished periods don't change, except
+// by appending, so they are read without the store's lock, which the readings being recorded need.
+func (st *tsStore) rollupSeries(dir string, now time.Time) error {
+	st.rollupMutex.Lock()
+	defer st.rollupMutex.Unlock()
+	for i, res := range rollupResolutions {
+		resDir := filepath.Join(dir, res.name)
+		next := time.Time{}
+		if last, ok := st.lastRollup(resDir, res); ok {
+			next = last.Add(res.step)
+		}
+		end := now.Add(-rollupLateness).Truncate(res.step)
+		if !next.Before(end) {
+			continue
+		}
+		var source []historyBucket
+		if i == 0 {
+			samples, err := st.readFiles(dir, next, end)
+			if err != nil {
+				return err
+			}
+			source = sampleBuckets(samples)
+		} else {
+			source = st.readRollups(dir, rollupResolutions[i-1], next, end)
+		}
+		var f *os.File
+		path := ""
+		for _, b := range mergeBuckets(source, time.Unix(0, 0), res.step) {
+			if !b.Start.Before(end) {
+				break // The bucket at end is still filling
+			}
+			if p := filepath.Join(resDir, b.Start.UTC().Format(res.layout)+storeFileSuffix); p != path {
+				if f != nil {
+					f.Close()
+				}
+				var err error
+				if f, err = openStoreFile(p, rollupRecordSize); err != nil {
+					return err
+				}
+				path = p
+			}
+			if _, err := f.Write(encodeRollup(b)); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if f != nil {
+			f.Sync()
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// lastRollup - Start of the newest bucket of a resolution, false if none has been written
+func (st *tsStore) lastRollup(resDir string, res rollupResolution) (time.Time, bool) {
+	files := periodFiles(resDir, res.layout, time.Time{}, time.Now().AddDate(100, 0, 0))
+	for i := len(files) - 1; i >= 0; i-- {
+		buckets := st.readRollupFile(files[i], time.Time{}, time.Now().AddDate(100, 0, 0))
+		if n := len(buckets); n > 0 {
+			return buckets[n-1].Start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// readRollups - Buckets of a series at a resolution starting from time from and before time to
+func (st *tsStore) readRollups(dir string, res rollupResolution, from time.Time, to time.Time) []historyBucket {
+	var out []historyBucket
+	for _, path := range periodFiles(filepath.Join(dir, res.name), res.layout, from, to) {
+		out = append(out, st.readRollupFile(path, from, to)...)
+	}
+	return out
+}
+
+// readRollupFile - Buckets of one rollup file starting from time from and before time to
+func (st *tsStore) readRollupFile(path string, from time.Time, to time.Time) []historyBucket {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var out []historyBucket
+	for i := 0; i+rollupRecordSize <= len(data); i += rollupRecordSize {
+		b, ok := decodeRollup(data[i : i+rollupRecordSize])
+		if !ok {
+			st.corrupt.Add(1)
+			continue
+		}
+		if !b.Start.Before(from) && b.Start.Before(to) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// pruneSeries - Delete the files of a series that are entirely older than their resolution's retention
+func pruneSeries(dir string, now time.Time) (int, error) {
+	removed := 0
+	prune := func(resDir string, resolution string, layout string) error {
+		days := settings.HistoryRetention[resolution]
+		if days <= 0 { // Kept forever
+			return nil
+		}
+		cutoff := now.AddDate(0, 0, -days)
+		// Files of periods before the cutoff's period end before the cutoff
+		for _, path := range periodFiles(resDir, layout, time.Time{}, cutoff) {
+			if filepath.Base(path) == cutoff.UTC().Format(layout)+storeFileSuffix {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	}
+	if err := prune(dir, rawResolution, storeDayFormat); err != nil {
+		return removed, err
+	}
+	for _, res := range rollupResolutions {
+		if err := prune(filepath.Join(dir, res.name), res.name, res.layout); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// query - Buckets of length bucket of a sensor's measurement from time from up to time to,
+// from the coarsest rollup that divides bucket evenly, and raw samples after the newest rollup.
+// A bucket of 0 picks a length to suit the time range.
+func (st *tsStore) query(key string, name string, from time.Time, to time.Time, bucket time.Duration) []historyBucket {
+	if bucket <= 0 {
+		bucket = bucketFor(to.Sub(from))
+	}
+	if bucket <= 0 {
+		return sampleBuckets(st.read(key, name, from, to))
+	}
+	origin := from.Truncate(bucket)
+	var buckets []historyBucket
+	rawFrom := from
+	if st.dir != "" {
+		for i := len(rollupResolutions) - 1; i >= 0; i-- {
+			res := rollupResolutions[i]
+			if res.step > bucket || bucket%res.step != 0 {
+				continue
+			}
+			dir := filepath.Join(st.dir, storeEscape(key), storeEscape(name))
+			st.rollupMutex.Lock()
+			buckets = st.readRollups(dir, res, origin, to)
+			st.rollupMutex.Unlock()
+			if n := len(buckets); n > 0 {
+				rawFrom = buckets[n-1].Start.Add(res.step)
+			}
+			break
+		}
+	}
+	if !rawFrom.After(to) {
+		buckets = append(buckets, sampleBuckets(st.read(key, name, rawFrom, to))...)
+	}
+	return mergeBuckets(buckets, origin, bucket)
+}
+
+// bucketFor - Bucket length for a query over a time range: raw samples up to a day, then the
+// rollups in turn, so a chart has a few hundred to a few thousand points
+func bucketFor(span time.Duration) time.Duration {
+	switch {
+	case span <= 24*time.Hour:
+		return 0
+	case span <= 7*24*time.Hour:
+		return 5 * time.Minute
+	case span <= 90*24*time.Hour:
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// encodeRollup - Record of a bucket
+func encodeRollup(b historyBucket) []byte {
+	rec := make([]byte, rollupRecordSize)
+	binary.BigEndian.PutUint64(rec[0:8], uint64(b.Start.UnixNano()))
+	binary.BigEndian.PutUint32(rec[8:12], uint32(b.Count))
+	binary.BigEndian.PutUint64(rec[12:20], math.Float64bits(b.Min))
+	binary.BigEndian.PutUint64(rec[20:28], math.Float64bits(b.Max))
+	binary.BigEndian.PutUint64(rec[28:36], math.Float64bits(b.Avg*float64(b.Count)))
+	binary.BigEndian.PutUint32(rec[36:40], crc32.ChecksumIEEE(rec[0:36]))
+	return rec
+}
+
+// decodeRollup - Bucket of a record, false if its CRC is wrong or it is empty
+func decodeRollup(rec []byte) (historyBucket, bool) {
+	if crc32.ChecksumIEEE(rec[0:36]) != binary.BigEndian.Uint32(rec[36:40]) {
+		return historyBucket{}, false
+	}
+	b := historyBucket{
+		Start: time.Unix(0, int64(binary.BigEndian.Uint64(rec[0:8]))),
+		Count: int(binary.BigEndian.Uint32(rec[8:12])),
+		Min:   math.Float64frombits(binary.BigEndian.Uint64(rec[12:20])),
+		Max:   math.Float64frombits(binary.BigEndian.Uint64(rec[20:28])),
+	}
+	if b.Count == 0 {
+		return b, false
+	}
+	b.Avg = math.Float64frombits(binary.BigEndian.Uint64(rec[28:36])) / float64(b.Count)
+	return b, true
+}
+
+// mergeRollups - Add samples, oldest first, to the buckets of a series already rolled up, for
+// samples written after their period was. Later buckets are left to the rollup.
+func (st *tsStore) mergeRollups(key string, name string, samples []sample) error {
+	if st.dir == "" || len(samples) == 0 {
+		return nil
+	}
+	st.rollupMutex.Lock()
+	defer st.rollupMutex.Unlock()
+	dir := filepath.Join(st.dir, storeEscape(key), storeEscape(name))
+	for _, res := range rollupResolutions {
+		resDir := filepath.Join(dir, res.name)
+		last, ok := st.lastRollup(resDir, res)
+		if !ok {
+			continue
+		}
+		files := make(map[string][]historyBucket) // Period file : new buckets
+		for _, b := range mergeBuckets(sampleBuckets(samples), time.Unix(0, 0), res.step) {
+			if b.Start.After(last) {
+				break
+			}
+			path := filepath.Join(resDir, b.Start.UTC().Format(res.layout)+storeFileSuffix)
+			files[path] = append(files[path], b)
+		}
+		for path, added := range files {
+			buckets := append(st.readRollupFile(path, time.Time{}, time.Now().AddDate(100, 0, 0)), added...)
+			sort.SliceStable(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+			if err := writeRollupFile(path, mergeBuckets(buckets, time.Unix(0, 0), res.step)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prunedRollups - For the UTC day of time t of a series, if its raw file has been pruned, a test of
+// whether a time falls in a bucket of the finest rollup left. Nil if the raw file is there, or no
+// rollup covers the day.
+func (st *tsStore) prunedRollups(key string, name string, t time.Time) func(time.Time) bool {
+	if st.dir == "" {
+		return nil
+	}
+	dir := filepath.Join(st.dir, storeEscape(key), storeEscape(name))
+	day := t.UTC().Truncate(24 * time.Hour)
+	if _, err := os.Stat(filepath.Join(dir, day.Format(storeDayFormat)+storeFileSuffix)); err == nil {
+		return nil
+	}
+	st.rollupMutex.Lock()
+	defer st.rollupMutex.Unlock()
+	for _, res := range rollupResolutions {
+		buckets := st.readRollups(dir, res, day, day.Add(24*time.Hour))
+		if len(buckets) == 0 {
+			continue
+		}
+		starts := make(map[int64]bool, len(buckets))
+		for _, b := range buckets {
+			starts[b.Start.UnixNano()] = true
+		}
+		step := res.step
+		return func(t time.Time) bool { return starts[t.Truncate(step).UnixNano()] }
+	}
+	return nil
+}
+
+// writeRollupFile - Replace a rollup file with buckets, through a temporary file so a crash
+// leaves the old or the new one
+func writeRollupFile(path string, buckets []historyBucket) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data := make([]byte, 0, len(buckets)*rollupRecordSize)
+	for _, b := range buckets {
+		data = append(data, encodeRollup(b)...)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
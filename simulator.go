@@ -0,0 +1,344 @@
+/******************************************************************
+ *
+ * Simulator - Readings of made up Acurite, LaCrosse and Fine
+ *      Offset sensors, for demos and for trying the dashboard
+ *      without a radio. Each simulated sensor sends rtl_433 style
+ *      JSON every IntervalSecs: temperature follows a daily cycle
+ *      peaking mid afternoon, humidity the opposite, with noise on
+ *      both, and the Fine Offset station adds wind and rain. Like
+ *      the real ones, Acurite sensors send each reading three times.
+ *      By the Simulator settings some readings are sent again
+ *      (duplicate bursts), some sensors go quiet for a few intervals
+ *      (dropouts) and some report a low battery for a few hours.
+ *      Messages go on topic <Station>/rtl_433/simulator/events either
+ *      straight into processMessage or to the connected broker.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	simLowBatteryTime = 6 * time.Hour // How long a low battery lasts
+	simMaxDropout     = 5             // Most intervals a dropout lasts
+)
+
+var (
+	simTargets                      = []string{"ingest", "broker"}
+	simulatorFlag        bool       = false // Simulator window flag. If true, window is open.
+	runningSimulator     *simulator         // The simulator sending readings, nil if none
+	runningSimulatorLock sync.Mutex
+)
+
+// Options of the simulator, saved with the settings
+type SimulatorSettings struct {
+	Station          string      `json:"Station"`          // Station the readings are heard by
+	Target           string      `json:"Target"`           // "ingest" straight into processMessage, "broker" to publish
+	Seed             int64       `json:"Seed"`             // Random seed, 0 for a different run each time
+	DuplicateChance  float64     `json:"DuplicateChance"`  // Chance a reading is sent again in a burst
+	DropoutChance    float64     `json:"DropoutChance"`    // Chance a sensor goes quiet for a few intervals
+	LowBatteryChance float64     `json:"LowBatteryChance"` // Chance per reading a sensor's battery goes low
+	Devices          []SimDevice `json:"Devices"`          // Simulated sensors, defaultSimDevices if none
+}
+
+// A simulated sensor
+type SimDevice struct {
+	Type         string  `json:"Type"` // "acurite", "lacrosse" or "fineoffset"
+	Id           int     `json:"Id"`
+	Channel      string  `json:"Channel"`
+	IntervalSecs float64 `json:"IntervalSecs"` // Time between readings
+	BaseTempF    float64 `json:"BaseTempF"`    // Average temperature of the day
+	SwingF       float64 `json:"SwingF"`       // Difference of the afternoon high from the average
+	BaseHumidity float64 `json:"BaseHumidity"` // Average humidity of the day
+}
+
+// defaultSimDevices - One sensor of each type
+func defaultSimDevices() []SimDevice {
+	return []SimDevice{
+		{Type: "acurite", Id: 1001, Channel: "A", IntervalSecs: 30, BaseTempF: 68, SwingF: 12, BaseHumidity: 55},
+		{Type: "lacrosse", Id: 2002, Channel: "0", IntervalSecs: 50, BaseTempF: 72, SwingF: 2, BaseHumidity: 40},
+		{Type: "fineoffset", Id: 3003, IntervalSecs: 16, BaseTempF: 66, SwingF: 14, BaseHumidity: 60},
+	}
+}
+
+// State of a simulated sensor between readings
+type simState struct {
+	dev       SimDevice
+	next      time.Time // Time of the next reading
+	quietTill time.Time // In a dropout until this time
+	lowTill   time.Time // Battery low until this time
+	rainMM    float64   // Rain gauge total
+	windDir   float64
+}
+
+// A running simulation
+type simulator struct {
+	opts    SimulatorSettings
+	rng     *rand.Rand
+	sensors []*simState
+	send    func(topic string, payload []byte, t time.Time) error
+	stop    chan struct{}
+	halted  sync.Once
+}
+
+// newSimulator - Simulation by opts, sending to its target, starting at time start
+func newSimulator(opts SimulatorSettings, start time.Time) *simulator {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if opts.Station == "" {
+		opts.Station = "Demo"
+	}
+	devices := opts.Devices
+	if len(devices) == 0 {
+		devices = defaultSimDevices()
+	}
+	sim := &simulator{opts: opts, rng: rand.New(rand.NewSource(seed)), stop: make(chan struct{})}
+	for _, d := range devices {
+		if d.IntervalSecs <= 0 {
+			d.IntervalSecs = 30
+		}
+		// Spread the first readings over the first interval, as real sensors are
+		offset := time.Duration(sim.rng.Float64() * d.IntervalSecs * float64(time.Second))
+		sim.sensors = append(sim.sensors, &simState{dev: d, next: start.Add(offset), windDir: sim.rng.Float64() * 360})
+	}
+	sim.send = func(topic string, payload []byte, t time.Time) error {
+		processMessage(topic, payload, t)
+		return nil
+	}
+	if opts.Target == "broker" {
+		sim.send = func(topic string, payload []byte, t time.Time) error {
+			return publishToBroker(topic, payload)
+		}
+	}
+	return sim
+}
+
+// topic - Topic the simulated readings are sent on
+func (sim *simulator) topic() string {
+	return sim.opts.Station + "/rtl_433/simulator/events"
+}
+
+// step - Send the readings due by time now. Returns the number of messages sent.
+func (sim *simulator) step(now time.Time) (int, error) {
+	sent := 0
+	for _, st := range sim.sensors {
+		interval := time.Duration(st.dev.IntervalSecs * float64(time.Second))
+		for !st.next.After(now) {
+			t := st.next
+			st.next = st.next.Add(interval)
+			if t.Before(st.quietTill) {
+				continue
+			}
+			if sim.rng.Float64() < sim.opts.DropoutChance {
+				st.quietTill = t.Add(time.Duration(1+sim.rng.Intn(simMaxDropout)) * interval)
+				continue
+			}
+			if !t.Before(st.lowTill) && sim.rng.Float64() < sim.opts.LowBatteryChance {
+				st.lowTill = t.Add(simLowBatteryTime)
+			}
+			copies := 1
+			if st.dev.Type == "acurite" {
+				copies = 3
+			}
+			if sim.rng.Float64() < sim.opts.DuplicateChance {
+				copies += 1 + sim.rng.Intn(3)
+			}
+			reading := sim.reading(st, t)
+			for i := 0; i < copies; i++ {
+				if st.dev.Type == "acurite" {
+					reading["sequence_num"] = i % 3
+				}
+				payload, err := json.Marshal(reading)
+				if err != nil {
+					return sent, err
+				}
+				if err := sim.send(sim.topic(), payload, t); err != nil {
+					return sent, err
+				}
+				sent++
+			}
+		}
+	}
+	return sent, nil
+}
+
+// reading - rtl_433 fields of a simulated sensor's reading at time t
+func (sim *simulator) reading(st *simState, t time.Time) map[string]interface{} {
+	local := t.In(stationLocation(sim.opts.Station))
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	cycle := math.Cos(2 * math.Pi * (hour - 15) / 24) // 1 at 3 pm, -1 at 3 am
+	temp := st.dev.BaseTempF + st.dev.SwingF*cycle + sim.rng.NormFloat64()*0.3
+	humidity := st.dev.BaseHumidity - 1.5*st.dev.SwingF*cycle + sim.rng.NormFloat64()
+	humidity = math.Max(5, math.Min(100, humidity))
+	battery := 1
+	if t.Before(st.lowTill) {
+		battery = 0
+	}
+	r := map[string]interface{}{
+		"time":          local.Format(YYYYMMDD + " " + HHMMSS24h),
+		"id":            st.dev.Id,
+		"battery_ok":    battery,
+		"temperature_F": math.Round(temp*10) / 10,
+		"humidity":      math.Round(humidity),
+		"rssi":          math.Round((-5+sim.rng.NormFloat64()*2)*100) / 100,
+		"snr":           math.Round((18+sim.rng.NormFloat64()*2)*100) / 100,
+		"noise":         -23.0,
+		"freq":          433.92,
+	}
+	switch st.dev.Type {
+	case "lacrosse":
+		r["model"] = "LaCrosse-TX141THBv2"
+		channel, _ := strconv.Atoi(st.dev.Channel)
+		r["channel"] = channel
+		r["test"] = "No"
+		r["mic"] = "CRC"
+		r["freq"] = 433.88
+	case "fineoffset":
+		r["model"] = "Fineoffset-WH24"
+		r["mic"] = "CRC"
+		r["freq"] = 915.0
+		// Wind picks up in the afternoon and veers slowly
+		avg := math.Max(0, 2+1.5*cycle+sim.rng.NormFloat64()*0.7)
+		st.windDir = math.Mod(st.windDir+sim.rng.NormFloat64()*10+360, 360)
+		r["wind_avg_m_s"] = math.Round(avg*10) / 10
+		r["wind_max_m_s"] = math.Round((avg*1.5+sim.rng.Float64())*10) / 10
+		r["wind_dir_deg"] = math.Round(st.windDir)
+		if sim.rng.Float64() < 0.02 { // An occasional shower
+			st.rainMM += 0.3
+		}
+		r["rain_mm"] = math.Round(st.rainMM*10) / 10
+	default:
+		r["model"] = "Acurite-Tower"
+		r["channel"] = st.dev.Channel
+		r["message_type"] = 56
+		r["mic"] = "CHECKSUM"
+	}
+	return r
+}
+
+// halt - Stop a running simulation
+func (sim *simulator) halt() {
+	sim.halted.Do(func() { close(sim.stop) })
+}
+
+// run - Send readings as they fall due until halted. Run as a goroutine.
+func (sim *simulator) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sim.stop:
+			return
+		case now := <-ticker.C:
+			if _, err := sim.step(now); err != nil {
+				SetStatus(fmt.Sprintf("Simulator stopped: %s", err))
+				return
+			}
+		}
+	}
+}
+
+// startSimulator - Start sending simulated readings by the settings, stopping any running simulation
+func startSimulator() {
+	stopSimulator()
+	sim := newSimulator(settings.Simulator, time.Now())
+	runningSimulatorLock.Lock()
+	runningSimulator = sim
+	runningSimulatorLock.Unlock()
+	go sim.run()
+	SetStatus(fmt.Sprintf("Simulating %d sensors at station %s, sending to %s", len(sim.sensors), sim.opts.Station, settings.Simulator.Target))
+}
+
+// stopSimulator - Stop the running simulation, if any
+func stopSimulator() {
+	runningSimulatorLock.Lock()
+	defer runningSimulatorLock.Unlock()
+	if runningSimulator != nil {
+		runningSimulator.halt()
+		runningSimulator = nil
+	}
+}
+
+// simulatorHandler - Opens a window to start and stop simulated sensors
+var simulatorHandler = func() {
+	if simulatorFlag {
+		return
+	}
+	simulatorFlag = true
+	stationEntry := widget.NewEntry()
+	stationEntry.SetText(settings.Simulator.Station)
+	stationEntry.SetPlaceHolder("Demo")
+	targetSelect := widget.NewSelect(simTargets, nil)
+	targetSelect.SetSelected(settings.Simulator.Target)
+	chance := func(v float64) *widget.Entry {
+		e := widget.NewEntry()
+		e.SetText(strconv.FormatFloat(v, 'f', -1, 64))
+		return e
+	}
+	duplicateEntry := chance(settings.Simulator.DuplicateChance)
+	dropoutEntry := chance(settings.Simulator.DropoutChance)
+	lowBatteryEntry := chance(settings.Simulator.LowBatteryChance)
+	simWindow := a.NewWindow("Simulator")
+	simWindow.SetOnClosed(func() {
+		simulatorFlag = false
+	})
+	start := widget.NewButton("Start", func() {
+		opts := settings.Simulator
+		for _, c := range []struct {
+			entry *widget.Entry
+			value *float64
+			name  string
+		}{
+			{duplicateEntry, &opts.DuplicateChance, "duplicate"},
+			{dropoutEntry, &opts.DropoutChance, "dropout"},
+			{lowBatteryEntry, &opts.LowBatteryChance, "low battery"},
+		} {
+			v, err := strconv.ParseFloat(c.entry.Text, 64)
+			if err != nil || v < 0 || v > 1 {
+				SetStatus(fmt.Sprintf("Simulator not started, %s chance %q is not between 0 and 1", c.name, c.entry.Text))
+				return
+			}
+			*c.value = v
+		}
+		opts.Station = stationEntry.Text
+		opts.Target = targetSelect.Selected
+		settings.Simulator = opts
+		startSimulator()
+	})
+	stop := widget.NewButton("Stop", func() {
+		stopSimulator()
+		SetStatus("Simulator stopped")
+	})
+	simWindow.SetContent(container.NewVBox(
+		widget.NewLabel("Station"),
+		stationEntry,
+		widget.NewLabel("Send readings to"),
+		targetSelect,
+		widget.NewLabel("Chance of a duplicate burst, 0 to 1"),
+		duplicateEntry,
+		widget.NewLabel("Chance of a dropout, 0 to 1"),
+		dropoutEntry,
+		widget.NewLabel("Chance of a low battery, 0 to 1"),
+		lowBatteryEntry,
+		container.NewHBox(start, stop, widget.NewButton("Close", func() {
+			simWindow.Close()
+		})),
+	))
+	simWindow.Resize(fyne.NewSize(400, 400))
+	simWindow.Show()
+}
@@ -0,0 +1,126 @@
+/******************************************************************
+ *
+ * Stale sensors - Learns how often each sensor transmits and flags
+ *      active sensors that have gone silent. A sensor is offline once
+ *      it has not been heard for StaleFactor times its interval; its
+ *      widget turns stale and an "offline" alert is raised.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	staleCheckInterval = 30 * time.Second
+	minLearnSecs       = 5.0 // Ignore repeats within one transmission burst when learning intervals
+)
+
+var staleWatchStart = time.Now() // Sensors are not judged on silence from before the program started
+
+// heard - Record the arrival of a reading and learn the sensor's transmit interval.
+// Returns true if the sensor had been offline.
+func (s *Sensor) heard(received time.Time) (wasOffline bool) {
+	if received.IsZero() {
+		received = time.Now()
+	}
+	last, err := time.ParseInLocation(YYYYMMDD+" "+HHMMSS24h, s.LastHeard, time.Local)
+	if err == nil && last.After(staleWatchStart) {
+		dt := received.Sub(last).Seconds()
+		// Skip repeats and gaps that are outages rather than the normal interval
+		if dt >= minLearnSecs && (s.LearnedInterval == 0 || dt < s.LearnedInterval*staleFactor()) {
+			if s.LearnedInterval == 0 {
+				s.LearnedInterval = dt
+			} else {
+				s.LearnedInterval += (dt - s.LearnedInterval) * 0.2
+			}
+		}
+	}
+	s.LastHeard = received.Local().Format(YYYYMMDD + " " + HHMMSS24h)
+	wasOffline = s.Offline
+	s.Offline = false
+	return wasOffline
+}
+
+// interval - Expected seconds between readings, configured or learned, 0 if unknown
+func (s *Sensor) interval() float64 {
+	if s.ExpectedInterval > 0 {
+		return float64(s.ExpectedInterval)
+	}
+	return s.LearnedInterval
+}
+
+// checkStaleSensors - Mark active sensors that have been silent too long as offline.
+// Returns copies of the sensors that just went offline.
+func checkStaleSensors(now time.Time) []Sensor {
+	var gone []Sensor
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	for _, s := range activeSensors {
+		interval := s.interval()
+		if s.Offline || interval <= 0 {
+			continue
+		}
+		last, err := time.ParseInLocation(YYYYMMDD+" "+HHMMSS24h, s.LastHeard, time.Local)
+		if err != nil || last.Before(staleWatchStart) {
+			last = staleWatchStart
+		}
+		if now.Sub(last).Seconds() > interval*staleFactor() {
+			s.Offline = true
+			gone = append(gone, *s)
+		}
+	}
+	return gone
+}
+
+// watchStaleSensors - Background check for silent sensors. Runs forever.
+func watchStaleSensors() {
+	ticker := time.NewTicker(staleCheckInterval)
+	for now := range ticker.C {
+		for _, s := range checkStaleSensors(now) {
+			raiseAlert("offline:"+s.Key, Alert{
+				SensorKey: s.Key,
+				Kind:      "offline",
+				Message:   fmt.Sprintf("Sensor offline: %s (%s), last heard %s", s.Name, s.Key, s.LastHeard),
+				Sinks:     settings.AlertSinks,
+			})
+			setWidgetStale(s.Key, true)
+		}
+	}
+}
+
+// setWidgetStale - Mark a sensor's widget stale or fresh and ask it to redraw
+func setWidgetStale(key string, stale bool) {
+	if !checkWeatherWidget(key) {
+		return
+	}
+	ww := weatherWidgets[key]
+	ww.stale = stale
+	select {
+	case ww.channel <- key:
+	default: // Widget handler not running or busy, widget picks up the flag on next refresh
+	}
+}
+
+// staleFactor - Missed intervals before a sensor is offline, with a sane default
+func staleFactor() float64 {
+	if settings.StaleFactor <= 1 {
+		return 3
+	}
+	return settings.StaleFactor
+}
+
+// formatLastHeard - Last heard time for the sensor lists
+func formatLastHeard(s *Sensor) string {
+	if s.LastHeard == "" {
+		return "Last heard: never"
+	}
+	str := "Last heard: " + s.LastHeard
+	if s.Offline {
+		str = str + " (OFFLINE)"
+	}
+	return str
+}
@@ -0,0 +1,162 @@
+/******************************************************************
+ *
+ * Stations - Options for each station (the first segment of the
+ *      rtl_433 topic): time zone for the daily statistics, and
+ *      elevation and latitude for the sea level pressure and the
+ *      forecast. Kept in config.json under Stations.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Station options, keyed by station name in config.json
+type StationSettings struct {
+	Timezone  string  `json:"Timezone"`  // IANA zone, e.g. "America/Chicago". Empty for the computer's zone.
+	Elevation float64 `json:"Elevation"` // Metres above sea level
+	Latitude  float64 `json:"Latitude"`  // Degrees, negative in the southern hemisphere
+}
+
+var (
+	stations            = make(map[string]StationSettings)
+	stationsMutex       sync.Mutex         // Locks stations, read them through stationSettings or copyStations
+	stationSettingsFlag bool       = false // Station settings window flag. If true, window is open.
+)
+
+// stationSettings - Options of a station, zero if it has none
+func stationSettings(station string) StationSettings {
+	stationsMutex.Lock()
+	defer stationsMutex.Unlock()
+	return stations[station]
+}
+
+// setStationSettings - Change the options of a station
+func setStationSettings(station string, st StationSettings) {
+	stationsMutex.Lock()
+	defer stationsMutex.Unlock()
+	stations[station] = st
+}
+
+// copyStations - A copy of the options of every station
+func copyStations() map[string]StationSettings {
+	stationsMutex.Lock()
+	defer stationsMutex.Unlock()
+	c := make(map[string]StationSettings, len(stations))
+	for name, st := range stations {
+		c[name] = st
+	}
+	return c
+}
+
+// stationLocation - Time zone of a station, the computer's zone if none is set or it is unknown
+func stationLocation(station string) *time.Location {
+	tz := stationSettings(station).Timezone
+	if tz == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// stationNames - Stations with settings or active sensors, sorted
+func stationNames() []string {
+	seen := make(map[string]bool)
+	for name := range copyStations() {
+		seen[name] = true
+	}
+	activeSensorsMutex.Lock()
+	for _, s := range activeSensors {
+		if s.Station != "" {
+			seen[s.Station] = true
+		}
+	}
+	activeSensorsMutex.Unlock()
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stationSettingsHandler - Opens a window to set the time zone, elevation and latitude of a station
+var stationSettingsHandler = func() {
+	if stationSettingsFlag {
+		return
+	}
+	names := stationNames()
+	if len(names) == 0 {
+		SetStatus("No stations yet, activate a sensor first")
+		return
+	}
+	stationSettingsFlag = true
+	tzEntry := widget.NewEntry()
+	tzEntry.SetPlaceHolder("America/Chicago, empty for this computer's time zone")
+	elevationEntry := widget.NewEntry()
+	elevationEntry.SetPlaceHolder("Elevation in metres")
+	latitudeEntry := widget.NewEntry()
+	latitudeEntry.SetPlaceHolder("Latitude in degrees, negative for south")
+	stationSelect := widget.NewSelect(names, func(name string) {
+		st := stationSettings(name)
+		tzEntry.SetText(st.Timezone)
+		elevationEntry.SetText(strconv.FormatFloat(st.Elevation, 'f', -1, 64))
+		latitudeEntry.SetText(strconv.FormatFloat(st.Latitude, 'f', -1, 64))
+	})
+	stationSelect.SetSelectedIndex(0)
+
+	stationWindow := a.NewWindow("Station Settings")
+	stationWindow.SetOnClosed(func() {
+		stationSettingsFlag = false
+	})
+	save := widget.NewButton("Save", func() {
+		name := stationSelect.Selected
+		tz := strings.TrimSpace(tzEntry.Text)
+		if _, err := time.LoadLocation(tz); err != nil {
+			SetStatus(fmt.Sprintf("Station %s not saved, unknown time zone %q", name, tz))
+			return
+		}
+		elevation, err := strconv.ParseFloat(strings.TrimSpace(elevationEntry.Text), 64)
+		if err != nil {
+			SetStatus(fmt.Sprintf("Station %s not saved, elevation %q is not a number", name, elevationEntry.Text))
+			return
+		}
+		latitude, err := strconv.ParseFloat(strings.TrimSpace(latitudeEntry.Text), 64)
+		if err != nil || latitude < -90 || latitude > 90 {
+			SetStatus(fmt.Sprintf("Station %s not saved, latitude %q is not between -90 and 90", name, latitudeEntry.Text))
+			return
+		}
+		setStationSettings(name, StationSettings{Timezone: tz, Elevation: elevation, Latitude: latitude})
+		SetStatus(fmt.Sprintf("Saved settings for station %s", name))
+	})
+	form := container.NewVBox(
+		widget.NewLabel("Station"),
+		stationSelect,
+		widget.NewLabel("Time zone"),
+		tzEntry,
+		widget.NewLabel("Elevation (m)"),
+		elevationEntry,
+		widget.NewLabel("Latitude"),
+		latitudeEntry,
+		container.NewHBox(save, widget.NewButton("Close", func() {
+			stationWindow.Close()
+		})),
+	)
+	stationWindow.SetContent(form)
+	stationWindow.Resize(fyne.NewSize(450, 350))
+	stationWindow.Show()
+}
@@ -0,0 +1,302 @@
+/******************************************************************
+ *
+ * Statistics - Minimum, maximum and average of every measurement a
+ *      sensor reports, for today, yesterday, this month and all
+ *      time, with the time of each extreme. Days and months follow
+ *      the local calendar of the sensor's station, so the daily
+ *      statistics reset at the station's midnight.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	YYYYMM              = "2006-01"
+	statsRolloverPeriod = time.Minute // How often the calendar is checked for a new day
+)
+
+// Statistics of one measurement over one period
+type StatPeriod struct {
+	Start   string  `json:"Start"` // Day (YYYY-MM-DD) or month (YYYY-MM) covered, empty for all time
+	Count   int     `json:"Count"`
+	Sum     float64 `json:"Sum"`
+	Min     float64 `json:"Min"`
+	MinTime string  `json:"MinTime"`
+	Max     float64 `json:"Max"`
+	MaxTime string  `json:"MaxTime"`
+}
+
+// Statistics of one measurement over each period
+type MeasurementStats struct {
+	Today     StatPeriod `json:"Today"`
+	Yesterday StatPeriod `json:"Yesterday"`
+	Month     StatPeriod `json:"Month"`
+	AllTime   StatPeriod `json:"AllTime"`
+}
+
+// add - Include a value taken at local time st
+func (p *StatPeriod) add(v float64, st string) {
+	if p.Count == 0 || v < p.Min {
+		p.Min = v
+		p.MinTime = st
+	}
+	if p.Count == 0 || v > p.Max {
+		p.Max = v
+		p.MaxTime = st
+	}
+	p.Count++
+	p.Sum += v
+}
+
+// Avg - Mean of the values, 0 if there are none
+func (p StatPeriod) Avg() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Sum / float64(p.Count)
+}
+
+// roll - Start new periods when local time lt is in a different day or month.
+// Returns true if today's period changed.
+func (ms *MeasurementStats) roll(lt time.Time) bool {
+	day := lt.Format(YYYYMMDD)
+	month := lt.Format(YYYYMM)
+	if ms.Month.Start != month {
+		ms.Month = StatPeriod{Start: month}
+	}
+	if ms.Today.Start == day {
+		return false
+	}
+	yesterday := lt.AddDate(0, 0, -1).Format(YYYYMMDD)
+	if ms.Today.Start == yesterday {
+		ms.Yesterday = ms.Today
+	} else if ms.Yesterday.Start != yesterday {
+		ms.Yesterday = StatPeriod{Start: yesterday}
+	}
+	ms.Today = StatPeriod{Start: day}
+	return true
+}
+
+// seasonStart - YYYY-MM the season beginning in month startMonth that contains local time lt began
+func seasonStart(lt time.Time, startMonth int) string {
+	first := time.Month(startMonth)
+	if first < time.January || first > time.December {
+		first = time.January
+	}
+	year := lt.Year()
+	if lt.Month() < first {
+		year--
+	}
+	return time.Date(year, first, 1, 0, 0, 0, 0, lt.Location()).Format(YYYYMM)
+}
+
+// updateStats - Add the measurements of a reading that arrived at time t.
+// Caller holds the lock on the sensor's table.
+func (s *Sensor) updateStats(wd WeatherData, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	lt := t.In(stationLocation(s.Station))
+	st := lt.Format(YYYYMMDD + " " + HHMMSS24h)
+	if s.Stats == nil {
+		s.Stats = make(map[string]*MeasurementStats)
+	}
+	for name, v := range wd.Measurements() {
+		ms, ok := s.Stats[name]
+		if !ok {
+			ms = new(MeasurementStats)
+			s.Stats[name] = ms
+		}
+		prev := ms.Today
+		if ms.roll(lt) && name == "temperature_F" && prev.Count > 0 {
+			s.closeDay(prev)
+		}
+		if name == "temperature_F" {
+			s.addChill(v, t)
+		}
+		ms.Today.add(v, st)
+		ms.Month.add(v, st)
+		ms.AllTime.add(v, st)
+	}
+	s.syncHiLo()
+}
+
+// rollStats - Start a new day for every measurement if it is past midnight at the
+// sensor's station. Returns true if the day changed. Caller holds the lock.
+func (s *Sensor) rollStats(now time.Time) bool {
+	lt := now.In(stationLocation(s.Station))
+	changed := false
+	for name, ms := range s.Stats {
+		prev := ms.Today
+		if ms.roll(lt) {
+			changed = true
+			if name == "temperature_F" && prev.Count > 0 {
+				s.closeDay(prev)
+			}
+		}
+	}
+	if changed {
+		s.syncHiLo()
+	}
+	if s.Rain != nil {
+		if s.Rain.roll(lt) {
+			changed = true
+		}
+		s.Rain.prune(now)
+	}
+	return changed
+}
+
+// resetTodayStats - Forget today's statistics, as if the day had just begun
+func (s *Sensor) resetTodayStats() {
+	for _, ms := range s.Stats {
+		ms.Today = StatPeriod{Start: ms.Today.Start}
+	}
+	s.syncHiLo()
+}
+
+// syncHiLo - Keep the sensor's hi/lo values on today's statistics. Until there is a
+// reading today, the hi and lo are the latest value.
+func (s *Sensor) syncHiLo() {
+	s.HighTemp, s.LowTemp = s.Temp, s.Temp
+	if ms, ok := s.Stats["temperature_F"]; ok && ms.Today.Count > 0 {
+		s.HighTemp, s.LowTemp = ms.Today.Max, ms.Today.Min
+	}
+	s.HighHumidity, s.LowHumidity = s.Humidity, s.Humidity
+	if ms, ok := s.Stats["humidity"]; ok && ms.Today.Count > 0 {
+		s.HighHumidity, s.LowHumidity = ms.Today.Max, ms.Today.Min
+	}
+}
+
+// watchStatsRollover - Reset the daily statistics at each station's midnight, even
+// for sensors that have not reported since. Runs forever.
+func watchStatsRollover() {
+	ticker := time.NewTicker(statsRolloverPeriod)
+	for now := range ticker.C {
+		activeSensorsMutex.Lock()
+		var rolled []string
+		for key, s := range activeSensors {
+			if s.rollStats(now) {
+				rolled = append(rolled, key)
+			}
+		}
+		activeSensorsMutex.Unlock()
+		for _, key := range rolled {
+			refreshWidgetHiLo(key)
+		}
+	}
+}
+
+// refreshWidgetHiLo - Copy a sensor's hi/lo values and rain totals to its widget and ask it to redraw
+func refreshWidgetHiLo(key string) {
+	if !checkWeatherWidget(key) {
+		return
+	}
+	ww := weatherWidgets[key]
+	activeSensorsMutex.Lock()
+	s := activeSensors[key]
+	ww.highTemp, ww.lowTemp = s.HighTemp, s.LowTemp
+	ww.highHumidity, ww.lowHumidity = s.HighHumidity, s.LowHumidity
+	if s.Rain != nil {
+		ww.rainDay, ww.rain24h, ww.rainRate = s.Rain.Day, s.Rain.last24h(), s.Rain.Rate
+	}
+	activeSensorsMutex.Unlock()
+	select {
+	case ww.channel <- key:
+	default: // Widget handler not running or busy, widget picks up the values on next refresh
+	}
+}
+
+// formatStats - Table of a sensor's statistics, one block per measurement
+func formatStats(s *Sensor) string {
+	if len(s.Stats) == 0 {
+		return "No readings yet"
+	}
+	var b strings.Builder
+	if s.Rain != nil {
+		b.WriteString(formatRain(s.Rain))
+	}
+	var names []string
+	for _, name := range measurementNames {
+		if _, ok := s.Stats[name]; ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		ms := s.Stats[name]
+		b.WriteString(name + "\n")
+		periods := []struct {
+			label string
+			p     StatPeriod
+		}{
+			{"Today", ms.Today},
+			{"Yesterday", ms.Yesterday},
+			{"Month", ms.Month},
+			{"All time", ms.AllTime},
+		}
+		for _, per := range periods {
+			if per.p.Count == 0 {
+				b.WriteString(fmt.Sprintf("   %-10s no readings\n", per.label))
+				continue
+			}
+			b.WriteString(fmt.Sprintf("   %-10s min %7.1f at %s   max %7.1f at %s   avg %7.1f\n",
+				per.label, per.p.Min, per.p.MinTime, per.p.Max, per.p.MaxTime, per.p.Avg()))
+		}
+		b.WriteString(fmt.Sprintf("   %-10s %s\n", "Trend", formatTrend(trendOf(s.Key, name))))
+	}
+	return b.String()
+}
+
+var statsWindows = make(map[string]fyne.Window) // Sensor key : open statistics window
+
+// sensorStatsHandler - Opens a window with the statistics of a sensor
+func sensorStatsHandler(key string) {
+	if w, ok := statsWindows[key]; ok {
+		w.Show()
+		return
+	}
+	activeSensorsMutex.Lock()
+	s, ok := activeSensors[key]
+	if !ok {
+		activeSensorsMutex.Unlock()
+		return
+	}
+	title := fmt.Sprintf("Statistics: %s (%s)", s.Name, key)
+	activeSensorsMutex.Unlock()
+
+	table := widget.NewLabel("")
+	table.TextStyle = fyne.TextStyle{Monospace: true}
+	fill := func() {
+		activeSensorsMutex.Lock()
+		if s, ok := activeSensors[key]; ok {
+			table.SetText(formatStats(s))
+		}
+		activeSensorsMutex.Unlock()
+	}
+	fill()
+	statsWindow := a.NewWindow(title)
+	statsWindows[key] = statsWindow
+	statsWindow.SetOnClosed(func() {
+		delete(statsWindows, key)
+	})
+	scroller := container.NewVScroll(table)
+	scroller.SetMinSize(fyne.NewSize(800, 400))
+	buttons := container.NewHBox(widget.NewButton("Refresh", fill))
+	if _, ok := latestHistory(key, "wind_avg_mi_h"); ok {
+		buttons.Add(widget.NewButton("Wind Rose", func() {
+			windRoseHandler(key)
+		}))
+	}
+	statsWindow.SetContent(container.NewBorder(nil, buttons, nil, nil, scroller))
+	statsWindow.Show()
+}
@@ -0,0 +1,379 @@
+/******************************************************************
+ *
+ * Store - Embedded time series store for the history of each
+ *      sensor's measurements. Each series (sensor key and
+ *      measurement) is a directory of day files, named YYYYMMDD.dat
+ *      by UTC date, of fixed size records:
+ *          8 bytes  time, Unix nanoseconds, big endian
+ *          8 bytes  value, IEEE 754 float64, big endian
+ *          4 bytes  CRC-32 (IEEE) of the 16 bytes before
+ *      Records are appended with one write each. A record torn by a
+ *      crash is cut off when the file is next opened, and records
+ *      that fail their CRC are skipped when read. Files written to
+ *      are synced every historySyncInterval and when the program
+ *      exits. Files not written since the sync before are closed,
+ *      so only series heard from lately hold a file open. The
+ *      latest day of each series is also kept in memory, which
+ *      serves the frequent short queries without reading files.
+ *      With no directory the store keeps only the memory copy, as
+ *      the tests do.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	storeRecordSize     = 20
+	storeDayFormat      = "20060102" // UTC day of a file
+	storeFileSuffix     = ".dat"
+	storeCacheWindow    = 24 * time.Hour
+	historySyncInterval = 10 * time.Second
+)
+
+// Time series store, see the top of this file
+type tsStore struct {
+	dir     string
+	mutex   sync.Mutex
+	series  map[string]*tsSeries // Series directory name : series
+	corrupt atomic.Int64         // Records skipped for a bad CRC
+	// Held while rollup files are written, which the rollup does without the store's lock
+	rollupMutex sync.Mutex
+}
+
+// One sensor measurement of the store
+type tsSeries struct {
+	dir       string    // Directory of the day files, "" without files
+	cacheFrom time.Time // Memory copy holds every sample from this time
+	cache     []sample  // Oldest first
+	file      *os.File  // Day file open for appending, nil if none
+	filePath  string    // Path of file
+	dirty     bool      // Written since the last sync
+	latest    sample    // Newest sample, if hasLatest
+	hasLatest bool      // False until a sample is written or found
+}
+
+// Minimum, maximum and average of the samples in one bucket of a query
+type historyBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+}
+
+// newTSStore - Store in directory dir, memory only if dir is ""
+func newTSStore(dir string) (*tsStore, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &tsStore{dir: dir, series: make(map[string]*tsSeries)}, nil
+}
+
+// storeEscape - Directory name for a sensor key or measurement, safe on every file system
+func storeEscape(name string) string {
+	var b strings.Builder
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.' && b.Len() > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// get - The series of a sensor measurement, loading its latest day on first use.
+// Caller holds the store's lock.
+func (st *tsStore) get(key string, name string) *tsSeries {
+	id := storeEscape(key) + "/" + storeEscape(name)
+	ts, ok := st.series[id]
+	if ok {
+		return ts
+	}
+	ts = new(tsSeries)
+	st.series[id] = ts
+	if st.dir == "" {
+		return ts
+	}
+	ts.dir = filepath.Join(st.dir, storeEscape(key), storeEscape(name))
+	ts.cacheFrom = time.Now().Add(-storeCacheWindow)
+	ts.cache, _ = st.readFiles(ts.dir, ts.cacheFrom, time.Now().Add(storeCacheWindow))
+	if n := len(ts.cache); n > 0 {
+		ts.latest, ts.hasLatest = ts.cache[n-1], true
+		return ts
+	}
+	// Nothing in the last day, the latest sample is at the end of the newest file
+	if days := periodFiles(ts.dir, storeDayFormat, time.Time{}, time.Now().Add(storeCacheWindow)); len(days) > 0 {
+		samples, _ := st.readFile(days[len(days)-1], time.Time{}, time.Now().Add(storeCacheWindow))
+		if n := len(samples); n > 0 {
+			ts.latest, ts.hasLatest = samples[n-1], true
+		}
+	}
+	return ts
+}
+
+// write - Add a sample of a sensor measurement
+func (st *tsStore) write(key string, name string, t time.Time, v float64) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	ts := st.get(key, name)
+	if !ts.hasLatest || !t.Before(ts.latest.t) {
+		ts.latest, ts.hasLatest = sample{t, v}, true
+	}
+	if !t.Before(ts.cacheFrom) {
+		ts.insert(sample{t, v})
+	}
+	if ts.dir == "" {
+		return nil
+	}
+	path := filepath.Join(ts.dir, t.UTC().Format(storeDayFormat)+storeFileSuffix)
+	if ts.file == nil || ts.filePath != path {
+		if ts.file != nil {
+			ts.file.Sync()
+			ts.file.Close()
+			ts.file = nil
+		}
+		f, err := openStoreFile(path, storeRecordSize)
+		if err != nil {
+			return err
+		}
+		ts.file, ts.filePath = f, path
+	}
+	_, err := ts.file.Write(encodeRecord(t, v))
+	ts.dirty = true
+	return err
+}
+
+// insert - Add a sample to the memory copy in time order and forget what is older than
+// the cache window before the newest sample
+func (ts *tsSeries) insert(s sample) {
+	n := len(ts.cache)
+	if n == 0 || !s.t.Before(ts.cache[n-1].t) {
+		ts.cache = append(ts.cache, s)
+	} else {
+		i := sort.Search(n, func(i int) bool { return ts.cache[i].t.After(s.t) })
+		ts.cache = append(ts.cache, sample{})
+		copy(ts.cache[i+1:], ts.cache[i:])
+		ts.cache[i] = s
+	}
+	if from := ts.cache[len(ts.cache)-1].t.Add(-storeCacheWindow); from.After(ts.cacheFrom) {
+		ts.cacheFrom = from
+		drop := sort.Search(len(ts.cache), func(i int) bool { return !ts.cache[i].t.Before(from) })
+		ts.cache = append([]sample(nil), ts.cache[drop:]...)
+	}
+}
+
+// read - Samples of a sensor measurement from time from up to time to, oldest first
+func (st *tsStore) read(key string, name string, from time.Time, to time.Time) []sample {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	ts := st.get(key, name)
+	if ts.dir == "" || !from.Before(ts.cacheFrom) {
+		var out []sample
+		for _, s := range ts.cache {
+			if !s.t.Before(from) && !s.t.After(to) {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	samples, _ := st.readFiles(ts.dir, from, to)
+	return samples
+}
+
+// latest - Newest sample of a sensor measurement, false if there is none
+func (st *tsStore) latest(key string, name string) (sample, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	ts := st.get(key, name)
+	return ts.latest, ts.hasLatest
+}
+
+// periodFiles - Paths of the files in dir, named by UTC period in layout, that may hold
+// samples from time from to time to, oldest first
+func periodFiles(dir string, layout string, from time.Time, to time.Time) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	first := from.UTC().Format(layout)
+	last := to.UTC().Format(layout)
+	var paths []string
+	for _, e := range entries {
+		day := strings.TrimSuffix(e.Name(), storeFileSuffix)
+		if e.IsDir() || day == e.Name() || day < first || day > last {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	return paths // ReadDir sorts by name, so by period
+}
+
+// readFiles - Samples of a series from time from up to time to, oldest first
+func (st *tsStore) readFiles(dir string, from time.Time, to time.Time) ([]sample, error) {
+	var out []sample
+	for _, path := range periodFiles(dir, storeDayFormat, from, to) {
+		samples, err := st.readFile(path, from, to)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, samples...)
+	}
+	return out, nil
+}
+
+// readFile - Samples of one day file from time from up to time to, oldest first
+func (st *tsStore) readFile(path string, from time.Time, to time.Time) ([]sample, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []sample
+	for i := 0; i+storeRecordSize <= len(data); i += storeRecordSize {
+		t, v, ok := decodeRecord(data[i : i+storeRecordSize])
+		if !ok {
+			st.corrupt.Add(1)
+			continue
+		}
+		if !t.Before(from) && !t.After(to) {
+			out = append(out, sample{t, v})
+		}
+	}
+	// Appends are in arrival order, which imports and replays can make out of time order
+	sort.SliceStable(out, func(i, j int) bool { return out[i].t.Before(out[j].t) })
+	return out, nil
+}
+
+// sync - Flush the files written since the last sync to disk, and close the others
+func (st *tsStore) sync() {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	for _, ts := range st.series {
+		if ts.file == nil {
+			continue
+		}
+		if ts.dirty {
+			ts.file.Sync()
+			ts.dirty = false
+		} else {
+			ts.file.Close()
+			ts.file = nil
+		}
+	}
+}
+
+// close - Sync and close the open files
+func (st *tsStore) close() {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	for _, ts := range st.series {
+		if ts.file != nil {
+			ts.file.Sync()
+			ts.file.Close()
+			ts.file = nil
+		}
+	}
+}
+
+// openStoreFile - Open a file of records of size size for appending, cutting off a record torn by a crash
+func openStoreFile(path string, size int64) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size()%size != 0 {
+		err = f.Truncate(info.Size() - info.Size()%size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// encodeRecord - Record of a sample
+func encodeRecord(t time.Time, v float64) []byte {
+	rec := make([]byte, storeRecordSize)
+	binary.BigEndian.PutUint64(rec[0:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(rec[8:16], math.Float64bits(v))
+	binary.BigEndian.PutUint32(rec[16:20], crc32.ChecksumIEEE(rec[0:16]))
+	return rec
+}
+
+// decodeRecord - Sample of a record, false if its CRC is wrong
+func decodeRecord(rec []byte) (time.Time, float64, bool) {
+	if crc32.ChecksumIEEE(rec[0:16]) != binary.BigEndian.Uint32(rec[16:20]) {
+		return time.Time{}, 0, false
+	}
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(rec[0:8])))
+	return t, math.Float64frombits(binary.BigEndian.Uint64(rec[8:16])), true
+}
+
+// aggregate - Count, minimum, maximum and average of samples in buckets of length bucket
+// starting at time from. Buckets without samples are left out.
+func aggregate(samples []sample, from time.Time, bucket time.Duration) []historyBucket {
+	if bucket <= 0 {
+		return nil
+	}
+	return mergeBuckets(sampleBuckets(samples), from, bucket)
+}
+
+// sampleBuckets - Each sample as a bucket of its own
+func sampleBuckets(samples []sample) []historyBucket {
+	out := make([]historyBucket, len(samples))
+	for i, s := range samples {
+		out[i] = historyBucket{Start: s.t, Count: 1, Min: s.v, Max: s.v, Avg: s.v}
+	}
+	return out
+}
+
+// mergeBuckets - Combine buckets, oldest first, into buckets of length bucket starting at time origin
+func mergeBuckets(in []historyBucket, origin time.Time, bucket time.Duration) []historyBucket {
+	var out []historyBucket
+	var sum float64
+	for _, b := range in {
+		d := b.Start.Sub(origin)
+		n := d / bucket
+		if d < 0 && d%bucket != 0 {
+			n-- // Round down before the origin too
+		}
+		start := origin.Add(n * bucket)
+		if n := len(out); n == 0 || !out[n-1].Start.Equal(start) {
+			if n > 0 {
+				out[n-1].Avg = sum / float64(out[n-1].Count)
+			}
+			out = append(out, historyBucket{Start: start, Min: b.Min, Max: b.Max})
+			sum = 0
+		}
+		m := &out[len(out)-1]
+		m.Count += b.Count
+		m.Min = math.Min(m.Min, b.Min)
+		m.Max = math.Max(m.Max, b.Max)
+		sum += b.Avg * float64(b.Count)
+	}
+	if n := len(out); n > 0 {
+		out[n-1].Avg = sum / float64(out[n-1].Count)
+	}
+	return out
+}
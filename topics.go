@@ -53,7 +53,7 @@ func displayTopics(t map[int]*Subscription) {
 
 // LIST TOPIC
 var listTopicsHandler = func() {
-	displayTopics(subscriptions)
+	displayTopics(copySubscriptions())
 	if !tflag {
 		topicWindow = a.NewWindow("Subscribed Topics")
 		// Get displayable list of subscribed topics
@@ -81,15 +81,7 @@ var addTopicHandler = func() {
 		inputT,
 		inputS,
 		widget.NewButton("Submit", func() {
-			SetStatus(fmt.Sprintf("Added Topic: %s, Station: %s", inputT.Text, inputS.Text))
-			// Add input text to topics[]
-			var m Subscription
-			m.Topic = inputT.Text
-			m.Station = inputS.Text
-			key := rand.Int()
-			subscriptions[key] = &m
-			Client.Subscribe(m.Topic, 0, messageHandler)
-			SetStatus(fmt.Sprintf("Subscribed to Topic: %s", m.Topic))
+			addSubscription(inputT.Text, inputS.Text)
 			addTopicWindow.Close()
 		}),
 	)
@@ -101,7 +93,7 @@ var addTopicHandler = func() {
 var removeTopicHandler = func() {
 	delTopicWindow := a.NewWindow("Delete Topic")
 	var choices []string
-	tlist := buildSubscriptionsList(subscriptions)
+	tlist := buildSubscriptionsList(copySubscriptions())
 	for _, m := range tlist {
 		choices = append(choices, m.Display)
 	}
@@ -118,12 +110,7 @@ var removeTopicHandler = func() {
 				check(err)
 				k := int(j) // k is index into the tlist array of []ChoicesIntKey where .Key is the Message key
 				// Verify message is in map before rying to delete
-				if checkMessage(tlist[k].Key, subscriptions) {
-					// Delete using key
-					key := tlist[k].Key
-					unsubscribe(Client, subscriptions[key])
-					delete(subscriptions, key)
-				}
+				removeSubscription(tlist[k].Key)
 			}
 			delTopicWindow.Close()
 		}),
@@ -132,6 +119,53 @@ var removeTopicHandler = func() {
 	delTopicWindow.Show()
 }
 
+// addSubscription - Subscribe to a topic of a station, now if connected and on every connect
+func addSubscription(topic string, station string) int {
+	SetStatus(fmt.Sprintf("Added Topic: %s, Station: %s", topic, station))
+	// Add input text to topics[]
+	var m Subscription
+	m.Topic = topic
+	m.Station = station
+	key := rand.Int()
+	m.Key = key
+	subscriptionsMutex.Lock()
+	subscriptions[key] = &m
+	subscriptionsMutex.Unlock()
+	if Client != nil && Client.IsConnected() {
+		Client.Subscribe(m.Topic, 0, messageHandler)
+		SetStatus(fmt.Sprintf("Subscribed to Topic: %s", m.Topic))
+	}
+	return key
+}
+
+// removeSubscription - Unsubscribe from a topic and forget it
+func removeSubscription(key int) error {
+	subscriptionsMutex.Lock()
+	// Verify message is in map before rying to delete
+	m, ok := subscriptions[key]
+	delete(subscriptions, key)
+	subscriptionsMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no subscription %d", key)
+	}
+	if Client != nil && Client.IsConnected() {
+		unsubscribe(Client, m)
+	}
+	return nil
+}
+
+// copySubscriptions - A copy of the subscriptions, to read without holding the lock
+func copySubscriptions() map[int]*Subscription {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	c := make(map[int]*Subscription, len(subscriptions))
+	for key, m := range subscriptions {
+		s := *m
+		c[key] = &s
+	}
+	return c
+}
+
 // Create subscription (topic) list
 func buildSubscriptionsList(m map[int]*Subscription) []ChoicesIntKey {
 	var list []ChoicesIntKey
@@ -0,0 +1,137 @@
+/******************************************************************
+ *
+ * Trends - Rising, falling or steady indicator and rate of change
+ *      per hour for each measurement of a sensor, from the readings
+ *      received within the trend window (TrendWindowMins setting).
+ *      A rate smaller than the measurement's steady threshold counts
+ *      as steady.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+)
+
+const (
+	trendFalling = -1
+	trendSteady  = 0
+	trendRising  = 1
+)
+
+// Per hour rates below which a measurement is steady, unless overridden by the TrendSteady setting
+var defaultTrendSteady = map[string]float64{
+	"temperature_F":     0.5,
+	"humidity":          2,
+	"wind_avg_mi_h":     2,
+	"wind_max_mi_h":     2,
+	"wind_avg_10m_mi_h": 2,
+	"dew_point_F":       0.5,
+	"heat_index_F":      0.5,
+	"wind_chill_F":      0.5,
+	"feels_like_F":      0.5,
+	"pressure_hPa":      0.5,
+	"sea_level_hPa":     0.5,
+}
+
+// Trend of a measurement over the trend window
+type trend struct {
+	Rate      float64 // Units per hour
+	Direction int     // trendFalling, trendSteady or trendRising
+	Ok        bool    // False until the readings span enough of the window
+}
+
+var (
+	trendSamples = make(map[string]map[string][]sample) // Sensor key : measurement : samples, oldest first
+	trendMutex   sync.Mutex
+)
+
+// trendWindow - Time covered by the trend, with a sane default
+func trendWindow() time.Duration {
+	if settings.TrendWindowMins <= 0 {
+		return time.Hour
+	}
+	return time.Duration(settings.TrendWindowMins * float64(time.Minute))
+}
+
+// trendSteadyRate - Rate per hour below which a measurement is steady
+func trendSteadyRate(name string) float64 {
+	if r, ok := settings.TrendSteady[name]; ok {
+		return r
+	}
+	return defaultTrendSteady[name]
+}
+
+// addTrendSamples - Remember the measurements of a reading that arrived at time t,
+// forgetting those older than the trend window
+func addTrendSamples(key string, wd WeatherData, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	cutoff := t.Add(-trendWindow())
+	trendMutex.Lock()
+	defer trendMutex.Unlock()
+	series, ok := trendSamples[key]
+	if !ok {
+		series = make(map[string][]sample)
+		trendSamples[key] = series
+	}
+	for name, v := range wd.Measurements() {
+		samples := append(series[name], sample{t, v})
+		drop := 0
+		for drop < len(samples) && samples[drop].t.Before(cutoff) {
+			drop++
+		}
+		series[name] = samples[drop:]
+	}
+}
+
+// trendOf - Trend of a sensor's measurement. Not Ok until the samples span a
+// quarter of the trend window.
+func trendOf(key string, name string) trend {
+	trendMutex.Lock()
+	samples := trendSamples[key][name]
+	trendMutex.Unlock()
+	if len(samples) < 2 || samples[len(samples)-1].t.Sub(samples[0].t) < trendWindow()/4 {
+		return trend{}
+	}
+	tr := trend{Rate: ratePerHour(samples), Ok: true}
+	switch steady := trendSteadyRate(name); {
+	case tr.Rate >= steady && tr.Rate > 0:
+		tr.Direction = trendRising
+	case tr.Rate <= -steady && tr.Rate < 0:
+		tr.Direction = trendFalling
+	}
+	return tr
+}
+
+// formatTrend - Rate of change for display, e.g. "rising 1.2/h"
+func formatTrend(tr trend) string {
+	if !tr.Ok {
+		return "no trend yet"
+	}
+	switch tr.Direction {
+	case trendRising:
+		return fmt.Sprintf("rising %.2f/h", tr.Rate)
+	case trendFalling:
+		return fmt.Sprintf("falling %.2f/h", -tr.Rate)
+	}
+	return fmt.Sprintf("steady %+.2f/h", tr.Rate)
+}
+
+// trendIcon - Arrow for the widget
+func trendIcon(tr trend) fyne.Resource {
+	switch tr.Direction {
+	case trendRising:
+		return theme.MoveUpIcon()
+	case trendFalling:
+		return theme.MoveDownIcon()
+	}
+	return theme.NavigateNextIcon()
+}
@@ -0,0 +1,635 @@
+/******************************************************************
+ *
+ * Virtual sensors - Sensors computed from the latest readings of
+ *      other active sensors, such as the average of the upstairs
+ *      rooms or the outdoor minus indoor temperature. A virtual
+ *      sensor is an active sensor like any other: it has a widget,
+ *      statistics and a data log. It is computed again whenever one
+ *      of its inputs reports, and its reading goes through
+ *      handleReading.
+ *
+ *      Expressions use numbers, + - * / and parentheses, references
+ *      to sensors and the functions avg, min, max, sum and count.
+ *      A reference is a measurement name with a selector, e.g.
+ *          temperature_F("name:Upstairs*")
+ *          temperature_F("Outdoor") - temperature_F("Basement")
+ *          max(temperature_F("location:Freezer*"))
+ *      The selector is a sensor key or name, or one of key:, name:,
+ *      location: or station: followed by a pattern with * and ?.
+ *      A reference gives one value for each matching sensor that is
+ *      online, and arithmetic needs exactly one, so references that
+ *      match several sensors are combined with a function. Virtual
+ *      sensors are not inputs of other virtual sensors.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const virtualModel = "Virtual" // Model of virtual sensors, their key is station:Virtual:0:name
+
+// Sensor referenced by an expression
+type virtualRef struct {
+	measurement string
+	selector    string
+}
+
+// Compiled expression of a virtual sensor
+type virtualProgram struct {
+	eval virtualExpr
+	refs []virtualRef
+}
+
+// Evaluates part of an expression to a list of values, resolving references with resolve
+type virtualExpr func(resolve virtualResolver) ([]float64, error)
+
+// Values of a measurement from the sensors matching a selector
+type virtualResolver func(ref virtualRef) []float64
+
+var (
+	virtualPrograms       = make(map[string]*virtualProgram) // Expression : compiled program
+	virtualProgramsMutex  sync.Mutex
+	virtualSensorsFlag    bool = false // Virtual sensors window flag. If true, window is open.
+	virtualAggregateNames      = map[string]func([]float64) float64{
+		"avg":   func(v []float64) float64 { return sumOf(v) / float64(len(v)) },
+		"min":   minOf,
+		"max":   maxOf,
+		"sum":   sumOf,
+		"count": func(v []float64) float64 { return float64(len(v)) },
+	}
+)
+
+func sumOf(v []float64) float64 {
+	total := 0.0
+	for _, x := range v {
+		total += x
+	}
+	return total
+}
+
+func minOf(v []float64) float64 {
+	min := math.Inf(1)
+	for _, x := range v {
+		min = math.Min(min, x)
+	}
+	return min
+}
+
+func maxOf(v []float64) float64 {
+	max := math.Inf(-1)
+	for _, x := range v {
+		max = math.Max(max, x)
+	}
+	return max
+}
+
+/******************************************
+ * Expression parser
+ ******************************************/
+
+// Parser state: the tokens of an expression and the references found so far
+type virtualParser struct {
+	tokens []string
+	pos    int
+	refs   []virtualRef
+}
+
+// tokenizeVirtual - Split an expression into numbers, names, quoted strings and operators
+func tokenizeVirtual(src string) ([]string, error) {
+	var tokens []string
+	r := []rune(src)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/(),", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(r) && r[j] != '"' {
+				j++
+			}
+			if j == len(r) {
+				return nil, errors.New("missing closing quote")
+			}
+			tokens = append(tokens, string(r[i:j+1]))
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(r[i:j]))
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, string(r[i:j]))
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// parseVirtual - Compile the expression of a virtual sensor
+func parseVirtual(src string) (*virtualProgram, error) {
+	tokens, err := tokenizeVirtual(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("expression is empty")
+	}
+	p := &virtualParser{tokens: tokens}
+	eval, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	if len(p.refs) == 0 {
+		return nil, errors.New("expression does not refer to any sensor")
+	}
+	return &virtualProgram{eval: eval, refs: p.refs}, nil
+}
+
+// peek - The next token, "" at the end
+func (p *virtualParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expect - Consume the next token, which must be tok
+func (p *virtualParser) expect(tok string) error {
+	if p.peek() != tok {
+		if p.peek() == "" {
+			return fmt.Errorf("expected %s at the end", tok)
+		}
+		return fmt.Errorf("expected %s, found %s", tok, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// expr - Sums and differences of terms
+func (p *virtualParser) expr() (virtualExpr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "+" || op == "-"; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = virtualArithmetic(op, left, right)
+	}
+	return left, nil
+}
+
+// term - Products and quotients of unary expressions
+func (p *virtualParser) term() (virtualExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "*" || op == "/"; op = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = virtualArithmetic(op, left, right)
+	}
+	return left, nil
+}
+
+// unary - A primary, possibly negated
+func (p *virtualParser) unary() (virtualExpr, error) {
+	if p.peek() != "-" {
+		return p.primary()
+	}
+	p.pos++
+	operand, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return virtualArithmetic("-", func(virtualResolver) ([]float64, error) { return []float64{0}, nil }, operand), nil
+}
+
+// primary - A number, a parenthesized expression, a function or a sensor reference
+func (p *virtualParser) primary() (virtualExpr, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, errors.New("expression ends too soon")
+	case tok == "(":
+		p.pos++
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		p.pos++
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s", tok)
+		}
+		return func(virtualResolver) ([]float64, error) { return []float64{v}, nil }, nil
+	case !unicode.IsLetter(rune(tok[0])) && tok[0] != '_':
+		return nil, fmt.Errorf("unexpected %s", tok)
+	}
+	name := tok
+	p.pos++
+	if err := p.expect("("); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if aggregate, ok := virtualAggregateNames[name]; ok {
+		var args []virtualExpr
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(resolve virtualResolver) ([]float64, error) {
+			var values []float64
+			for _, arg := range args {
+				v, err := arg(resolve)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v...)
+			}
+			if len(values) == 0 && name != "count" {
+				return nil, fmt.Errorf("%s of no values", name)
+			}
+			return []float64{aggregate(values)}, nil
+		}, nil
+	}
+	var wd WeatherData
+	if wd.measurementField(name) == nil {
+		return nil, fmt.Errorf("unknown function or measurement %s", name)
+	}
+	selector := p.peek()
+	if len(selector) < 2 || selector[0] != '"' {
+		return nil, fmt.Errorf("%s needs a quoted sensor selector", name)
+	}
+	p.pos++
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	ref := virtualRef{measurement: name, selector: selector[1 : len(selector)-1]}
+	p.refs = append(p.refs, ref)
+	return func(resolve virtualResolver) ([]float64, error) {
+		return resolve(ref), nil
+	}, nil
+}
+
+// virtualArithmetic - Combine two single values with an operator
+func virtualArithmetic(op string, left virtualExpr, right virtualExpr) virtualExpr {
+	single := func(e virtualExpr, resolve virtualResolver) (float64, error) {
+		v, err := e(resolve)
+		if err != nil {
+			return 0, err
+		}
+		switch len(v) {
+		case 0:
+			return 0, errors.New("a sensor reference matched no sensor with a reading")
+		case 1:
+			return v[0], nil
+		}
+		return 0, fmt.Errorf("a sensor reference matched %d sensors, combine them with avg, min, max or sum", len(v))
+	}
+	return func(resolve virtualResolver) ([]float64, error) {
+		a, err := single(left, resolve)
+		if err != nil {
+			return nil, err
+		}
+		b, err := single(right, resolve)
+		if err != nil {
+			return nil, err
+		}
+		var v float64
+		switch op {
+		case "+":
+			v = a + b
+		case "-":
+			v = a - b
+		case "*":
+			v = a * b
+		case "/":
+			if b == 0 {
+				return nil, errors.New("division by zero")
+			}
+			v = a / b
+		}
+		return []float64{v}, nil
+	}
+}
+
+// evaluate - Value of the expression, which must be a single number
+func (vp *virtualProgram) evaluate(resolve virtualResolver) (float64, error) {
+	v, err := vp.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 1 {
+		return 0, fmt.Errorf("expression gives %d values, combine them with avg, min, max or sum", len(v))
+	}
+	return v[0], nil
+}
+
+/******************************************
+ * Evaluation
+ ******************************************/
+
+// compileVirtual - The compiled program of an expression, parsed once
+func compileVirtual(src string) (*virtualProgram, error) {
+	virtualProgramsMutex.Lock()
+	defer virtualProgramsMutex.Unlock()
+	if vp, ok := virtualPrograms[src]; ok {
+		return vp, nil
+	}
+	vp, err := parseVirtual(src)
+	if err != nil {
+		return nil, err
+	}
+	virtualPrograms[src] = vp
+	return vp, nil
+}
+
+// virtualSelects - Whether a selector picks sensor s
+func virtualSelects(selector string, s *Sensor) bool {
+	if s.Virtual {
+		return false
+	}
+	match := func(pattern, value string) bool {
+		ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+		return ok
+	}
+	if field, pattern, ok := strings.Cut(selector, ":"); ok {
+		switch field {
+		case "key":
+			return match(pattern, s.Key)
+		case "name":
+			return match(pattern, s.Name)
+		case "location":
+			return match(pattern, s.Location)
+		case "station":
+			return match(pattern, s.Station)
+		}
+	}
+	return s.Key == selector || strings.EqualFold(s.Name, selector)
+}
+
+// resolveVirtual - Latest values from the online active sensors a reference selects.
+// Takes the lock on the active sensors.
+func resolveVirtual(ref virtualRef) []float64 {
+	var keys []string
+	activeSensorsMutex.Lock()
+	for _, k := range sortActiveSensors() {
+		if s := activeSensors[k]; !s.Offline && virtualSelects(ref.selector, s) {
+			keys = append(keys, k)
+		}
+	}
+	activeSensorsMutex.Unlock()
+	var values []float64
+	for _, k := range keys {
+		if v, ok := latestHistory(k, ref.measurement); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// usesSensor - Whether any reference of the program selects sensor s
+func (vp *virtualProgram) usesSensor(s *Sensor) bool {
+	for _, ref := range vp.refs {
+		if virtualSelects(ref.selector, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// virtualReading - Reading of a virtual sensor with value v, arriving at time t
+func virtualReading(s Sensor, v float64, date string, t time.Time) WeatherData {
+	station, _, _ := strings.Cut(s.Key, ":")
+	wd := WeatherData{Time: date, Model: s.Model, Id: s.Id, Channel: s.Channel, Station: station, Received: t}
+	wd.SetMeasurement(s.Measurement, v)
+	return wd
+}
+
+// updateVirtualSensors - Compute the virtual sensors that use the sensor with key, after its reading
+// dated date arrived at time t, and pass their readings to handleReading
+func updateVirtualSensors(key string, date string, t time.Time) {
+	var due []Sensor
+	activeSensorsMutex.Lock()
+	source, ok := activeSensors[key]
+	if ok && !source.Virtual {
+		for _, s := range activeSensors {
+			if !s.Virtual {
+				continue
+			}
+			if vp, err := compileVirtual(s.Expression); err == nil && vp.usesSensor(source) {
+				due = append(due, *s)
+			}
+		}
+	}
+	activeSensorsMutex.Unlock()
+	for _, s := range due {
+		vp, _ := compileVirtual(s.Expression)
+		v, err := vp.evaluate(resolveVirtual)
+		if err != nil {
+			continue // Inputs without readings yet, or offline
+		}
+		handleReading(virtualReading(s, v, date, t))
+	}
+}
+
+// newVirtualSensor - Sensor record of a virtual sensor
+func newVirtualSensor(station string, name string, measurement string, expression string) Sensor {
+	st := time.Now().Local().Format(YYYYMMDD + " " + HHMMSS24h)
+	wd := WeatherData{Station: station, Model: virtualModel, Channel: name}
+	return Sensor{
+		Key:         wd.BuildSensorKey(),
+		Model:       virtualModel,
+		Channel:     name,
+		Station:     station,
+		Name:        name,
+		Location:    "Virtual",
+		DateAdded:   st,
+		LastEdit:    st,
+		HasHumidity: measurement == "humidity",
+		Virtual:     true,
+		Expression:  expression,
+		Measurement: measurement,
+	}
+}
+
+// virtualSensorKeys - Keys of the active virtual sensors, sorted
+func virtualSensorKeys() []string {
+	activeSensorsMutex.Lock()
+	defer activeSensorsMutex.Unlock()
+	var keys []string
+	for _, k := range sortActiveSensors() {
+		if activeSensors[k].Virtual {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// virtualSensorsHandler - Opens a window to define and remove virtual sensors
+var virtualSensorsHandler = func() {
+	if virtualSensorsFlag {
+		return
+	}
+	virtualSensorsFlag = true
+	list := widget.NewLabel("")
+	list.TextStyle = fyne.TextStyle{Monospace: true}
+	removeSelect := widget.NewSelect(nil, nil)
+	removeSelect.PlaceHolder = "Select a virtual sensor to remove"
+	fill := func() {
+		keys := virtualSensorKeys()
+		var b strings.Builder
+		activeSensorsMutex.Lock()
+		for _, k := range keys {
+			s := activeSensors[k]
+			b.WriteString(fmt.Sprintf("%s = %s   (%s)\n", s.Name, s.Expression, s.Measurement))
+		}
+		activeSensorsMutex.Unlock()
+		if len(keys) == 0 {
+			b.WriteString("No virtual sensors")
+		}
+		list.SetText(b.String())
+		removeSelect.Options = keys
+		removeSelect.ClearSelected()
+	}
+	fill()
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Name, e.g. Upstairs")
+	stationEntry := widget.NewSelectEntry(stationNames())
+	stationEntry.SetPlaceHolder("Station")
+	measurementSelect := widget.NewSelect(measurementNames, nil)
+	measurementSelect.SetSelected("temperature_F")
+	expressionEntry := widget.NewEntry()
+	expressionEntry.SetPlaceHolder(`avg(temperature_F("location:Upstairs*"))`)
+
+	check := func() (*virtualProgram, bool) {
+		vp, err := parseVirtual(strings.TrimSpace(expressionEntry.Text))
+		if err != nil {
+			SetStatus(fmt.Sprintf("Expression not valid: %s", err))
+			return nil, false
+		}
+		return vp, true
+	}
+	virtualWindow := a.NewWindow("Virtual Sensors")
+	virtualWindow.SetOnClosed(func() {
+		virtualSensorsFlag = false
+	})
+	tryButton := widget.NewButton("Try", func() {
+		vp, ok := check()
+		if !ok {
+			return
+		}
+		v, err := vp.evaluate(resolveVirtual)
+		if err != nil {
+			SetStatus(fmt.Sprintf("Expression is valid but can't be computed now: %s", err))
+			return
+		}
+		SetStatus(fmt.Sprintf("Expression gives %.2f", v))
+	})
+	addButton := widget.NewButton("Add", func() {
+		name := strings.TrimSpace(nameEntry.Text)
+		station := strings.TrimSpace(stationEntry.Text)
+		if name == "" || station == "" || strings.Contains(name, ":") || strings.Contains(station, ":") {
+			SetStatus("Virtual sensor needs a name and a station, without colons")
+			return
+		}
+		if _, ok := check(); !ok {
+			return
+		}
+		s := newVirtualSensor(station, name, measurementSelect.Selected, strings.TrimSpace(expressionEntry.Text))
+		if checkSensor(s.Key, activeSensors) {
+			SetStatus(fmt.Sprintf("Sensor %s already exists", s.Key))
+			return
+		}
+		activeSensorsMutex.Lock()
+		activeSensors[s.Key] = &s
+		activeSensorsMutex.Unlock()
+		reloadDashboard()
+		fill()
+		SetStatus(fmt.Sprintf("Added virtual sensor %s, it reports when one of its inputs does", s.Key))
+	})
+	removeButton := widget.NewButton("Remove", func() {
+		key := removeSelect.Selected
+		if key == "" {
+			return
+		}
+		activeSensorsMutex.Lock()
+		delete(activeSensors, key)
+		activeSensorsMutex.Unlock()
+		reloadDashboard()
+		fill()
+		SetStatus(fmt.Sprintf("Removed virtual sensor %s", key))
+	})
+	help := widget.NewLabel("Functions avg, min, max, sum, count. Sensors: measurement(\"selector\"), where the selector\n" +
+		"is a key or name, or key:, name:, location: or station: with a pattern, e.g. temperature_F(\"name:Freezer*\")")
+	form := container.NewVBox(
+		list,
+		container.NewBorder(nil, nil, nil, removeButton, removeSelect),
+		widget.NewSeparator(),
+		widget.NewLabel("Name"),
+		nameEntry,
+		widget.NewLabel("Station"),
+		stationEntry,
+		widget.NewLabel("Measurement"),
+		measurementSelect,
+		widget.NewLabel("Expression"),
+		expressionEntry,
+		help,
+		container.NewHBox(tryButton, addButton, widget.NewButton("Close", func() {
+			virtualWindow.Close()
+		})),
+	)
+	virtualWindow.SetContent(container.NewVScroll(form))
+	virtualWindow.Resize(fyne.NewSize(650, 600))
+	virtualWindow.Show()
+}
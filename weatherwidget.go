@@ -22,6 +22,7 @@ import (
 
 var (
 	widgetBackgroundColor = color.RGBA{R: 214, G: 240, B: 246, A: 255}
+	widgetStaleColor      = color.RGBA{R: 190, G: 190, B: 190, A: 255} // Sensor has gone silent
 	widgetFrameColor      = color.Black
 )
 
@@ -105,6 +106,38 @@ func newWeatherWidgetRenderer(ww *weatherWidget) fyne.WidgetRenderer {
 	latestUpdate := canvas.NewText("Updated:   "+ww.latestUpdate, color.Black)
 	latestUpdate.TextSize = 12
 
+	battery := canvas.NewText("LOW BATTERY", color.RGBA{R: 247, G: 19, B: 2, A: 255})
+	battery.TextSize = 10
+	battery.TextStyle = fyne.TextStyle{Bold: true}
+
+	stale := canvas.NewText("OFFLINE", color.RGBA{R: 247, G: 19, B: 2, A: 255})
+	stale.TextSize = 10
+	stale.TextStyle = fyne.TextStyle{Bold: true}
+
+	dp := canvas.NewText("Dew "+strconv.FormatFloat(ww.dewPoint, 'f', 1, 64), color.Black)
+	dp.TextSize = 10
+
+	fl := canvas.NewText("Feels "+strconv.FormatFloat(ww.feelsLike, 'f', 1, 64), color.Black)
+	fl.TextSize = 10
+
+	ta := canvas.NewImageFromResource(trendIcon(ww.tempTrend))
+	ta.FillMode = canvas.ImageFillContain
+	ta.Resize(fyne.NewSize(trendIconSize, trendIconSize))
+	tr := canvas.NewText(formatRate(ww.tempTrend), color.Black)
+	tr.TextSize = 10
+
+	ha := canvas.NewImageFromResource(trendIcon(ww.humidityTrend))
+	ha.FillMode = canvas.ImageFillContain
+	ha.Resize(fyne.NewSize(trendIconSize, trendIconSize))
+	hr := canvas.NewText(formatRate(ww.humidityTrend), color.Black)
+	hr.TextSize = 10
+
+	rain := canvas.NewText(formatRainLine(ww.rainDay, ww.rain24h, ww.rainRate), color.RGBA{R: 11, G: 11, B: 243, A: 255})
+	rain.TextSize = 9
+
+	wind := canvas.NewText(formatWindLine(ww.windSpeed, ww.windDir, ww.hasWindDir, ww.windGust), color.Black)
+	wind.TextSize = 9
+
 	r.widget = ww
 	r.frame = frame
 	r.sensorName = header
@@ -117,7 +150,18 @@ func newWeatherWidgetRenderer(ww *weatherWidget) fyne.WidgetRenderer {
 	r.highHumidity = hhw
 	r.lowHumidity = lhw
 	r.latestUpdate = latestUpdate
-	r.objects = append(r.objects, frame, header, st, tw, tw2, hw, hw2, htw, ltw, hhw, lhw, latestUpdate)
+	r.battery = battery
+	r.stale = stale
+	r.dewPoint = dp
+	r.feelsLike = fl
+	r.tempArrow = ta
+	r.tempRate = tr
+	r.humArrow = ha
+	r.humRate = hr
+	r.rain = rain
+	r.wind = wind
+	r.tempCaption = tw2
+	r.objects = append(r.objects, frame, header, st, tw, tw2, hw, hw2, htw, ltw, hhw, lhw, latestUpdate, battery, stale, dp, fl, ta, tr, ha, hr, rain, wind)
 
 	r.widget.ExtendBaseWidget(ww)
 
@@ -148,6 +192,23 @@ func (r *weatherWidgetRenderer) Layout(size fyne.Size) {
 	r.lowHumidity.Move(fyne.NewPos(4, 100))
 	xpos = ((widgetSizeX / 2) - (r.latestUpdate.MinSize().Width)/2)
 	r.latestUpdate.Move(fyne.NewPos(xpos, 130))
+	xpos = widgetSizeX - widgetPadding - r.battery.MinSize().Width
+	r.battery.Move(fyne.NewPos(xpos, 100))
+	if !r.widget.batteryLow {
+		r.battery.Hide()
+	}
+	r.stale.Move(fyne.NewPos(4, 115))
+	r.dewPoint.Move(fyne.NewPos(widgetSizeX-widgetPadding-r.dewPoint.MinSize().Width, 40))
+	r.feelsLike.Move(fyne.NewPos(widgetSizeX-widgetPadding-r.feelsLike.MinSize().Width, 52))
+	if !r.widget.hasHumidity {
+		r.dewPoint.Hide()
+	}
+	if !r.widget.stale {
+		r.stale.Hide()
+	}
+	r.layoutTrends()
+	r.layoutRain()
+	r.layoutWind()
 	if !r.widget.hasHumidity {
 		r.humidity.Hide()
 		r.highHumidity.Hide()
@@ -166,6 +227,13 @@ func (r *weatherWidgetRenderer) Objects() []fyne.CanvasObject {
 
 func (r *weatherWidgetRenderer) Refresh() {
 	r.frame.Resize(fyne.NewSize(widgetSizeX, widgetSizeY)) // This is critical or frame won't appear
+	if r.widget.stale {
+		r.frame.FillColor = widgetStaleColor
+		r.stale.Show()
+	} else {
+		r.frame.FillColor = widgetBackgroundColor
+		r.stale.Hide()
+	}
 	r.frame.Show()
 	r.sensorName.Text = r.widget.sensorName
 	r.station.Text = r.widget.sensorStation
@@ -176,17 +244,85 @@ func (r *weatherWidgetRenderer) Refresh() {
 	r.highHumidity.Text = "Hi " + strconv.FormatFloat(r.widget.highHumidity, 'f', 1, 64) + "%"
 	r.lowHumidity.Text = "Lo " + strconv.FormatFloat(r.widget.lowHumidity, 'f', 1, 64) + "%"
 	r.latestUpdate.Text = "Updated:   " + r.widget.latestUpdate
+	r.dewPoint.Text = "Dew " + strconv.FormatFloat(r.widget.dewPoint, 'f', 1, 64)
+	r.feelsLike.Text = "Feels " + strconv.FormatFloat(r.widget.feelsLike, 'f', 1, 64)
+	r.dewPoint.Move(fyne.NewPos(widgetSizeX-widgetPadding-r.dewPoint.MinSize().Width, 40))
+	r.feelsLike.Move(fyne.NewPos(widgetSizeX-widgetPadding-r.feelsLike.MinSize().Width, 52))
+	r.tempRate.Text = formatRate(r.widget.tempTrend)
+	r.tempArrow.Resource = trendIcon(r.widget.tempTrend)
+	r.humRate.Text = formatRate(r.widget.humidityTrend)
+	r.humArrow.Resource = trendIcon(r.widget.humidityTrend)
+	r.layoutTrends()
+	r.tempArrow.Refresh()
+	r.humArrow.Refresh()
+	r.rain.Text = formatRainLine(r.widget.rainDay, r.widget.rain24h, r.widget.rainRate)
+	r.wind.Text = formatWindLine(r.widget.windSpeed, r.widget.windDir, r.widget.hasWindDir, r.widget.windGust)
+	if r.widget.batteryLow {
+		r.battery.Show()
+	} else {
+		r.battery.Hide()
+	}
 	if !r.widget.hasHumidity {
 		r.lowHumidity.Hide()
 		r.highHumidity.Hide()
 		r.humidity.Hide()
 		r.humidity2.Hide()
+		r.dewPoint.Hide()
 	} else {
 		r.lowHumidity.Show()
 		r.highHumidity.Show()
 		r.humidity.Show()
 		r.humidity2.Show()
+		r.dewPoint.Show()
 	}
+	r.layoutRain()
+	r.layoutWind()
+}
+
+// layoutTrends - Place the trend arrows and rates at the right edge, beside the value they belong to
+func (r *weatherWidgetRenderer) layoutTrends() {
+	place := func(arrow *canvas.Image, rate *canvas.Text, tr trend, y float32, show bool) {
+		xpos := widgetSizeX - widgetPadding - rate.MinSize().Width
+		rate.Move(fyne.NewPos(xpos, y))
+		arrow.Move(fyne.NewPos(xpos-trendIconSize-2, y+(rate.MinSize().Height-trendIconSize)/2))
+		if show && tr.Ok {
+			arrow.Show()
+			rate.Show()
+		} else {
+			arrow.Hide()
+			rate.Hide()
+		}
+	}
+	place(r.tempArrow, r.tempRate, r.widget.tempTrend, 26, true)
+	place(r.humArrow, r.humRate, r.widget.humidityTrend, 85, r.widget.hasHumidity)
+}
+
+// layoutRain - Rain totals of a rain gauge take the place of the humidity caption
+func (r *weatherWidgetRenderer) layoutRain() {
+	if !r.widget.hasRain {
+		r.rain.Hide()
+		return
+	}
+	r.rain.Move(fyne.NewPos((widgetSizeX/2)-(r.rain.MinSize().Width)/2, 114))
+	r.rain.Show()
+	r.humidity2.Hide()
+}
+
+// layoutWind - Wind of an anemometer takes the place of the temperature caption
+func (r *weatherWidgetRenderer) layoutWind() {
+	if !r.widget.hasWind {
+		r.wind.Hide()
+		r.tempCaption.Show()
+		return
+	}
+	r.wind.Move(fyne.NewPos((widgetSizeX/2)-(r.wind.MinSize().Width)/2, 71))
+	r.wind.Show()
+	r.tempCaption.Hide()
+}
+
+// formatRate - Signed rate of change per hour for the widget
+func formatRate(tr trend) string {
+	return strconv.FormatFloat(tr.Rate, 'f', 1, 64) + "/h"
 }
 
 /************************************
@@ -259,10 +395,21 @@ func (ww *weatherWidget) Init(s *Sensor) {
 	ww.hasHumidity = s.HasHumidity
 	ww.highHumidity = s.HighHumidity
 	ww.lowHumidity = s.LowHumidity
-	ww.highTemp = activeSensors[s.Key].HighTemp
 	ww.highTemp = s.HighTemp
 	ww.lowTemp = s.LowTemp
 	ww.latestUpdate = s.DataDate
+	ww.batteryLow = s.BatteryLow()
+	ww.stale = s.Offline
+	if speed, ok := latestHistory(s.Key, "wind_avg_mi_h"); ok {
+		ww.hasWind = true
+		ww.windSpeed = speed
+		ww.windDir, ww.hasWindDir = latestHistory(s.Key, "wind_dir_deg")
+		ww.windGust = windGustToday(s)
+	}
+	if s.Rain != nil {
+		ww.hasRain = true
+		ww.rainDay, ww.rain24h, ww.rainRate = s.Rain.Day, s.Rain.last24h(), s.Rain.Rate
+	}
 	wwc := make(chan string, 5) // Buffered channel for this sensor
 	ww.channel = wwc
 	ww.goHandler = wwHandler
@@ -276,7 +423,10 @@ func (ww *weatherWidget) Tapped(*fyne.PointEvent) {
 	editSpecificSensorHandler(ww.sensorKey)
 }
 
-func (mc *weatherWidget) TappedSecondary(*fyne.PointEvent) {}
+// TappedSecondary - Show the sensor's statistics
+func (ww *weatherWidget) TappedSecondary(*fyne.PointEvent) {
+	sensorStatsHandler(ww.sensorKey)
+}
 
 // sortWeatherWidgets - returns array of keys for the sorted widgets
 func sortWeatherWidgets() (sortedWWKeys []string) {
@@ -0,0 +1,347 @@
+/******************************************************************
+ *
+ * Wind - Speed, gust and direction of anemometers. Speeds are kept
+ *      in miles per hour whatever unit the sensor reports. Each
+ *      reading gets the 10 minute average speed and direction, the
+ *      statistics give the peak gust of the day, and the wind rose
+ *      window charts direction against speed from the sensor's
+ *      history.
+ *
+ ******************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	windAverageWindow = 10 * time.Minute
+	mphPerKmh         = 0.621371
+	mphPerMs          = 2.23694
+	windRoseSectors   = 16
+	windSectorDeg     = 360.0 / windRoseSectors
+	windCalmMph       = 1 // Slower than this is calm, with no direction
+)
+
+var compassPoints = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// Upper limit of each wind rose speed band in mph, and its colour
+var (
+	windRoseBands  = []float64{5, 10, 15, 20, 30, math.Inf(1)}
+	windRoseColors = []color.RGBA{
+		{R: 158, G: 202, B: 225, A: 255},
+		{R: 107, G: 174, B: 214, A: 255},
+		{R: 66, G: 146, B: 198, A: 255},
+		{R: 33, G: 113, B: 181, A: 255},
+		{R: 8, G: 81, B: 156, A: 255},
+		{R: 247, G: 19, B: 2, A: 255},
+	}
+)
+
+// Periods the wind rose can cover
+var windRosePeriods = []struct {
+	label  string
+	period time.Duration
+}{
+	{"Last hour", time.Hour},
+	{"Last 6 hours", 6 * time.Hour},
+	{"Last 24 hours", 24 * time.Hour},
+	{"Last 7 days", 7 * 24 * time.Hour},
+}
+
+var windRoseFlag bool = false // Wind rose window flag. If true, window is open.
+
+// addWind - Convert wind speeds reported in km/h or m/s to mph
+func (wd *WeatherData) addWind() {
+	convert := func(to string, set *float64) {
+		if _, ok := wd.Measurement(to); ok {
+			return
+		}
+		var v float64
+		if kmh, ok := wd.Measurement(to[:len(to)-4] + "km_h"); ok {
+			v = kmh * mphPerKmh
+		} else if ms, ok := wd.Measurement(to[:len(to)-4] + "m_s"); ok {
+			v = ms * mphPerMs
+		} else {
+			return
+		}
+		if wd.Fields == nil {
+			wd.Fields = make(map[string]bool)
+		}
+		*set = v
+		wd.Fields[to] = true
+	}
+	convert("wind_avg_mi_h", &wd.Wind_avg_mi_h)
+	convert("wind_max_mi_h", &wd.Wind_max_mi_h)
+}
+
+// addWindAverages - Add the 10 minute average speed and direction of a sensor's wind,
+// from its history and this reading, which arrived at time t
+func (wd *WeatherData) addWindAverages(key string, t time.Time) {
+	speed, ok := wd.Measurement("wind_avg_mi_h")
+	if !ok {
+		return
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	from := t.Add(-windAverageWindow)
+	speeds := append(historySeries(key, "wind_avg_mi_h", from, t), sample{t, speed})
+	dirs := historySeries(key, "wind_dir_deg", from, t)
+	if dir, ok := wd.Measurement("wind_dir_deg"); ok {
+		dirs = append(dirs, sample{t, dir})
+	}
+	sum := 0.0
+	for _, s := range speeds {
+		sum += s.v
+	}
+	if wd.Fields == nil {
+		wd.Fields = make(map[string]bool)
+	}
+	wd.WindAvg10m_mi_h = sum / float64(len(speeds))
+	wd.Fields["wind_avg_10m_mi_h"] = true
+	if dir, ok := averageDirection(dirs); ok {
+		wd.WindDir10m_deg = dir
+		wd.Fields["wind_dir_10m_deg"] = true
+	}
+}
+
+// averageDirection - Vector mean of directions in degrees, false if they cancel out
+func averageDirection(dirs []sample) (float64, bool) {
+	var x, y float64
+	for _, d := range dirs {
+		rad := d.v * math.Pi / 180
+		x += math.Sin(rad)
+		y += math.Cos(rad)
+	}
+	if math.Hypot(x, y) < 1e-9 {
+		return 0, false
+	}
+	return math.Mod(math.Atan2(x, y)*180/math.Pi+360, 360), true
+}
+
+// compassPoint - Nearest of the 16 compass points to a direction in degrees
+func compassPoint(deg float64) string {
+	return compassPoints[windSector(deg)]
+}
+
+// windSector - Index of the nearest compass point, 0 for north
+func windSector(deg float64) int {
+	return int(math.Mod(deg+windSectorDeg/2+360, 360)/windSectorDeg) % windRoseSectors
+}
+
+// formatWindLine - Short wind summary for the widget
+func formatWindLine(speed float64, dir float64, hasDir bool, gust float64) string {
+	str := fmt.Sprintf("Wind %.1f mph", speed)
+	if hasDir {
+		str = fmt.Sprintf("Wind %s %.1f mph", compassPoint(dir), speed)
+	}
+	return str + fmt.Sprintf(", gust %.1f today", gust)
+}
+
+// windGustToday - Peak gust of the day, from the gust or, if the sensor has none, the speed statistics
+func windGustToday(s *Sensor) float64 {
+	for _, name := range []string{"wind_max_mi_h", "wind_avg_mi_h"} {
+		if ms, ok := s.Stats[name]; ok && ms.Today.Count > 0 {
+			return ms.Today.Max
+		}
+	}
+	return 0
+}
+
+/******************************************
+ * Wind rose
+ ******************************************/
+
+// Counts of readings by direction sector and speed band
+type windRose struct {
+	counts [windRoseSectors][]int
+	calm   int
+	total  int
+}
+
+// newWindRose - Count the readings whose speed and direction samples were taken together
+func newWindRose(speeds []sample, dirs []sample) windRose {
+	var wr windRose
+	for i := range wr.counts {
+		wr.counts[i] = make([]int, len(windRoseBands))
+	}
+	dirAt := make(map[time.Time]float64, len(dirs))
+	for _, d := range dirs {
+		dirAt[d.t] = d.v
+	}
+	for _, s := range speeds {
+		dir, ok := dirAt[s.t]
+		if !ok {
+			continue
+		}
+		wr.total++
+		if s.v < windCalmMph {
+			wr.calm++
+			continue
+		}
+		band := 0
+		for band < len(windRoseBands)-1 && s.v >= windRoseBands[band] {
+			band++
+		}
+		wr.counts[windSector(dir)][band]++
+	}
+	return wr
+}
+
+// maxSector - Readings in the busiest direction
+func (wr *windRose) maxSector() int {
+	max := 0
+	for _, bands := range wr.counts {
+		n := 0
+		for _, c := range bands {
+			n += c
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// draw - Wind rose image: a wedge per direction, as long as the share of readings from
+// that direction and coloured by speed band from the centre out
+func (wr *windRose) draw(w int, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	max := wr.maxSector()
+	if max == 0 {
+		return img
+	}
+	cx, cy := float64(w)/2, float64(h)/2
+	radius := math.Min(cx, cy) - 2
+	gap := windSectorDeg * 0.1 // Space between wedges, in degrees
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			dx, dy := float64(px)-cx, cy-float64(py)
+			r := math.Hypot(dx, dy) / radius
+			if r > 1 {
+				continue
+			}
+			deg := math.Mod(math.Atan2(dx, dy)*180/math.Pi+360, 360)
+			sector := windSector(deg)
+			offset := math.Abs(math.Mod(deg-float64(sector)*windSectorDeg+540, 360) - 180)
+			if offset > windSectorDeg/2-gap/2 {
+				continue
+			}
+			edge := 0.0
+			for band, c := range wr.counts[sector] {
+				edge += float64(c) / float64(max)
+				if r <= edge {
+					img.Set(px, py, windRoseColors[band])
+					break
+				}
+			}
+		}
+	}
+	return img
+}
+
+// windRoseHandler - Opens a window charting a sensor's wind by direction and speed
+func windRoseHandler(key string) {
+	if windRoseFlag {
+		return
+	}
+	var keys, labels []string
+	activeSensorsMutex.Lock()
+	for _, k := range sortActiveSensors() {
+		s := activeSensors[k]
+		if _, ok := s.Stats["wind_avg_mi_h"]; ok {
+			keys = append(keys, k)
+			labels = append(labels, fmt.Sprintf("%s (%s)", s.Name, k))
+		}
+	}
+	activeSensorsMutex.Unlock()
+	if len(keys) == 0 {
+		SetStatus("No active sensor reports wind")
+		return
+	}
+	windRoseFlag = true
+
+	var wr windRose
+	selected, period := keys[0], windRosePeriods[2].period
+	for _, k := range keys {
+		if k == key {
+			selected = k
+		}
+	}
+	summary := widget.NewLabel("")
+	chart := canvas.NewRaster(func(w, h int) image.Image {
+		return wr.draw(w, h)
+	})
+	chart.SetMinSize(fyne.NewSize(400, 400))
+	update := func() {
+		now := time.Now()
+		wr = newWindRose(historySeries(selected, "wind_avg_mi_h", now.Add(-period), now),
+			historySeries(selected, "wind_dir_deg", now.Add(-period), now))
+		if wr.total == 0 {
+			summary.SetText("No wind readings with direction in this period")
+		} else {
+			summary.SetText(fmt.Sprintf("%d readings, %.0f%% calm. Top of chart is north.", wr.total, 100*float64(wr.calm)/float64(wr.total)))
+		}
+		chart.Refresh()
+	}
+
+	sensorSelect := widget.NewSelect(labels, func(label string) {
+		for i, l := range labels {
+			if l == label {
+				selected = keys[i]
+			}
+		}
+		update()
+	})
+	var periodLabels []string
+	for _, p := range windRosePeriods {
+		periodLabels = append(periodLabels, p.label)
+	}
+	periodSelect := widget.NewSelect(periodLabels, func(label string) {
+		for _, p := range windRosePeriods {
+			if p.label == label {
+				period = p.period
+			}
+		}
+		update()
+	})
+	legend := container.NewHBox()
+	low := 0.0
+	for band, high := range windRoseBands {
+		text := fmt.Sprintf("%.0f-%.0f mph", low, high)
+		if math.IsInf(high, 1) {
+			text = fmt.Sprintf("%.0f+ mph", low)
+		}
+		swatch := canvas.NewRectangle(windRoseColors[band])
+		swatch.SetMinSize(fyne.NewSize(12, 12))
+		legend.Add(container.NewCenter(swatch))
+		legend.Add(widget.NewLabel(text))
+		low = high
+	}
+	for i, k := range keys {
+		if k == selected {
+			sensorSelect.SetSelectedIndex(i)
+		}
+	}
+	periodSelect.SetSelectedIndex(2)
+
+	roseWindow := a.NewWindow("Wind Rose")
+	roseWindow.SetOnClosed(func() {
+		windRoseFlag = false
+	})
+	roseWindow.SetContent(container.NewBorder(
+		container.NewHBox(sensorSelect, periodSelect),
+		container.NewVBox(legend, summary), nil, nil, chart))
+	roseWindow.Show()
+}